@@ -85,5 +85,141 @@ func CreateSchema(DB *sql.DB, logger *log.Logger) error {
 	}
 
 	logger.Println("Таблица trackMeta успешно создана")
+
+	// Громкость трека по EBU R128, измеряется music-service при обработке загрузки
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS integrated_loudness DOUBLE PRECISION,
+       ADD COLUMN IF NOT EXISTS true_peak DOUBLE PRECISION,
+       ADD COLUMN IF NOT EXISTS loudness_range DOUBLE PRECISION;`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка обновления таблицы trackMeta: " + err.Error())
+		return fmt.Errorf("ошибка обновления таблицы trackMeta: %v", err)
+	}
+
+	// Акустические отпечатки для поиска повторных загрузок одного и того же звука.
+	// duplicate_of заполняется, если music-service принял совпавший трек с пометкой на проверку
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS duplicate_of INT;
+
+    CREATE TABLE IF NOT EXISTS trackFingerprints (
+       track_id INT PRIMARY KEY REFERENCES trackMeta(id) ON DELETE CASCADE,
+       fingerprint BYTEA NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS trackFingerprintHashes (
+       hash INT NOT NULL,
+       track_id INT NOT NULL REFERENCES trackMeta(id) ON DELETE CASCADE
+    );
+
+    CREATE INDEX IF NOT EXISTS trackFingerprintHashes_hash_idx ON trackFingerprintHashes (hash);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка создания таблиц отпечатков: " + err.Error())
+		return fmt.Errorf("ошибка создания таблиц отпечатков: %v", err)
+	}
+
+	// Версии звука трека: при замене HLS пишется в новую версию, предыдущая хранится для отката
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS audio_version INT NOT NULL DEFAULT 0,
+       ADD COLUMN IF NOT EXISTS previous_audio_version INT;
+
+    CREATE TABLE IF NOT EXISTS trackAudioVersions (
+       track_id INT NOT NULL REFERENCES trackMeta(id) ON DELETE CASCADE,
+       version INT NOT NULL,
+       duration INT NOT NULL,
+       integrated_loudness DOUBLE PRECISION,
+       true_peak DOUBLE PRECISION,
+       loudness_range DOUBLE PRECISION,
+       normalized_rendition BOOLEAN NOT NULL DEFAULT FALSE,
+       fingerprint BYTEA,
+       created_at TIMESTAMP NOT NULL DEFAULT now(),
+       PRIMARY KEY (track_id, version)
+    );`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка создания таблицы версий звука: " + err.Error())
+		return fmt.Errorf("ошибка создания таблицы версий звука: %v", err)
+	}
+
+	// trackMeta - источник истины для метаданных, хэш track<ID> в Redis только кэш.
+	// published становится TRUE, когда music-service закончил обработку загрузки.
+	// Строки, созданные до появления колонки, уже опубликованы, отсюда DEFAULT TRUE
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS normalized_rendition BOOLEAN NOT NULL DEFAULT FALSE,
+       ADD COLUMN IF NOT EXISTS published BOOLEAN NOT NULL DEFAULT TRUE;`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка обновления таблицы trackMeta: " + err.Error())
+		return fmt.Errorf("ошибка обновления таблицы trackMeta: %v", err)
+	}
+
+	// upload_id ключ идемпотентности загрузки: повтор с тем же ключом находит уже созданный трек.
+	// NULL в уникальном индексе не совпадают друг с другом, загрузки без ключа не ограничены
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS upload_id VARCHAR(64);
+
+    CREATE UNIQUE INDEX IF NOT EXISTS trackMeta_upload_id_idx ON trackMeta (owner, upload_id);`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка обновления таблицы trackMeta: " + err.Error())
+		return fmt.Errorf("ошибка обновления таблицы trackMeta: %v", err)
+	}
+
+	// Видимость трека: unlisted доступен по ссылке с JWT, private только владельцу.
+	// Подписанные ссылки на HLS выдаются после этой проверки
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS visibility VARCHAR(10) NOT NULL DEFAULT 'public'
+          CHECK (visibility IN ('public', 'unlisted', 'private'));`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка обновления таблицы trackMeta: " + err.Error())
+		return fmt.Errorf("ошибка обновления таблицы trackMeta: %v", err)
+	}
+
+	// Ключ AES-128 сегментов HLS, NULL у треков без шифрования. Один на все версии звука трека
+	query = `
+    ALTER TABLE trackMeta
+       ADD COLUMN IF NOT EXISTS hls_key BYTEA;`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка обновления таблицы trackMeta: " + err.Error())
+		return fmt.Errorf("ошибка обновления таблицы trackMeta: %v", err)
+	}
+
+	// Outbox событий жизненного цикла трека. Событие пишется в одной транзакции с изменением
+	// trackMeta, а relay в auth-service публикует его в Kafka и отмечает published_at.
+	// track_id без внешнего ключа: событие удаления переживает строку трека
+	query = `
+    CREATE TABLE IF NOT EXISTS trackOutbox (
+       id BIGSERIAL PRIMARY KEY,
+       event_type VARCHAR(20) NOT NULL,
+       track_id INT NOT NULL,
+       payload JSONB NOT NULL,
+       created_at TIMESTAMP NOT NULL DEFAULT now(),
+       published_at TIMESTAMP
+    );
+
+    CREATE INDEX IF NOT EXISTS trackOutbox_unpublished_idx ON trackOutbox (id) WHERE published_at IS NULL;`
+
+	_, err = DB.Exec(query)
+	if err != nil {
+		logger.Println("Ошибка создания таблицы trackOutbox: " + err.Error())
+		return fmt.Errorf("ошибка создания таблицы trackOutbox: %v", err)
+	}
+
 	return nil
 }
@@ -1,31 +1,34 @@
 package main
 
 import (
-	"aiartistprod/backend/auth-service/Kafka"
 	"aiartistprod/backend/auth-service/proto-gen-files"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
 	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
 const (
-	maxUploadSize  = 12 << 20 // 12MB (общий лимит формы)
-	maxMusicSize   = 10 << 20 // 10MB (музыкальный файл)
-	maxPictureSize = 2 << 20  // 2MB (изображение)
+	maxUploadSize   = 210 << 20 // 210MB (общий лимит формы)
+	maxMusicSize    = 200 << 20 // 200MB (музыкальный файл, передается потоком)
+	maxPictureSize  = 2 << 20   // 2MB (изображение)
+	maxMetaDataSize = 64 << 10  // 64KB (JSON метаданных)
+	uploadChunkSize = 256 << 10 // 256KB (кусок трека в UploadMusicStream)
 )
 
 var musicClient gen.MusicServiceClient
@@ -48,9 +51,28 @@ type TrackMeta struct {
 	// Количество лайков - не отправлять
 	Likes int `json:"likes"`
 	// Количество прослушиваний - не отправлять
-	Plays        int    `json:"plays"`
-	TrackPicture []byte `json:"track_picture"`
-	TrackID      int    `json:"track_id"`
+	Plays int `json:"plays"`
+	// Обложка байтами, только при include_picture=true
+	TrackPicture []byte `json:"track_picture,omitempty"`
+	// Ссылка на обложку (/trackpicture), относительно адреса auth-service
+	PictureURL string `json:"picture_url"`
+	TrackID    int    `json:"track_id"`
+	// Собрать дополнительную версию, нормализованную к -14 LUFS (master-normalized.m3u8)
+	NormalizeLoudness bool `json:"normalize_loudness,omitempty"`
+	// Шифровать сегменты HLS, ключ выдается через /trackkey
+	EncryptSegments bool `json:"encrypt_segments,omitempty"`
+	// Громкость по EBU R128 для коррекции громкости на клиенте - не отправлять
+	IntegratedLoudness float64 `json:"integrated_loudness"`
+	TruePeak           float64 `json:"true_peak"`
+	LoudnessRange      float64 `json:"loudness_range"`
+	// Есть нормализованная лестница, она играется по /streammusicsend?normalized=true - не отправлять
+	NormalizedRendition bool `json:"normalized_rendition"`
+	// Номер текущей версии звука, 0 - исходная загрузка - не отправлять
+	AudioVersion int `json:"audio_version"`
+	// Есть предыдущая версия звука для отката - не отправлять
+	RollbackAvailable bool `json:"rollback_available"`
+	// public, unlisted (по ссылке вошедшим пользователям) или private (только владельцу)
+	Visibility string `json:"visibility"`
 }
 
 type Artists struct {
@@ -112,7 +134,7 @@ func mainPage(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			trackMetaData, err := getTrackMetaFunc(ctx, trackID)
+			trackMetaData, err := getTrackMetaFunc(ctx, trackID, 0, false, "")
 			if err != nil {
 				logger.Println(err.Error())
 				http.Error(w, "Ошибка загрузки страницы", http.StatusInternalServerError)
@@ -146,7 +168,7 @@ func mainPage(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Ошибка загрузки страницы", http.StatusInternalServerError)
 			return
 		}
-		newTrackMeta, err := getTrackMetaFunc(ctx, trackID)
+		newTrackMeta, err := getTrackMetaFunc(ctx, trackID, 0, false, "")
 		fmt.Println(trackID)
 		if err != nil {
 			logger.Println(err.Error())
@@ -173,7 +195,7 @@ func mainPage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		popularTrackMeta, err := getTrackMetaFunc(ctx, popularTrackID)
+		popularTrackMeta, err := getTrackMetaFunc(ctx, popularTrackID, 0, false, "")
 		if err != nil {
 			logger.Println(err.Error())
 			http.Error(w, "Ошибка загрузки страницы", http.StatusInternalServerError)
@@ -259,16 +281,18 @@ func getTracksFromList(ctx context.Context, key string) ([]string, error) {
 // @Description Принимает от клиента трек и метаданные трека и отправляет через GRPC в БД
 // @Tags track
 // @Accept multipart/form-data
-// @Produce text/plain
+// @Produce application/json
 // @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
 // @Param refresh_token header string true "Refresh token из cookies"
-// @Param picture_file formData file true "Track cover image, тип multipart/form-data"
-// @Param track_file formData file true "Track audio file, тип multipart/form-data"
+// @Param Idempotency-Key header string false "Ключ загрузки до 64 символов: повтор запроса с тем же ключом возвращает уже принятый трек"
+// @Param picture_file formData file false "Track cover image, тип multipart/form-data. Без нее берется обложка из тегов файла"
+// @Param track_file formData file true "Track audio file (.mp3, .wav или .flac), тип multipart/form-data"
 // @Param meta_data formData string true "Метадата трека согласно TrackMeta структуры in JSON format, тип multipart/form-data"
-// @Success 200 {string} string "Your track has been uploaded successfully"
+// @Success 202 {object} UploadResult "Трек принят, статус обработки - через /uploadstatus"
 // @Failure 400 {string} string "Bad Request - Invalid input, missing required data, file too large, file type error, or metadata retrieval error"
 // @Failure 401 {string} string "Unauthorized - Invalid or expired token"
 // @Failure 405 {string} string "Method Not Allowed - Use POST"
+// @Failure 409 {string} string "Conflict - Track duplicates an already uploaded track"
 // @Failure 415 {string} string "Unsupported Media Type - Must be multipart/form-data"
 // @Failure 413 {string} string "Request Entity Too Large - Exceeds file size limit"
 // @Failure 500 {string} string "Internal Server Error - Unexpected issue"
@@ -294,84 +318,212 @@ func uploadMusicHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = r.ParseMultipartForm(maxUploadSize)
-	if err != nil {
-		logger.Println("The body of upload music request is too large:", err)
-		http.Error(w, "The body of upload music request is too large", http.StatusBadRequest)
+	// Повтор после обрыва соединения с тем же ключом не создаст второй трек
+	uploadID := r.Header.Get("Idempotency-Key")
+	if len(uploadID) > 64 {
+		http.Error(w, "Idempotency-Key длиннее 64 символов", http.StatusBadRequest)
 		return
 	}
 
-	musicFile, musicHeader, err := r.FormFile("track_file")
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Части формы читаются по очереди, трек не собирается в памяти целиком
+	multipartReader, err := r.MultipartReader()
 	if err != nil {
-		logger.Println("Error getting music file after parsing:", err)
-		http.Error(w, "Error getting music file after parsing", http.StatusBadRequest)
+		logger.Println("Error reading multipart body:", err)
+		http.Error(w, "Error reading multipart body", http.StatusBadRequest)
 		return
 	}
-	defer musicFile.Close()
 
-	if musicHeader.Size > maxMusicSize {
-		logger.Println("Music file is too large")
-		http.Error(w, "Music file is too large", http.StatusBadRequest)
-		return
-	}
+	var trackMeta *TrackMeta
+	var pictureFileData []byte
+	var spooledTrack *os.File
+	var uploadResp *gen.UploadMusicResponse
+	trackSent := false
 
-	pictureFile, pictureHeader, err := r.FormFile("picture_file")
-	if err != nil {
-		logger.Println("Error getting picture file after parsing:", err)
-		http.Error(w, "Error getting picture file after parsing", http.StatusBadRequest)
-		return
-	}
-	defer pictureFile.Close()
+	defer func() {
+		if spooledTrack != nil {
+			spooledTrack.Close()
+			os.Remove(spooledTrack.Name())
+		}
+	}()
 
-	if pictureHeader.Size > maxPictureSize {
-		logger.Println("Picture file is too large")
-		http.Error(w, "Picture file is too large", http.StatusBadRequest)
-		return
-	}
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Println("The body of upload music request is too large:", err)
+			http.Error(w, "The body of upload music request is too large", http.StatusBadRequest)
+			return
+		}
 
-	musicFileData, err := io.ReadAll(musicFile)
-	if err != nil {
-		http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
-		return
-	}
+		switch part.FormName() {
+		case "meta_data":
+			metaData, err := io.ReadAll(io.LimitReader(part, maxMetaDataSize))
+			if err != nil || len(metaData) == 0 {
+				http.Error(w, "Getting metaData error", http.StatusBadRequest)
+				return
+			}
 
-	if !strings.HasSuffix(musicHeader.Filename, ".mp3") &&
-		!strings.HasSuffix(musicHeader.Filename, ".MP3") {
-		http.Error(w, "File type error", http.StatusBadRequest)
-		return
-	}
+			trackMeta = &TrackMeta{}
+			err = json.Unmarshal(metaData, trackMeta)
+			if err != nil {
+				http.Error(w, "Getting metaData error", http.StatusBadRequest)
+				return
+			}
 
-	pictureFileData, err := io.ReadAll(pictureFile)
-	if err != nil {
-		http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
-		return
+		case "picture_file":
+			// Содержимое проверяет music-service при декодировании, здесь отсекаются явно чужие файлы
+			if !strings.HasSuffix(part.FileName(), ".jpeg") &&
+				!strings.HasSuffix(part.FileName(), ".jpg") &&
+				!strings.HasSuffix(part.FileName(), ".png") {
+				http.Error(w, "File type error", http.StatusBadRequest)
+				return
+			}
+
+			pictureFileData, err = io.ReadAll(io.LimitReader(part, maxPictureSize+1))
+			if err != nil {
+				http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+				return
+			}
+
+			if len(pictureFileData) > maxPictureSize {
+				logger.Println("Picture file is too large")
+				http.Error(w, "Picture file is too large", http.StatusBadRequest)
+				return
+			}
+
+		case "track_file":
+			if !isTrackFileName(part.FileName()) {
+				http.Error(w, "File type error", http.StatusBadRequest)
+				return
+			}
+
+			// Если метаданные уже пришли, трек сразу уходит в music-service,
+			// иначе он дожидается их во временном файле. Обложка необязательна (берется из тегов файла),
+			// но если она идет после трека, трек тоже придется отложить
+			if trackMeta != nil && pictureFileData != nil {
+				uploadResp, err = uploadTrackStream(r.Context(), newUploadMusicRequest(trackMeta, pictureFileData, claims.Username, uploadID), part)
+				if err != nil {
+					sendUploadError(w, err)
+					return
+				}
+				trackSent = true
+				break
+			}
+
+			spooledTrack, err = os.CreateTemp("", "upload_*"+strings.ToLower(filepath.Ext(part.FileName())))
+			if err != nil {
+				logger.Println("Error creating temp file for track:", err)
+				http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+				return
+			}
+
+			_, err = io.Copy(spooledTrack, part)
+			if err != nil {
+				logger.Println("Error saving track to temp file:", err)
+				http.Error(w, "Ошибка чтения файла", http.StatusBadRequest)
+				return
+			}
+		}
+
+		part.Close()
 	}
 
-	if !strings.HasSuffix(pictureHeader.Filename, ".jpeg") &&
-		!strings.HasSuffix(pictureHeader.Filename, ".jpg") {
-		http.Error(w, "File type error", http.StatusBadRequest)
+	if trackMeta == nil {
+		http.Error(w, "Getting metaData error", http.StatusBadRequest)
 		return
 	}
 
-	currentTime := timestamppb.New(time.Now())
+	if !trackSent {
+		if spooledTrack == nil {
+			logger.Println("Error getting music file after parsing")
+			http.Error(w, "Error getting music file after parsing", http.StatusBadRequest)
+			return
+		}
 
-	trackMeta := &TrackMeta{}
+		_, err = spooledTrack.Seek(0, io.SeekStart)
+		if err != nil {
+			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+			return
+		}
 
-	metaData := r.FormValue("meta_data")
-	if metaData == "" {
-		http.Error(w, "Getting metaData error", http.StatusBadRequest)
-		return
+		uploadResp, err = uploadTrackStream(r.Context(), newUploadMusicRequest(trackMeta, pictureFileData, claims.Username, uploadID), spooledTrack)
+		if err != nil {
+			sendUploadError(w, err)
+			return
+		}
 	}
 
-	err = json.Unmarshal([]byte(metaData), &trackMeta)
+	// Событие о новом треке отправляет trackOutboxRelay, когда music-service его опубликует
+	sendUploadResult(w, uploadResp)
+}
+
+// UploadResult ответ на загрузку трека: трек принят и обрабатывается, готовность - через /uploadstatus
+type UploadResult struct {
+	Message string `json:"message"`
+	TrackID int    `json:"track_id"`
+	// Статус обработки: queued, processing, ready или failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ID трека с тем же звуком, если загрузка принята с пометкой на проверку
+	DuplicateOf int `json:"duplicate_of,omitempty"`
+}
+
+func sendUploadResult(w http.ResponseWriter, uploadResp *gen.UploadMusicResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	err := json.NewEncoder(w).Encode(UploadResult{
+		Message:     "Your track has been uploaded successfully",
+		TrackID:     int(uploadResp.GetTrackID()),
+		Status:      uploadResp.GetStatus(),
+		DuplicateOf: int(uploadResp.GetDuplicateOf()),
+	})
 	if err != nil {
-		http.Error(w, "Getting metaData error", http.StatusBadRequest)
-		return
+		logger.Println("error sending upload result:", err)
 	}
+}
+
+// sendUploadError отвечает на неудачную загрузку. Совпадение с уже загруженным треком - 409
+// с ID этого трека в тексте ошибки, неполные метаданные - 400, остальное - 500
+func sendUploadError(w http.ResponseWriter, err error) {
+	sendTrackError(w, err, "Failed to upload music")
+}
 
-	fmt.Println(trackMeta)
-	// тут реализовать добавку метаданных
-	_, err = musicClient.UploadMusic(context.Background(), &gen.UploadMusicRequest{
+// sendTrackError переводит gRPC статус ошибки music-service в HTTP код, prefix - что не получилось
+func sendTrackError(w http.ResponseWriter, err error, prefix string) {
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.AlreadyExists, codes.FailedPrecondition, codes.Aborted:
+			http.Error(w, prefix+": "+st.Message(), http.StatusConflict)
+			return
+		case codes.InvalidArgument:
+			http.Error(w, prefix+": "+st.Message(), http.StatusBadRequest)
+			return
+		case codes.NotFound:
+			http.Error(w, prefix+": "+st.Message(), http.StatusNotFound)
+			return
+		case codes.PermissionDenied:
+			http.Error(w, prefix+": "+st.Message(), http.StatusForbidden)
+			return
+		case codes.Unauthenticated:
+			http.Error(w, prefix+": "+st.Message(), http.StatusUnauthorized)
+			return
+		case codes.OutOfRange:
+			http.Error(w, prefix+": "+st.Message(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+	http.Error(w, prefix+": "+err.Error(), http.StatusInternalServerError)
+}
+
+// newUploadMusicRequest метаданные загрузки для music-service. uploadID делает повтор загрузки
+// идемпотентным, пустой - каждая загрузка создает новый трек
+func newUploadMusicRequest(trackMeta *TrackMeta, pictureFileData []byte, owner, uploadID string) *gen.UploadMusicRequest {
+	return &gen.UploadMusicRequest{
 		ArtistName:   trackMeta.ArtistName,
 		Title:        trackMeta.Title,
 		AlbumName:    trackMeta.AlbumName,
@@ -379,177 +531,230 @@ func uploadMusicHandler(w http.ResponseWriter, r *http.Request) {
 		Description:  trackMeta.Description,
 		Duration:     0,
 		ReleaseYear:  int32(trackMeta.ReleaseYear),
-		AddToDbDate:  currentTime,
+		AddToDbDate:  timestamppb.New(time.Now()),
 		TrackPicture: pictureFileData,
-		MusicContent: musicFileData,
-		Owner:        claims.Username,
-	})
-	if err != nil {
-		http.Error(w, "Failed to upload music: "+err.Error(), http.StatusInternalServerError)
-		return
+		Owner:        owner,
+
+		NormalizeLoudness: trackMeta.NormalizeLoudness,
+		EncryptSegments:   trackMeta.EncryptSegments,
+		UploadId:          uploadID,
 	}
-	/////вызов кафка пользователь добавил трек
-	msgText := fmt.Sprintf("Artist %s has uploaded track %s", trackMeta.ArtistName, trackMeta.Title)
+}
 
-	_, err = Kafka.SendMsg(producer, "users_events", msgText, logger)
+// uploadTrackStream отправляет метаданные первым сообщением UploadMusicStream,
+// а затем передает трек кусками по uploadChunkSize
+func uploadTrackStream(ctx context.Context, meta *gen.UploadMusicRequest, track io.Reader) (*gen.UploadMusicResponse, error) {
+	stream, err := musicClient.UploadMusicStream(ctx)
 	if err != nil {
-		logger.Println("Failed to send message to Kafka:", err)
+		logger.Println("Error opening upload stream:", err)
+		return nil, err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Your track has been uploaded successfully"))
-}
-
-// streamMusicHandler Выполняет потоковый стриминг трека из GRPC клиенту
-// @Summary Выполняет потоковый стриминг трека из GRPC клиенту (отправка данных со страницы /streammusic)
-// @Description Принимает от клиента имя исполнителя, название трека и стартовую позицию воспроизведения и по этим данным начинает воспроизведение
-// @Tags track
-// @Accept text/plain
-// @Produce application/octet-stream
-// @Param artist_name query string true "Имя артиста"
-// @Param title query string true "Название трека"
-// @Param startPosition query int true "Стартовая позиция воспроизведения в секундах"
-// @Success 200 {array} []byte "Audio stream, поток []byte данных трека"
-// @Failure 500 {string} string "Internal Server Error: invalid startPosition, streaming music error, error getting metadata, error streaming music"
-// @Router /streammusicsend [GET]
-func streamMusicHandler(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
-	trackID := r.URL.Query().Get("trackID")
-	startPosition := r.URL.Query().Get("startPosition")
-	startPosition = strings.TrimSpace(startPosition)
-
-	startPositionInt, err := strconv.Atoi(startPosition)
-	if err != nil {
-		http.Error(w, "Invalid startPosition: "+err.Error(), http.StatusInternalServerError)
-		return
+	err = stream.Send(&gen.UploadMusicChunk{Payload: &gen.UploadMusicChunk_Meta{Meta: meta}})
+	if err != nil {
+		logger.Println("Error sending track metadata:", err)
+		return nil, err
 	}
 
-	stream, err := musicClient.StreamMusic(context.Background(), &gen.StreamMusicRequest{
-		Username:      username,
-		TrackId:       trackID,
-		StartPosition: int64(startPositionInt),
-	})
+	err = sendTrackChunks(stream, track)
+	if errors.Is(err, errTrackStreamBroken) {
+		// настоящая причина обрыва приходит в CloseAndRecv
+		_, err = stream.CloseAndRecv()
+		return nil, err
+	}
 	if err != nil {
-		http.Error(w, "Streaming music error: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	md, err := stream.Header()
+	resp, err := stream.CloseAndRecv()
 	if err != nil {
-		http.Error(w, "Error getting metadata", http.StatusInternalServerError)
-		logger.Println("Error getting metadata:", err)
-		return
+		logger.Println("Error uploading track:", err)
+		return nil, err
 	}
 
-	musicDuration := md.Get("music-duration")
-	if len(musicDuration) > 0 {
-		logger.Println("music-duration received", musicDuration)
-		w.Header().Set("Music-Duration", musicDuration[0])
-	} else {
-		logger.Println("music-duration metadata not found")
+	return resp, nil
+}
+
+// errTrackStreamBroken music-service оборвал поток, причину нужно забрать через CloseAndRecv
+var errTrackStreamBroken = errors.New("track stream is broken")
+
+// sendTrackChunks передает трек кусками по uploadChunkSize в UploadMusicStream или ProbeUpload
+func sendTrackChunks(stream interface {
+	Send(*gen.UploadMusicChunk) error
+	CloseSend() error
+}, track io.Reader) error {
+	buf := make([]byte, uploadChunkSize)
+	var total int64
+	for {
+		n, err := track.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			if total > maxMusicSize {
+				stream.CloseSend()
+				return errors.New("music file is too large")
+			}
+
+			errSend := stream.Send(&gen.UploadMusicChunk{Payload: &gen.UploadMusicChunk_MusicChunk{MusicChunk: buf[:n]}})
+			if errSend != nil {
+				logger.Println("Error sending track chunk:", errSend)
+				return errTrackStreamBroken
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Println("Error reading track file:", err)
+			stream.CloseSend()
+			return err
+		}
 	}
 
-	upgradeToWebsocket := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	if total == 0 {
+		stream.CloseSend()
+		return errors.New("music file is empty")
 	}
 
-	conn, err := upgradeToWebsocket.Upgrade(w, r, nil)
-	if err != nil {
-		logger.Printf("Websocker connection error: %v\n", err)
+	return nil
+}
+
+// ProbeResult метаданные и обложка из тегов файла для предзаполнения формы загрузки
+type ProbeResult struct {
+	ArtistName   string `json:"artist_name"`
+	Title        string `json:"title"`
+	AlbumName    string `json:"album_name"`
+	Genre        string `json:"genre"`
+	Description  string `json:"description"`
+	ReleaseYear  int    `json:"release_year"`
+	Duration     int    `json:"duration"`
+	TrackPicture []byte `json:"track_picture"`
+}
+
+// probeUploadHandler читает теги трека до загрузки
+// @Summary Прочитать теги трека до загрузки
+// @Description Принимает аудиофайл и возвращает найденные в его тегах (ID3, Vorbis comments, MP4) метаданные и встроенную обложку. Трек не сохраняется
+// @Tags track
+// @Accept multipart/form-data
+// @Produce json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param track_file formData file true "Track audio file (.mp3, .wav или .flac), тип multipart/form-data"
+// @Success 200 {object} ProbeResult
+// @Failure 400 {string} string "Bad Request - Missing track file, file type error or not an audio file"
+// @Failure 405 {string} string "Method Not Allowed - Use POST"
+// @Failure 415 {string} string "Unsupported Media Type - Must be multipart/form-data"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /probeupload [post]
+// @Security CookieAuth
+// @Security BearerAuth
+func probeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Недопустимый метод запроса", http.StatusMethodNotAllowed)
 		return
 	}
-	defer conn.Close()
 
-	var firstTime time.Time
+	_, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		http.Error(w, "Авторизуйтесь: "+err.Error(), http.StatusBadRequest)
+		logger.Println("Авторизуйтесь снова:", err)
+		return
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" || !strings.HasPrefix(contentType, "multipart/form-data") {
+		http.Error(w, "Отсутствует заголовок multipart/form-data", http.StatusUnsupportedMediaType)
+		return
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
-	go func() {
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		logger.Println("Error reading multipart body:", err)
+		http.Error(w, "Error reading multipart body", http.StatusBadRequest)
+		return
+	}
 
-		firstTime = time.Now()
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Println("The body of probe request is too large:", err)
+			http.Error(w, "The body of probe request is too large", http.StatusBadRequest)
+			return
+		}
 
-		for {
+		if part.FormName() != "track_file" {
+			part.Close()
+			continue
+		}
 
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				resp, err := stream.Recv()
-				if err == io.EOF {
-					logger.Println("End of streaming file")
-					conn.Close()
-					cancel()
-					return
-				}
-				if err != nil {
-					logger.Printf("Error streaming music: %v\n", err)
-					conn.Close()
-					cancel()
-					return
-				}
+		if !isTrackFileName(part.FileName()) {
+			http.Error(w, "File type error", http.StatusBadRequest)
+			return
+		}
 
-				err = conn.WriteMessage(websocket.BinaryMessage, resp.Data)
-				if err != nil {
-					logger.Printf("Ошибка отправки по WebSocket: %v\n", err)
-					conn.Close()
-					cancel()
-					return
-				}
-			}
+		res, err := probeTrackStream(r.Context(), part)
+		if err != nil {
+			sendUploadError(w, err)
+			return
 		}
-	}()
 
-	go func() {
-		defer wg.Done()
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(ProbeResult{
+			ArtistName:   res.ArtistName,
+			Title:        res.Title,
+			AlbumName:    res.AlbumName,
+			Genre:        res.Genre,
+			Description:  res.Description,
+			ReleaseYear:  int(res.ReleaseYear),
+			Duration:     int(res.Duration),
+			TrackPicture: res.TrackPicture,
+		})
+		if err != nil {
+			logger.Printf("Ошибка сериализации JSON: %v\n", err)
+		}
+		return
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				logger.Println("Received cancel in reading goroutine")
-				return
-			default:
-				//conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-				msgType, msg, err := conn.ReadMessage()
+	logger.Println("Error getting music file after parsing")
+	http.Error(w, "Error getting music file after parsing", http.StatusBadRequest)
+}
 
-				if err != nil {
-					/*	var netError net.Error
-						if errors.As(err, &netError) && netError.Timeout() {
-							logger.Println("End of timeout")
-							continue
-						}*/
-
-					logger.Printf("Ошибка при чтении из WebSocket: %v\n", err)
-					cancel()
-					return
-				}
+func probeTrackStream(ctx context.Context, track io.Reader) (*gen.ProbeUploadResponse, error) {
+	stream, err := musicClient.ProbeUpload(ctx)
+	if err != nil {
+		logger.Println("Error opening probe stream:", err)
+		return nil, err
+	}
 
-				if msgType == websocket.TextMessage {
-					if string(msg) == "played_60_sec" {
+	err = sendTrackChunks(stream, track)
+	if errors.Is(err, errTrackStreamBroken) {
+		_, err = stream.CloseAndRecv()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
 
-						secondTime := time.Now()
-						timeValue := secondTime.Sub(firstTime)
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		logger.Println("Error probing track:", err)
+		return nil, err
+	}
 
-						if timeValue.Seconds() > 55 {
+	return resp, nil
+}
 
-							firstTime = time.Now()
-							playsIncr(username, trackID)
-						}
-					} else if string(msg) == "finish" {
-						cancel()
-						return
-					}
-				}
-			}
-		}
-	}()
+// trackFileExtensions форматы, которые принимает загрузка. Это только отсев по имени файла:
+// содержимое проверяет ffprobe в music-service
+var trackFileExtensions = map[string]bool{".mp3": true, ".wav": true, ".flac": true}
 
-	wg.Wait()
+// isTrackFileName проверяет расширение файла трека без учета регистра
+func isTrackFileName(name string) bool {
+	return trackFileExtensions[strings.ToLower(filepath.Ext(name))]
 }
 
 func playsIncr(username, trackID string) {
@@ -566,14 +771,28 @@ func playsIncr(username, trackID string) {
 		logger.Println("Ошибка при увеличении прослушивания в хэше")
 	}
 
-	err = rdb.ZIncrBy(ctx, "plays", 1, "track"+trackID).Err()
-	if err != nil {
-		logger.Println("Ошибка при увеличении прослушивания в sorted set likes")
+	hashPlays, hashErr := rdb.HIncrBy(ctx, "track"+trackID, "plays", 1).Result()
+	if hashErr != nil {
+		logger.Println("Ошибка при увеличении прослушивания в хэше track")
 	}
 
-	err = rdb.HIncrBy(ctx, "track"+trackID, "plays", 1).Err()
+	// XX: unlisted и private треков нет в общем рейтинге, и прослушивание не должно их туда вернуть.
+	// Для них счетчик ведется только в хэше трека
+	trackPlays, err := rdb.ZAddArgsIncr(ctx, "plays", redis.ZAddArgs{
+		XX:      true,
+		Members: []redis.Z{{Score: 1, Member: "track" + trackID}},
+	}).Result()
+	if errors.Is(err, redis.Nil) && hashErr == nil {
+		trackPlays, err = float64(hashPlays), nil
+	}
 	if err != nil {
-		logger.Println("Ошибка при увеличении прослушивания в хэше track")
+		logger.Println("Ошибка при увеличении прослушивания в sorted set plays")
+	} else {
+		// Postgres - источник для пересборки рейтингов, если Redis потеряет данные
+		_, err = DB.ExecContext(ctx, `UPDATE trackMeta SET plays = GREATEST(plays, $1) WHERE id = $2`, int64(trackPlays), trackID)
+		if err != nil {
+			logger.Println("Ошибка сохранения прослушиваний трека в БД: " + err.Error())
+		}
 	}
 }
 
@@ -583,10 +802,17 @@ func playsIncr(username, trackID string) {
 // @Tags track
 // @Accept json
 // @Produce json
+// @Param Authorization header string false "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков"
+// @Param refresh_token header string false "Refresh token из cookies"
 // @Param track_id query int true "ID трека"
+// @Param picture_size query int false "Сторона обложки в пикселях: 64, 300 (по умолчанию) или 1000"
+// @Param include_picture query bool false "Вернуть байты обложки в track_picture помимо picture_url"
 // @Success 200 {object} TrackMeta
-// @Failure 400 {string} string "Bad Request - Empty trackID, trackID error, metadata retrieval error, or trackMeta serialization error"
+// @Failure 400 {string} string "Bad Request - Empty trackID, trackID error or picture_size error"
+// @Failure 401 {string} string "Authorization required - unlisted or private track"
+// @Failure 404 {string} string "Track not found"
 // @Failure 405 {string} string "Method Not Allowed - Invalid request method"
+// @Failure 500 {string} string "Internal Server Error - metadata retrieval or trackMeta serialization error"
 // @Router /gettrackmetasend [get]
 func getMetaHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -608,10 +834,22 @@ func getMetaHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trackMeta, err := getTrackMetaFunc(r.Context(), trackID)
+	pictureSize := 0
+	if pictureSizeString := r.URL.Query().Get("picture_size"); pictureSizeString != "" {
+		pictureSize, err = strconv.Atoi(pictureSizeString)
+		if err != nil || pictureSize < 0 {
+			logger.Printf("picture_size error: %v\n", err)
+			http.Error(w, "picture_size error", http.StatusBadRequest)
+			return
+		}
+	}
+
+	includePicture, _ := strconv.ParseBool(r.URL.Query().Get("include_picture"))
+
+	trackMeta, err := getTrackMetaFunc(r.Context(), trackID, pictureSize, includePicture, requestListener(w, r))
 	if err != nil {
-		logger.Printf("getting meta error: %v\n", err)
-		http.Error(w, "getting meta error: "+err.Error(), http.StatusBadRequest)
+		sendTrackError(w, err, "getting meta error")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -624,16 +862,236 @@ func getMetaHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getTrackMetaFunc(ctx context.Context, trackID int) (*TrackMeta, error) {
-	res, err := musicClient.GetMeta(ctx, &gen.GetMetaRequest{TrackId: int32(trackID)})
+// uploadStatusHandler возвращает статус обработки загруженного трека
+// @Summary Статус обработки загруженного трека
+// @Description Возвращает статус HLS-конвертации трека владельцу: queued, processing, ready или failed с текстом ошибки
+// @Tags track
+// @Produce json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param track_id query int true "ID трека"
+// @Success 200 {object} UploadResult
+// @Failure 400 {string} string "Bad Request - Empty trackID or trackID error"
+// @Failure 403 {string} string "Forbidden - not the owner of this track"
+// @Failure 404 {string} string "Track not found"
+// @Failure 405 {string} string "Method Not Allowed - Invalid request method"
+// @Router /uploadstatus [get]
+// @Security CookieAuth
+// @Security BearerAuth
+func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Недопустимый метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Println(err)
+		return
+	}
+
+	trackID, err := strconv.Atoi(r.URL.Query().Get("track_id"))
+	if err != nil {
+		logger.Printf("trackID error: %v\n", err)
+		http.Error(w, "trackID error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := musicClient.GetUploadStatus(r.Context(), &gen.GetUploadStatusRequest{TrackId: int32(trackID)})
+	if err != nil {
+		logger.Printf("getting upload status error: %v\n", err)
+		http.Error(w, "track not found", http.StatusNotFound)
+		return
+	}
+
+	if res.Owner != claims.Username {
+		logger.Println("you are not the owner of this track")
+		http.Error(w, "you are not the owner of this track", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(UploadResult{
+		TrackID: int(res.TrackID),
+		Status:  res.Status,
+		Error:   res.Error,
+	})
+	if err != nil {
+		logger.Printf("Ошибка сериализации JSON: %v\n", err)
+	}
+}
+
+// Waveform пики волны трека для отрисовки в плеере
+type Waveform struct {
+	TrackID    int     `json:"track_id"`
+	Resolution int     `json:"resolution"`
+	Duration   float64 `json:"duration"`
+	// Чередующиеся min,max в диапазоне -128..127, resolution пар на весь трек
+	Peaks                []int32 `json:"peaks"`
+	AvailableResolutions []int32 `json:"available_resolutions"`
+}
+
+// getWaveformHandler возвращает пики волны трека по его ID
+// @Summary Получить волну трека
+// @Description Возвращает пики min/max волны трека. Отдается ближайшее сохраненное разрешение не меньше запрошенного, без resolution - наибольшее
+// @Tags track
+// @Produce json
+// @Param Authorization header string false "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков"
+// @Param refresh_token header string false "Refresh token из cookies"
+// @Param track_id query int true "ID трека"
+// @Param resolution query int false "Желаемое количество пар min/max"
+// @Success 200 {object} Waveform
+// @Failure 400 {string} string "Bad Request - Empty trackID, trackID error or resolution error"
+// @Failure 401 {string} string "Authorization required - unlisted or private track"
+// @Failure 404 {string} string "Waveform not found"
+// @Failure 405 {string} string "Method Not Allowed - Invalid request method"
+// @Router /gettrackwaveform [get]
+func getWaveformHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Недопустимый метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	trackIDString := r.URL.Query().Get("track_id")
+	if trackIDString == "" {
+		logger.Println("Пустой trackID")
+		http.Error(w, "Пустой trackID", http.StatusBadRequest)
+		return
+	}
+
+	trackID, err := strconv.Atoi(trackIDString)
+	if err != nil {
+		logger.Printf("trackID error: %v\n", err)
+		http.Error(w, "trackID error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resolution := 0
+	if resolutionString := r.URL.Query().Get("resolution"); resolutionString != "" {
+		resolution, err = strconv.Atoi(resolutionString)
+		if err != nil || resolution < 0 {
+			logger.Printf("resolution error: %v\n", err)
+			http.Error(w, "resolution error", http.StatusBadRequest)
+			return
+		}
+	}
+
+	listener := requestListener(w, r)
+
+	res, err := musicClient.GetWaveform(r.Context(), &gen.GetWaveformRequest{
+		TrackId:    int32(trackID),
+		Resolution: int32(resolution),
+		Listener:   listener,
+	})
+	if err != nil {
+		logger.Printf("getting waveform error: %v\n", err)
+		if status.Code(err) == codes.Unauthenticated {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "waveform not found", http.StatusNotFound)
+		return
+	}
+
+	// Волна перестраивается при замене звука трека, поэтому кэшируется ненадолго.
+	// Ответ вошедшему пользователю может содержать закрытый трек - общим кэшам его не отдаем
+	if listener != "" {
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(Waveform{
+		TrackID:              int(res.TrackID),
+		Resolution:           int(res.Resolution),
+		Duration:             res.Duration,
+		Peaks:                res.Peaks,
+		AvailableResolutions: res.AvailableResolutions,
+	})
+	if err != nil {
+		logger.Printf("Ошибка сериализации JSON: %v\n", err)
+	}
+}
+
+// getTrackMetaFunc метаданные трека со ссылкой на обложку стороной pictureSize (0 - размер по умолчанию, 300).
+// Сами байты обложки запрашиваются только при includePicture. listener - вошедший пользователь или пустая
+// строка: unlisted и private треки music-service отдает только тем, кому они доступны
+func getTrackMetaFunc(ctx context.Context, trackID, pictureSize int, includePicture bool, listener string) (*TrackMeta, error) {
+	res, err := musicClient.GetMeta(ctx, &gen.GetMetaRequest{
+		TrackId:        int32(trackID),
+		PictureSize:    int32(pictureSize),
+		IncludePicture: includePicture,
+		Listener:       listener,
+	})
 	if err != nil {
 		logger.Printf("getting meta error: %v\n", err)
-		return nil, errors.New("getting meta error: " + err.Error())
+		return nil, err
+	}
+
+	return trackMetaFromResponse(res), nil
+}
+
+// trackPlayback поля хэша track<ID>, по которым отдаются HLS и обложка. Отсутствующее в хэше
+// поле остается пустой строкой: пустая visibility - публичный трек, пустой previousAudioVersion -
+// отката нет
+type trackPlayback struct {
+	TrackID              string `redis:"trackID"`
+	Owner                string `redis:"owner"`
+	Visibility           string `redis:"visibility"`
+	AudioVersion         string `redis:"audioVersion"`
+	PreviousAudioVersion string `redis:"previousAudioVersion"`
+}
+
+// trackPlaybackInfo владелец, видимость и версии звука трека из кэша track<ID>.
+// Кэш заполняет music-service: если хэша нет (истек TTL), GetMeta перечитывает трек из Postgres,
+// и поля запрашиваются еще раз. owner - владелец из запроса, от его имени music-service
+// читает и закрытые треки; доступ слушателя проверяет вызывающий
+func trackPlaybackInfo(ctx context.Context, trackID, owner string) (*trackPlayback, error) {
+	info, err := readTrackPlayback(ctx, trackID)
+	if err != nil {
+		return nil, err
+	}
+	if info.TrackID != "" {
+		return info, nil
+	}
+
+	trackIDInt, err := strconv.Atoi(trackID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = musicClient.GetMeta(ctx, &gen.GetMetaRequest{TrackId: int32(trackIDInt), Listener: owner})
+	if err != nil {
+		logger.Println("getting track meta error: " + err.Error())
+		return nil, err
 	}
 
+	info, err = readTrackPlayback(ctx, trackID)
+	if err != nil {
+		return nil, err
+	}
+	if info.TrackID == "" {
+		return nil, status.Errorf(codes.NotFound, "трек %s не найден", trackID)
+	}
+
+	return info, nil
+}
+
+func readTrackPlayback(ctx context.Context, trackID string) (*trackPlayback, error) {
+	info := &trackPlayback{}
+	err := rdb.HMGet(ctx, "track"+trackID, "trackID", "owner", "visibility", "audioVersion", "previousAudioVersion").Scan(info)
+	if err != nil {
+		logger.Println("getting track playback info error: " + err.Error())
+		return nil, err
+	}
+	return info, nil
+}
+
+func trackMetaFromResponse(res *gen.GetMetaResponse) *TrackMeta {
 	addToDBDate := res.AddToDbDate.AsTime().Format(time.RFC3339)
 
-	trackMeta := &TrackMeta{
+	return &TrackMeta{
 		ArtistName:   res.ArtistName,
 		Title:        res.Title,
 		AlbumName:    res.AlbumName,
@@ -646,8 +1104,15 @@ func getTrackMetaFunc(ctx context.Context, trackID int) (*TrackMeta, error) {
 		Likes:        int(res.Likes),
 		Plays:        int(res.Plays),
 		TrackPicture: res.TrackPicture,
+		PictureURL:   res.PictureUrl,
 		TrackID:      int(res.TrackID),
-	}
 
-	return trackMeta, nil
+		IntegratedLoudness:  res.IntegratedLoudness,
+		TruePeak:            res.TruePeak,
+		LoudnessRange:       res.LoudnessRange,
+		NormalizedRendition: res.NormalizedRendition,
+		AudioVersion:        int(res.AudioVersion),
+		RollbackAvailable:   res.RollbackAvailable,
+		Visibility:          res.Visibility,
+	}
 }
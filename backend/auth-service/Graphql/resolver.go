@@ -3,6 +3,7 @@ package Graphql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/graphql-go/graphql"
 	"github.com/redis/go-redis/v9"
@@ -46,25 +47,30 @@ var Mutation = graphql.NewObject(graphql.ObjectConfig{
 					return nil, fmt.Errorf("trackID is required")
 				}
 
-				pipe := rdb.TxPipeline()
-
-				incrCmd := pipe.ZIncrBy(ctx, "likes", 1, "track"+trackIDstring)
-
-				_, err := pipe.Exec(ctx)
-				if err != nil {
+				// XX: unlisted и private треков нет в общем рейтинге, и лайк не должен их туда вернуть
+				likesNewValue, err := rdb.ZAddArgsIncr(ctx, "likes", redis.ZAddArgs{
+					XX:      true,
+					Members: []redis.Z{{Score: 1, Member: "track" + trackIDstring}},
+				}).Result()
+				if errors.Is(err, redis.Nil) {
+					// Для закрытого трека лайки копятся только в хэше трека
+					var likes int64
+					likes, err = rdb.HIncrBy(ctx, "track"+trackIDstring, "likes", 1).Result()
+					if err != nil {
+						return nil, err
+					}
+					likesNewValue = float64(likes)
+				} else if err != nil {
 					return nil, err
+				} else {
+					rdb.HSet(ctx, "track"+trackIDstring, "likes", likesNewValue)
 				}
 
-				likesNewValue, err := incrCmd.Result()
+				// Каждый лайк сразу сохраняется в Postgres: хэш трека music-service перечитывает из базы
+				// при любом изменении метаданных, и несохраненные лайки закрытых треков пропали бы
+				_, err = DB.ExecContext(ctx, `UPDATE trackmeta SET likes = GREATEST(likes, $1) WHERE id = $2`, int64(likesNewValue), trackIDstring)
 				if err != nil {
-					return nil, err
-				}
-
-				rdb.HSet(ctx, "track"+trackIDstring, "likes", likesNewValue)
-
-				if int(likesNewValue)%10 == 0 {
-					query := `UPDATE trackmeta SET likes = $1 WHERE id = $2`
-					DB.Exec(query, likesNewValue, trackIDstring)
+					return nil, fmt.Errorf("saving likes error: %w", err)
 				}
 
 				return likesNewValue, nil
@@ -52,6 +52,31 @@ func SendMsg(producer sarama.SyncProducer, topic, msgText string, logger *log.Lo
 	return fmt.Sprintf("Message %s sent to topic %s to partition %d\n", msg, topic, partition), nil
 }
 
+// SendEvent отправляет событие с ключом партиционирования и заголовками. dedupeKey одинаков
+// при повторной отправке того же события, по нему потребители отбрасывают дубли
+func SendEvent(producer sarama.SyncProducer, topic, key, dedupeKey, eventType string, payload []byte) error {
+	if producer == nil {
+		return fmt.Errorf("producer is nil")
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("dedupe_key"), Value: []byte(dedupeKey)},
+			{Key: []byte("event_type"), Value: []byte(eventType)},
+		},
+	}
+
+	_, _, err := producer.SendMessage(msg)
+	if err != nil {
+		return fmt.Errorf("error sending event %s: %w", dedupeKey, err)
+	}
+
+	return nil
+}
+
 func Reader(topic string, logger *log.Logger) {
 	brokers := []string{"localhost:9092"}
 
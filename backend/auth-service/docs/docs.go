@@ -220,7 +220,7 @@ const docTemplate = `{
         },
         "/deleteusertrack": {
             "delete": {
-                "description": "Этот эндпоинт позволяет удалить трек при наличии токенов",
+                "description": "Этот эндпоинт позволяет удалить трек при наличии токенов. Удалить можно только свой трек: music-service удаляет метаданные, файлы трека и убирает его из всех плейлистов",
                 "produces": [
                     "text/plain"
                 ],
@@ -249,20 +249,6 @@ const docTemplate = `{
                         "name": "trackID",
                         "in": "query",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Владелец трека - Username",
-                        "name": "trackOwner",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Жанр трека",
-                        "name": "genre",
-                        "in": "query",
-                        "required": true
                     }
                 ],
                 "responses": {
@@ -278,8 +264,14 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "403": {
+                        "description": "You are not the owner of this track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
                         "schema": {
                             "type": "string"
                         }
@@ -290,6 +282,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "409": {
+                        "description": "The track is still being processed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -485,12 +483,36 @@ const docTemplate = `{
                 ],
                 "summary": "Получить метаданные трека (отправка track_id со страницы /gettrackmeta)",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header"
+                    },
                     {
                         "type": "integer",
                         "description": "ID трека",
                         "name": "track_id",
                         "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Сторона обложки в пикселях: 64, 300 (по умолчанию) или 1000",
+                        "name": "picture_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Вернуть байты обложки в track_picture помимо picture_url",
+                        "name": "include_picture",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -501,7 +523,19 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request - Empty trackID, trackID error, metadata retrieval error, or trackMeta serialization error",
+                        "description": "Bad Request - Empty trackID, trackID error or picture_size error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Authorization required - unlisted or private track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
                         "schema": {
                             "type": "string"
                         }
@@ -511,6 +545,12 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error - metadata retrieval or trackMeta serialization error",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
@@ -601,6 +641,77 @@ const docTemplate = `{
                 }
             }
         },
+        "/gettrackwaveform": {
+            "get": {
+                "description": "Возвращает пики min/max волны трека. Отдается ближайшее сохраненное разрешение не меньше запрошенного, без resolution - наибольшее",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Получить волну трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "track_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Желаемое количество пар min/max",
+                        "name": "resolution",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Waveform"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request - Empty trackID, trackID error or resolution error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Authorization required - unlisted or private track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Waveform not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed - Invalid request method",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/getuserdatasend": {
             "get": {
                 "security": [
@@ -887,167 +998,77 @@ const docTemplate = `{
                 }
             }
         },
-        "/signinsend": {
+        "/probeupload": {
             "post": {
-                "description": "Принимает email/username и пароль согласно структуре UserData, и при успехе возвращает сообщение json и токены: refresh в Cookies \"refresh_token\" и access в заголовке \"Authorization\"",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "authorization"
-                ],
-                "summary": "Аутентификация пользователя",
-                "parameters": [
+                "security": [
                     {
-                        "description": "Данные от клиента для авторизации",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.UserData"
-                        }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "Успешный ответ json(\"message\": \"result\") с токенами в Cookies и заголовке",
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
-                        },
-                        "headers": {
-                            "Authorization": {
-                                "type": "string",
-                                "description": "Bearer \u003cAccessJWTToken\u003e"
-                            }
-                        }
-                    },
-                    "400": {
-                        "description": "Invalid request method, Data error",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "401": {
-                        "description": "Wrong password",
-                        "schema": {
-                            "type": "string"
-                        }
+                        "CookieAuth": []
                     },
-                    "500": {
-                        "description": "Server error",
-                        "schema": {
-                            "type": "string"
-                        }
+                    {
+                        "BearerAuth": []
                     }
-                }
-            }
-        },
-        "/signupsend": {
-            "post": {
-                "description": "Принимает все метаданные пользователя согласно структуре User и добавляет его в БД Users",
+                ],
+                "description": "Принимает аудиофайл и возвращает найденные в его тегах (ID3, Vorbis comments, MP4) метаданные и встроенную обложку. Трек не сохраняется",
                 "consumes": [
-                    "application/json"
+                    "multipart/form-data"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "authorization"
+                    "track"
                 ],
-                "summary": "Регистрация нового пользователя",
+                "summary": "Прочитать теги трека до загрузки",
                 "parameters": [
                     {
-                        "description": "Метаданные пользователя",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.User"
-                        }
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "Track audio file (.mp3, .wav или .flac), тип multipart/form-data",
+                        "name": "track_file",
+                        "in": "formData",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "The user has been registered, json(\"message\": \"answer\")",
+                        "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.ProbeResult"
                         }
                     },
                     "400": {
-                        "description": "Неверный метод запроса, ошибка обработки данных",
+                        "description": "Bad Request - Missing track file, file type error or not an audio file",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Ошибка обработки пароля, ошибка добавления пользователя, Ошибка отправки результата",
+                    "405": {
+                        "description": "Method Not Allowed - Use POST",
                         "schema": {
                             "type": "string"
                         }
-                    }
-                }
-            }
-        },
-        "/streammusicsend": {
-            "get": {
-                "description": "Принимает от клиента имя исполнителя, название трека и стартовую позицию воспроизведения и по этим данным начинает воспроизведение",
-                "consumes": [
-                    "text/plain"
-                ],
-                "produces": [
-                    "application/octet-stream"
-                ],
-                "tags": [
-                    "track"
-                ],
-                "summary": "Выполняет потоковый стриминг трека из GRPC клиенту (отправка данных со страницы /streammusic)",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Имя артиста",
-                        "name": "artist_name",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Название трека",
-                        "name": "title",
-                        "in": "query",
-                        "required": true
                     },
-                    {
-                        "type": "integer",
-                        "description": "Стартовая позиция воспроизведения в секундах",
-                        "name": "startPosition",
-                        "in": "query",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "Audio stream, поток []byte данных трека",
+                    "415": {
+                        "description": "Unsupported Media Type - Must be multipart/form-data",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "array",
-                                "items": {
-                                    "type": "integer"
-                                }
-                            }
+                            "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error: invalid startPosition, streaming music error, error getting metadata, error streaming music",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
@@ -1055,7 +1076,7 @@ const docTemplate = `{
                 }
             }
         },
-        "/uploadmusicsend": {
+        "/replacetrackaudio": {
             "post": {
                 "security": [
                     {
@@ -1065,17 +1086,17 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Принимает от клиента трек и метаданные трека и отправляет через GRPC в БД",
+                "description": "Владелец загружает новый звук опубликованного трека (ремастер, исправленное сведение). ID трека, прослушивания, лайки, плейлисты и метаданные сохраняются. Новый звук проходит те же проверки, что и загрузка, и обрабатывается в фоне: до готовности играет прежний, статус - через /uploadstatus. Предыдущая версия хранится для отката через /rollbacktrackaudio",
                 "consumes": [
                     "multipart/form-data"
                 ],
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
                     "track"
                 ],
-                "summary": "Загружает трек и его метаданные в БД (отправка данных со страницы /uploadmusic)",
+                "summary": "Замена звука трека",
                 "parameters": [
                     {
                         "type": "string",
@@ -1091,67 +1112,1106 @@ const docTemplate = `{
                         "in": "header",
                         "required": true
                     },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Собрать дополнительную версию, нормализованную к -14 LUFS",
+                        "name": "normalize_loudness",
+                        "in": "query"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Новый аудиофайл трека (.mp3, .wav или .flac)",
+                        "name": "track_file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Новый звук принят, статус обработки - через /uploadstatus",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "You are not the owner of this track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "The track is still being processed or duplicates another track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type - Must be multipart/form-data",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rollbacktrackaudio": {
+            "post": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает трек на предыдущую версию звука. Текущая версия становится предыдущей, так что повторный откат возвращает замену",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Откат звука трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Метаданные трека после отката",
+                        "schema": {
+                            "$ref": "#/definitions/main.TrackMeta"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "You are not the owner of this track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "No previous audio version or the track is being processed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/signinsend": {
+            "post": {
+                "description": "Принимает email/username и пароль согласно структуре UserData, и при успехе возвращает сообщение json и токены: refresh в Cookies \"refresh_token\" и access в заголовке \"Authorization\"",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authorization"
+                ],
+                "summary": "Аутентификация пользователя",
+                "parameters": [
+                    {
+                        "description": "Данные от клиента для авторизации",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.UserData"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Успешный ответ json(\"message\": \"result\") с токенами в Cookies и заголовке",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        },
+                        "headers": {
+                            "Authorization": {
+                                "type": "string",
+                                "description": "Bearer \u003cAccessJWTToken\u003e"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request method, Data error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong password",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/signupsend": {
+            "post": {
+                "description": "Принимает все метаданные пользователя согласно структуре User и добавляет его в БД Users",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authorization"
+                ],
+                "summary": "Регистрация нового пользователя",
+                "parameters": [
+                    {
+                        "description": "Метаданные пользователя",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.User"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user has been registered, json(\"message\": \"answer\")",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный метод запроса, ошибка обработки данных",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Ошибка обработки пароля, ошибка добавления пользователя, Ошибка отправки результата",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/streammusicws": {
+            "get": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Бинарные сообщения - куски сегментов из StreamMusic: MPEG-TS или CMAF (init сегмент, затем .m4s). Текстовые - JSON с полем type.\nКлиент: {\"type\":\"seek\",\"position\":42}, {\"type\":\"pause\"}, {\"type\":\"resume\"}, {\"type\":\"set_quality\",\"quality\":\"256k\"},\n{\"type\":\"ack\",\"credits\":8,\"position\":12.5}, {\"type\":\"played_60_sec\"}, {\"type\":\"finish\"}.\nСервер: started после каждого (пере)запуска потока (start, duration, quality, format mpegts или fmp4), затем куски этого потока;\nstate раз в секунду и после команд (state, position, sent_position, buffered, credits); error при ошибке команды.\nseek и set_quality перезапускают StreamMusic в том же соединении. Если передан credits, сервер отправляет не больше\ncredits бинарных сообщений и ждет ack, иначе шлет без ограничений. После конца трека соединение остается открытым для seek",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Потоковое воспроизведение трека по WebSocket с управлением",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Владелец трека",
+                        "name": "username",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Стартовая позиция воспроизведения в секундах",
+                        "name": "startPosition",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ступень: 64k, 128k или 256k, по умолчанию 128k",
+                        "name": "quality",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Начальный кредит бинарных сообщений, включает управление потоком через ack",
+                        "name": "credits",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request - некорректные startPosition или credits",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized - нет входа",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found - трек не найден",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable - startPosition за концом трека",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/trackkey": {
+            "get": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отдает 16 байт ключа AES-128, на который ссылается #EXT-X-KEY плейлиста зашифрованного трека. Ключ получают только вошедшие пользователи, которым разрешено слушать трек: для private трека - только владелец",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Ключ сегментов трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Ключ AES-128",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid trackID",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Authorization required",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found or not encrypted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/trackpicture": {
+            "get": {
+                "description": "Отдает JPEG обложки: наименьшую версию не меньше size (64, 300 или 1000, по умолчанию 300). Ссылки из picture_url содержат параметр v, который меняется вместе с файлом, поэтому такие ответы кэшируются как immutable. Без v ответ нужно перепроверять по ETag. Обложка unlisted трека требует входа, private - только владельцу",
+                "produces": [
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Обложка трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Владелец трека",
+                        "name": "username",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Сторона обложки в пикселях",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Версия файла из picture_url",
+                        "name": "v",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "JPEG",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid parameters",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Authorization required",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Picture not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed - Invalid request method",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/updatetrackmeta": {
+            "patch": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Владелец трека меняет название, альбом, жанр, описание, год выпуска, видимость и обложку. Прослушивания, лайки и плейлисты сохраняются. Передаются только изменяемые поля: meta_data с частью полей TrackMetaUpdate и/или новая обложка",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Редактирование трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "trackID",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Изменяемые поля согласно TrackMetaUpdate в JSON",
+                        "name": "meta_data",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Новая обложка JPEG или PNG",
+                        "name": "picture_file",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Метаданные трека после изменения",
+                        "schema": {
+                            "$ref": "#/definitions/main.TrackMeta"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "You are not the owner of this track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "The track is still being processed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploadmusicsend": {
+            "post": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Принимает от клиента трек и метаданные трека и отправляет через GRPC в БД",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Загружает трек и его метаданные в БД (отправка данных со страницы /uploadmusic)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ключ загрузки до 64 символов: повтор запроса с тем же ключом возвращает уже принятый трек",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Track cover image, тип multipart/form-data. Без нее берется обложка из тегов файла",
+                        "name": "picture_file",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Track audio file (.mp3, .wav или .flac), тип multipart/form-data",
+                        "name": "track_file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Метадата трека согласно TrackMeta структуры in JSON format, тип multipart/form-data",
+                        "name": "meta_data",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Трек принят, статус обработки - через /uploadstatus",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request - Invalid input, missing required data, file too large, file type error, or metadata retrieval error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized - Invalid or expired token",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed - Use POST",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict - Track duplicates an already uploaded track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large - Exceeds file size limit",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type - Must be multipart/form-data",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error - Unexpected issue",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploadsession": {
+            "post": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Принимает метаданные трека, обложку и полный размер трека в заголовке Upload-Length. Сам трек затем догружается кусками через PATCH /uploadsession",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Создает сессию возобновляемой загрузки трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Полный размер трека в байтах",
+                        "name": "Upload-Length",
+                        "in": "header",
+                        "required": true
+                    },
                     {
                         "type": "file",
-                        "description": "Track cover image, тип multipart/form-data",
+                        "description": "Track cover image, тип multipart/form-data. Без нее берется обложка из тегов файла",
                         "name": "picture_file",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Метадата трека согласно TrackMeta структуры in JSON format, тип multipart/form-data",
+                        "name": "meta_data",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Имя файла трека: .mp3, .wav или .flac",
+                        "name": "track_filename",
                         "in": "formData",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadSession"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "HEAD возвращает текущее смещение в заголовке Upload-Offset. PATCH дописывает кусок трека с Content-Type application/offset+octet-stream, заголовок Upload-Offset должен совпадать с текущим смещением",
+                "consumes": [
+                    "application/offset+octet-stream"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Смещение и дозагрузка трека в сессии возобновляемой загрузки",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
                     },
                     {
-                        "type": "file",
-                        "description": "Track audio file, тип multipart/form-data",
-                        "name": "track_file",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Метадата трека согласно TrackMeta структуры in JSON format, тип multipart/form-data",
-                        "name": "meta_data",
-                        "in": "formData",
+                        "description": "ID сессии загрузки",
+                        "name": "uploadID",
+                        "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение куска, обязательно для PATCH",
+                        "name": "Upload-Offset",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Your track has been uploaded successfully",
+                    "204": {
+                        "description": "Upload-Offset в заголовке ответа",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "400": {
-                        "description": "Bad Request - Invalid input, missing required data, file too large, file type error, or metadata retrieval error",
+                        "description": "Bad Request",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized - Invalid or expired token",
+                    "404": {
+                        "description": "Upload session not found or expired",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "405": {
-                        "description": "Method Not Allowed - Use POST",
+                        "description": "Method Not Allowed",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "413": {
-                        "description": "Request Entity Too Large - Exceeds file size limit",
+                    "409": {
+                        "description": "Upload-Offset mismatch",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "415": {
-                        "description": "Unsupported Media Type - Must be multipart/form-data",
+                        "description": "Unsupported Media Type",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error - Unexpected issue",
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploadsessionfinish": {
+            "post": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Когда смещение сессии дошло до Upload-Length, собранный трек передается в music-service тем же путем, что и /uploadmusicsend, после чего сессия удаляется",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Завершает возобновляемую загрузку трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID сессии загрузки",
+                        "name": "uploadID",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Трек принят, статус обработки - через /uploadstatus",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Upload session not found or expired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Upload is not complete or track duplicates an already uploaded track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploadstatus": {
+            "get": {
+                "security": [
+                    {
+                        "CookieAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает статус HLS-конвертации трека владельцу: queued, processing, ready или failed с текстом ошибки",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "track"
+                ],
+                "summary": "Статус обработки загруженного трека",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access token (format: 'Bearer {token}') из header",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token из cookies",
+                        "name": "refresh_token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID трека",
+                        "name": "track_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request - Empty trackID or trackID error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden - not the owner of this track",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Track not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed - Invalid request method",
                         "schema": {
                             "type": "string"
                         }
@@ -1161,6 +2221,38 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "main.ProbeResult": {
+            "type": "object",
+            "properties": {
+                "album_name": {
+                    "type": "string"
+                },
+                "artist_name": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "duration": {
+                    "type": "integer"
+                },
+                "genre": {
+                    "type": "string"
+                },
+                "release_year": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "track_picture": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
         "main.TrackMeta": {
             "type": "object",
             "properties": {
@@ -1174,6 +2266,10 @@ const docTemplate = `{
                 "artist_name": {
                     "type": "string"
                 },
+                "audio_version": {
+                    "description": "Номер текущей версии звука, 0 - исходная загрузка - не отправлять",
+                    "type": "integer"
+                },
                 "description": {
                     "description": "Описание песни - необязательное поле",
                     "type": "string"
@@ -1182,17 +2278,40 @@ const docTemplate = `{
                     "description": "Длительность песни - не отправлять",
                     "type": "integer"
                 },
+                "encrypt_segments": {
+                    "description": "Шифровать сегменты HLS, ключ выдается через /trackkey",
+                    "type": "boolean"
+                },
                 "genre": {
                     "type": "string"
                 },
+                "integrated_loudness": {
+                    "description": "Громкость по EBU R128 для коррекции громкости на клиенте - не отправлять",
+                    "type": "number"
+                },
                 "likes": {
                     "description": "Количество лайков - не отправлять",
                     "type": "integer"
                 },
+                "loudness_range": {
+                    "type": "number"
+                },
+                "normalize_loudness": {
+                    "description": "Собрать дополнительную версию, нормализованную к -14 LUFS (master-normalized.m3u8)",
+                    "type": "boolean"
+                },
+                "normalized_rendition": {
+                    "description": "Есть нормализованная лестница, она играется по /streammusicsend?normalized=true - не отправлять",
+                    "type": "boolean"
+                },
                 "owner": {
                     "description": "Владелец песни - не отправлять",
                     "type": "string"
                 },
+                "picture_url": {
+                    "description": "Ссылка на обложку (/trackpicture), относительно адреса auth-service",
+                    "type": "string"
+                },
                 "plays": {
                     "description": "Количество прослушиваний - не отправлять",
                     "type": "integer"
@@ -1200,6 +2319,10 @@ const docTemplate = `{
                 "release_year": {
                     "type": "integer"
                 },
+                "rollback_available": {
+                    "description": "Есть предыдущая версия звука для отката - не отправлять",
+                    "type": "boolean"
+                },
                 "title": {
                     "type": "string"
                 },
@@ -1207,10 +2330,54 @@ const docTemplate = `{
                     "type": "integer"
                 },
                 "track_picture": {
+                    "description": "Обложка байтами, только при include_picture=true",
                     "type": "array",
                     "items": {
                         "type": "integer"
                     }
+                },
+                "true_peak": {
+                    "type": "number"
+                },
+                "visibility": {
+                    "description": "public, unlisted (по ссылке вошедшим пользователям) или private (только владельцу)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.UploadResult": {
+            "type": "object",
+            "properties": {
+                "duplicate_of": {
+                    "description": "ID трека с тем же звуком, если загрузка принята с пометкой на проверку",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Статус обработки: queued, processing, ready или failed",
+                    "type": "string"
+                },
+                "track_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.UploadSession": {
+            "type": "object",
+            "properties": {
+                "upload_id": {
+                    "type": "string"
+                },
+                "upload_length": {
+                    "type": "integer"
+                },
+                "upload_offset": {
+                    "type": "integer"
                 }
             }
         },
@@ -1232,6 +2399,9 @@ const docTemplate = `{
                 "firstName": {
                     "type": "string"
                 },
+                "hash": {
+                    "type": "string"
+                },
                 "password": {
                     "type": "string"
                 },
@@ -1253,6 +2423,33 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "main.Waveform": {
+            "type": "object",
+            "properties": {
+                "available_resolutions": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "peaks": {
+                    "description": "Чередующиеся min,max в диапазоне -128..127, resolution пар на весь трек",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "resolution": {
+                    "type": "integer"
+                },
+                "track_id": {
+                    "type": "integer"
+                }
+            }
         }
     }
 }`
@@ -3,6 +3,7 @@ module aiartistprod/backend/auth-service
 go 1.23.1
 
 require (
+	aiartistprod/backend/storage v0.0.0
 	github.com/IBM/sarama v1.45.1
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/websocket v1.5.3
@@ -55,3 +56,5 @@ require (
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace aiartistprod/backend/storage => ../storage
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsURLTTL сколько живут подписанные ссылки плейлиста. Плеер перечитывает только
+// входной плейлист, поэтому срок должен покрывать прослушивание целиком
+const hlsURLTTL = 4 * time.Hour
+
+// Видимость трека, ее хранит music-service в trackMeta.visibility
+const (
+	trackVisibilityPublic   = "public"
+	trackVisibilityUnlisted = "unlisted"
+	trackVisibilityPrivate  = "private"
+)
+
+var (
+	errHLSURLUnsigned = errors.New("ссылка без подписи")
+	errHLSURLExpired  = errors.New("срок ссылки истек")
+	errHLSURLBadSign  = errors.New("неверная подпись ссылки")
+)
+
+// hlsURLSigner подписывает ссылки на варианты и сегменты одной версии трека.
+// Все ссылки плейлиста наследуют срок входного запроса
+type hlsURLSigner struct {
+	username string
+	trackID  string
+	version  string
+	expires  int64
+}
+
+// sign HMAC-SHA256 от всего, что определяет отдаваемый файл, и срока действия. duration -
+// длительность сегмента из #EXTINF, по ней считаются прослушивания, поэтому она тоже подписана
+func (s hlsURLSigner) sign(variant, file, duration string) string {
+	mac := hmac.New(sha256.New, []byte(HLSURLSigningKey))
+	mac.Write([]byte(strings.Join([]string{
+		s.username, s.trackID, s.version, variant, file, duration, strconv.FormatInt(s.expires, 10),
+	}, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// query параметры dur (только у сегментов), exp и sig для ссылки на файл file варианта variant
+func (s hlsURLSigner) query(variant, file, duration string) string {
+	query := ""
+	if duration != "" {
+		query = "&dur=" + url.QueryEscape(duration)
+	}
+	return query + "&exp=" + strconv.FormatInt(s.expires, 10) + "&sig=" + s.sign(variant, file, duration)
+}
+
+// verifyHLSURL проверяет подпись и срок ссылки, выданной в плейлисте. Возвращает подписчика
+// с тем же сроком, чтобы ссылки вложенного плейлиста истекали вместе с родительским
+func verifyHLSURL(r *http.Request, signer hlsURLSigner, variant, file string) (hlsURLSigner, error) {
+	exp := r.URL.Query().Get("exp")
+	sig := r.URL.Query().Get("sig")
+	if exp == "" || sig == "" {
+		return signer, errHLSURLUnsigned
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return signer, errHLSURLBadSign
+	}
+	signer.expires = expires
+
+	if !hmac.Equal([]byte(sig), []byte(signer.sign(variant, file, r.URL.Query().Get("dur")))) {
+		return signer, errHLSURLBadSign
+	}
+	if time.Now().Unix() > expires {
+		return signer, errHLSURLExpired
+	}
+
+	return signer, nil
+}
+
+// authorizeTrackPlaylist проверка перед выдачей подписанных ссылок: публичный трек доступен всем,
+// unlisted - любому вошедшему пользователю, private - только владельцу
+func authorizeTrackPlaylist(w http.ResponseWriter, r *http.Request, owner, visibility string) bool {
+	if visibility == "" || visibility == trackVisibilityPublic {
+		return true
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println("HLS access denied:", err)
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return false
+	}
+
+	if visibility == trackVisibilityPrivate && claims.Username != owner {
+		logger.Println("HLS access denied", "user", claims.Username, "owner", owner)
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return false
+	}
+
+	return true
+}
+
+// requestListener пользователь запроса, если он передал токены. Метаданные, обложку и волну
+// публичных треков отдаем без входа, поэтому отсутствие или ошибка токенов - просто аноним,
+// а решение о доступе к unlisted и private принимает music-service
+func requestListener(w http.ResponseWriter, r *http.Request) string {
+	if r.Header.Get("Authorization") == "" {
+		return ""
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		return ""
+	}
+
+	return claims.Username
+}
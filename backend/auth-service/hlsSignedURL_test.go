@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHLSSigner(expires int64) hlsURLSigner {
+	HLSURLSigningKey = "test-signing-key"
+	return hlsURLSigner{username: "alice", trackID: "42", version: "1", expires: expires}
+}
+
+func TestVerifyHLSURL(t *testing.T) {
+	valid := testHLSSigner(time.Now().Add(time.Hour).Unix())
+	expired := testHLSSigner(time.Now().Add(-time.Minute).Unix())
+	validQuery := valid.query("128k", "segment0.ts", "6.000")
+
+	tests := []struct {
+		name    string
+		query   string
+		variant string
+		file    string
+		wantErr error
+	}{
+		{
+			name:    "valid signature",
+			query:   validQuery,
+			variant: "128k",
+			file:    "segment0.ts",
+		},
+		{
+			name:    "tampered file",
+			query:   validQuery,
+			variant: "128k",
+			file:    "segment1.ts",
+			wantErr: errHLSURLBadSign,
+		},
+		{
+			name:    "tampered variant",
+			query:   validQuery,
+			variant: "320k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLBadSign,
+		},
+		{
+			name:    "tampered duration",
+			query:   strings.Replace(validQuery, "dur=6.000", "dur=600.000", 1),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLBadSign,
+		},
+		{
+			name:    "extended expiry",
+			query:   strings.Replace(validQuery, "exp="+strconv.FormatInt(valid.expires, 10), "exp="+strconv.FormatInt(valid.expires+3600, 10), 1),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLBadSign,
+		},
+		{
+			name:    "non-numeric expiry",
+			query:   "&exp=soon&sig=" + valid.sign("128k", "segment0.ts", ""),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLBadSign,
+		},
+		{
+			name:    "expired timestamp",
+			query:   expired.query("128k", "segment0.ts", "6.000"),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLExpired,
+		},
+		{
+			name:    "missing sig",
+			query:   "&dur=6.000&exp=" + strconv.FormatInt(valid.expires, 10),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLUnsigned,
+		},
+		{
+			name:    "missing exp",
+			query:   "&sig=" + valid.sign("128k", "segment0.ts", "6.000"),
+			variant: "128k",
+			file:    "segment0.ts",
+			wantErr: errHLSURLUnsigned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/streammusicsend?username=alice&trackID=42"+tt.query, nil)
+
+			// Срок берется из exp запроса, а не из подписчика
+			signer, err := verifyHLSURL(r, testHLSSigner(0), tt.variant, tt.file)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyHLSURL() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && signer.expires != valid.expires {
+				t.Errorf("verifyHLSURL() expires = %d, want %d", signer.expires, valid.expires)
+			}
+		})
+	}
+}
+
+func TestVerifyHLSURLOtherTrack(t *testing.T) {
+	signer := testHLSSigner(time.Now().Add(time.Hour).Unix())
+	query := signer.query("128k", "segment0.ts", "")
+
+	other := signer
+	other.trackID = "43"
+	other.expires = 0
+
+	r := httptest.NewRequest("GET", "/streammusicsend?username=alice&trackID=43"+query, nil)
+	if _, err := verifyHLSURL(r, other, "128k", "segment0.ts"); !errors.Is(err, errHLSURLBadSign) {
+		t.Fatalf("verifyHLSURL() error = %v, want %v", err, errHLSURLBadSign)
+	}
+}
+
+func TestRewriteHLSPlaylist(t *testing.T) {
+	signer := testHLSSigner(time.Now().Add(time.Hour).Unix())
+	trackBaseURL := "https://api.example.com/streammusicsend?username=alice&trackID=42"
+	segmentBaseURL := trackBaseURL + "&variant=128k&file="
+	keyURL := "https://api.example.com/trackkey?trackID=42"
+
+	tests := []struct {
+		name    string
+		variant string
+		content string
+		want    string
+	}{
+		{
+			name:    "master playlist variants",
+			variant: "",
+			content: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\n128k/playlist.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=128000\n128k-norm/playlist.m3u8",
+			want: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\n" +
+				trackBaseURL + "&variant=128k&file=playlist.m3u8" + signer.query("128k", "playlist.m3u8", "") + "\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=128000\n" +
+				trackBaseURL + "&variant=128k-norm&file=playlist.m3u8" + signer.query("128k-norm", "playlist.m3u8", ""),
+		},
+		{
+			name:    "mpegts segments with key",
+			variant: "128k",
+			content: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\"\n#EXTINF:6.000,\nsegment0.ts\n#EXTINF:4.5,\nsegment1.ts\n#EXT-X-ENDLIST",
+			want: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"" + keyURL + "\"\n" +
+				"#EXTINF:6.000,\n" + segmentBaseURL + "segment0.ts" + signer.query("128k", "segment0.ts", "6.000") + "\n" +
+				"#EXTINF:4.5,\n" + segmentBaseURL + "segment1.ts" + signer.query("128k", "segment1.ts", "4.5") + "\n" +
+				"#EXT-X-ENDLIST",
+		},
+		{
+			name:    "cmaf init and segments",
+			variant: "128k",
+			content: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.000,\nsegment0.m4s",
+			want: "#EXTM3U\n#EXT-X-MAP:URI=\"" + segmentBaseURL + "init.mp4" + signer.query("128k", "init.mp4", "") + "\"\n" +
+				"#EXTINF:6.000,\n" + segmentBaseURL + "segment0.m4s" + signer.query("128k", "segment0.m4s", "6.000"),
+		},
+		{
+			name:    "foreign uris are left as is",
+			variant: "128k",
+			content: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://evil.example.com/key\"\n#EXTINF:6.000,\nhttps://evil.example.com/segment0.ts\n../other/segment0.ts",
+			want:    "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://evil.example.com/key\"\n#EXTINF:6.000,\nhttps://evil.example.com/segment0.ts\n../other/segment0.ts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewriteHLSPlaylist(tt.content, trackBaseURL, segmentBaseURL, tt.variant, keyURL, signer)
+			if got != tt.want {
+				t.Errorf("rewriteHLSPlaylist() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Ссылка из переписанного плейлиста должна проходить verifyHLSURL
+func TestRewrittenSegmentURLVerifies(t *testing.T) {
+	signer := testHLSSigner(time.Now().Add(time.Hour).Unix())
+	trackBaseURL := "/streammusicsend?username=alice&trackID=42"
+
+	rewritten := rewriteHLSPlaylist("#EXTINF:6.000,\nsegment3.ts", trackBaseURL, trackBaseURL+"&variant=128k&file=", "128k", "", signer)
+	segmentURL := strings.Split(rewritten, "\n")[1]
+
+	r := httptest.NewRequest("GET", segmentURL, nil)
+	if _, err := verifyHLSURL(r, testHLSSigner(0), r.URL.Query().Get("variant"), r.URL.Query().Get("file")); err != nil {
+		t.Fatalf("verifyHLSURL(%s) error = %v", segmentURL, err)
+	}
+}
@@ -6,21 +6,27 @@ import (
 	"aiartistprod/backend/auth-service/Kafka"
 	_ "aiartistprod/backend/auth-service/docs"
 	logger2 "aiartistprod/backend/auth-service/logger"
+	"aiartistprod/backend/storage"
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/graphql-go/handler"
 	_ "github.com/lib/pq"
 	redisOrig "github.com/redis/go-redis/v9"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -40,7 +46,7 @@ type User struct {
 
 type playsCountObject struct {
 	lastTime time.Time
-	value    int
+	value    float64 // прослушанные секунды
 	check    bool
 	mutex    *sync.Mutex
 }
@@ -51,9 +57,15 @@ var logger *log.Logger
 var DB *sql.DB
 var JWTAccessSecretKey string
 var JWTRefreshSecretKey string
+
+// HLSURLSigningKey ключ HMAC подписанных ссылок на плейлисты и сегменты
+var HLSURLSigningKey string
 var rdb *redisOrig.Client
 var producer sarama.SyncProducer
 
+// blobStorage то же хранилище медиафайлов, в которое пишет music-service
+var blobStorage storage.Storage
+
 var graphQlHandler = handler.New(&handler.Config{
 	Schema:   &Graphql.Schema, // Передаем правильную схему
 	Pretty:   true,
@@ -76,6 +88,7 @@ func playsCountBuilder(username, trackID string) {
 
 func main() {
 	go playsCountReset()
+	go uploadSessionsCleanup()
 
 	var err error
 
@@ -112,6 +125,18 @@ func main() {
 		return
 	}
 
+	HLSURLSigningKey, err = getenvs("HLS_URL_SIGNING_KEY")
+	if err != nil {
+		logger.Println("Ошибка получения HLSURLSigningKey:", err)
+		return
+	}
+
+	// По умолчанию файлы лежат в рабочей директории соседнего music-service
+	blobStorage, err = storage.FromEnv(filepath.Join("..", "music-service"))
+	if err != nil {
+		logger.Fatalf("Ошибка настройки хранилища файлов: %v", err)
+	}
+
 	DB, err = Db.InitDB("Shellshocker", "123123123", "AiartistDB", "localhost", 5432, logger)
 	if err != nil {
 		logger.Fatalf("Ошибка подключения к БД, %v", err)
@@ -119,6 +144,23 @@ func main() {
 
 	Graphql.DBinit(DB)
 
+	// Пересборка по требованию: auth-service rebuild-redis
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-redis" {
+		stats, err := rebuildRedisIndexes(context.Background())
+		if err != nil {
+			log.Fatalf("rebuild-redis: %v", err)
+		}
+		fmt.Printf("rebuild-redis: пользователей %d, треков %d, списков %d\n", stats.Users, stats.Tracks, stats.Lists)
+		return
+	}
+
+	err = rebuildRedisIndexesIfMissing(context.Background())
+	if err != nil {
+		logger.Fatalf("Ошибка восстановления индексов Redis: %v", err)
+	}
+
+	go trackOutboxRelay()
+
 	initGRPCClient()
 
 	mux := http.NewServeMux()
@@ -140,6 +182,7 @@ func main() {
 	mux.HandleFunc("/uploadmusicsend", uploadMusicHandler) //passive загрузка музыки по GRPC
 	mux.HandleFunc("/streammusic", streamMusic)            //active страница c плеером для проигрывания музыки
 	mux.HandleFunc("/streammusicsend", streamMusicHLS)     //passive страница c плеером для проигрывания музыки
+	mux.HandleFunc("/streammusicws", streamMusicHandler)   //passive плеер по WebSocket с управлением воспроизведением
 	mux.HandleFunc("/gettrackmeta", getMeta)               //active получаем мета для трека
 	mux.HandleFunc("/gettrackmetasend", getMetaHandler)    //passive отправляем данные
 	mux.HandleFunc("/getuserdatasend", getUserDataHandler) //passive получаем данные пользователя
@@ -149,6 +192,18 @@ func main() {
 	mux.HandleFunc("/getUserTracks", getUserTracksHandler)
 	//удаление трека пользователя
 	mux.HandleFunc("/deleteusertrack", deleteUserTrackHandler)
+	////Возобновляемая загрузка музыки: POST создание сессии, HEAD смещение, PATCH кусок трека
+	mux.HandleFunc("/uploadsession", uploadSessionHandler)
+	mux.HandleFunc("/uploadsessionfinish", finishUploadSessionHandler)
+	////Статус обработки загруженного трека
+	mux.HandleFunc("/uploadstatus", uploadStatusHandler)
+	mux.HandleFunc("/gettrackwaveform", getWaveformHandler)
+	mux.HandleFunc("/probeupload", probeUploadHandler)
+	mux.HandleFunc("/trackpicture", trackPictureHandler)
+	mux.HandleFunc("/trackkey", trackKeyHandler)
+	mux.HandleFunc("/updatetrackmeta", updateTrackMetaHandler)
+	mux.HandleFunc("/replacetrackaudio", replaceTrackAudioHandler)
+	mux.HandleFunc("/rollbacktrackaudio", rollbackTrackAudioHandler)
 	////Активность в реальном времени
 	mux.HandleFunc("/liveactionsp", websocketHandler)    //passive
 	mux.HandleFunc("/liveactions", websocketPageHandler) //active
@@ -194,9 +249,13 @@ func getMeta(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./react/gettrackmetasend.html")
 }
 
-func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, filePath, segmentBaseURL string) {
+// StreamHLS отдает master/variant плейлисты и сегменты трека. В плейлистах относительные
+// ссылки на варианты и сегменты переписываются на trackBaseURL, вариант передается через &variant=,
+// и каждая ссылка получает подпись signer со сроком действия. Ключ зашифрованных сегментов
+// плейлисты берут с keyURL
+func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, variant, fileKey, trackBaseURL, keyURL string, signer hlsURLSigner) {
 
-	ext := filepath.Ext(filePath)
+	ext := path.Ext(fileKey)
 
 	switch ext {
 	case ".m3u8":
@@ -205,27 +264,42 @@ func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, filePa
 	case ".ts":
 		w.Header().Set("Content-Type", "video/mp2t")
 		w.Header().Set("Cache-Control", "no-store")
+	case ".m4s", ".mp4":
+		w.Header().Set("Content-Type", "audio/mp4")
+		w.Header().Set("Cache-Control", "no-store")
 	default:
 		logger.Printf("Unsupported file type: %s\n", ext)
 		http.Error(w, "Unsupported file type", http.StatusBadRequest)
 		return
 	}
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		logger.Printf("File not found: %s\n", filePath)
+	body, info, err := blobStorage.Get(r.Context(), fileKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.Printf("File not found: %s\n", fileKey)
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		logger.Printf("Failed to open %s: %v\n", fileKey, err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
 
 	if ext == ".m3u8" {
-		content, err := os.ReadFile(filePath)
+		content, err := io.ReadAll(body)
 		if err != nil {
 			logger.Printf("Failed to read playlist: %v\n", err)
 			http.Error(w, "Failed to read playlist", http.StatusInternalServerError)
 			return
 		}
 
-		modifiedContent := regexp.MustCompile(`(?m)^(segment\d+\.ts)$`).ReplaceAllString(string(content), segmentBaseURL+"${1}")
+		segmentBaseURL := trackBaseURL + "&file="
+		if variant != "" {
+			segmentBaseURL = trackBaseURL + "&variant=" + url.QueryEscape(variant) + "&file="
+		}
+
+		modifiedContent := rewriteHLSPlaylist(string(content), trackBaseURL, segmentBaseURL, variant, keyURL, signer)
 		logger.Printf("Modified playlist with segment base URL: %s\n", segmentBaseURL)
 		fmt.Printf("Sample modified content:\n%s\n", modifiedContent[:miN(len(modifiedContent), 200)])
 
@@ -238,6 +312,24 @@ func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, filePa
 		return
 	}
 
+	// Init сегмент CMAF не несет звука и в прослушивания не засчитывается
+	if ext == ".mp4" {
+		content, err := seekableBody(body)
+		if err != nil {
+			logger.Printf("Failed to read segment %s: %v\n", fileKey, err)
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, path.Base(fileKey), info.ModTime, content)
+		return
+	}
+
+	// Длительность сегмента подписана вместе со ссылкой, у старых ссылок ее нет - сегмент в 1 секунду
+	segmentDuration, err := strconv.ParseFloat(r.URL.Query().Get("dur"), 64)
+	if err != nil || segmentDuration <= 0 {
+		segmentDuration = 1
+	}
+
 	fmt.Println("Отметка 1")
 	playsCountBuilder(username, trackID)
 
@@ -246,11 +338,11 @@ func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, filePa
 
 		object.mutex.Lock()
 		object.lastTime = time.Now()
-		object.value++
+		object.value += segmentDuration
 		fmt.Println(object.value)
 		object.check = true
 
-		if object.value > 7 { //у нас сегмент равен 1 секунде
+		if object.value >= playCountSeconds {
 			playsIncr(username, trackID)
 			fmt.Println("Прослушка увеличена")
 			object.value = 0
@@ -258,8 +350,70 @@ func StreamHLS(w http.ResponseWriter, r *http.Request, username, trackID, filePa
 		object.mutex.Unlock()
 	}
 
-	fmt.Println("Отправка файла", filePath)
-	http.ServeFile(w, r, filePath)
+	content, err := seekableBody(body)
+	if err != nil {
+		logger.Printf("Failed to read segment %s: %v\n", fileKey, err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Println("Отправка файла", fileKey)
+	http.ServeContent(w, r, path.Base(fileKey), info.ModTime, content)
+}
+
+// seekableBody нужен для http.ServeContent (Range, 304): файлы с диска отдаются как есть,
+// ответы S3 небольшие (сегмент, обложка) и читаются в память
+func seekableBody(body io.ReadCloser) (io.ReadSeeker, error) {
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		return seeker, nil
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// playCountSeconds сколько секунд трека нужно получить, чтобы прослушивание засчиталось
+const playCountSeconds = 8
+
+var (
+	hlsVariantLineRegexp = regexp.MustCompile(`^([0-9]+k(?:-norm)?)/(playlist\.m3u8)$`)
+	hlsSegmentLineRegexp = regexp.MustCompile(`^segment\d+\.(?:ts|m4s)$`)
+	hlsMapLineRegexp     = regexp.MustCompile(`^(#EXT-X-MAP:.*URI=")([a-zA-Z0-9_-]+\.mp4)(".*)$`)
+	hlsVariantNameRegexp = regexp.MustCompile(`^[0-9]+k(-norm)?$`)
+)
+
+// rewriteHLSPlaylist переписывает относительные ссылки плейлиста на подписанные адреса auth-service:
+// варианты master плейлиста, init сегмент CMAF из #EXT-X-MAP, сегменты (с длительностью из
+// предшествующего #EXTINF) и адрес ключа в #EXT-X-KEY
+func rewriteHLSPlaylist(content, trackBaseURL, segmentBaseURL, variant, keyURL string, signer hlsURLSigner) string {
+	lines := strings.Split(content, "\n")
+	duration := ""
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration, _, _ = strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration = strings.TrimSpace(duration)
+		case hlsKeyLineRegexp.MatchString(line):
+			lines[i] = hlsKeyLineRegexp.ReplaceAllString(line, "${1}"+keyURL+"${2}")
+		case hlsMapLineRegexp.MatchString(line):
+			match := hlsMapLineRegexp.FindStringSubmatch(line)
+			lines[i] = match[1] + segmentBaseURL + match[2] + signer.query(variant, match[2], "") + match[3]
+		case hlsVariantLineRegexp.MatchString(line):
+			match := hlsVariantLineRegexp.FindStringSubmatch(line)
+			lines[i] = trackBaseURL + "&variant=" + match[1] + "&file=" + match[2] + signer.query(match[1], match[2], "")
+		case hlsSegmentLineRegexp.MatchString(line):
+			lines[i] = segmentBaseURL + line + signer.query(variant, line, duration)
+			duration = ""
+		}
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 func miN(a, b int) int {
@@ -269,6 +423,9 @@ func miN(a, b int) int {
 	return b
 }
 
+// streamMusicHLS раздает HLS трека. Запрос без variant и file выдает входной плейлист с подписанными
+// ссылками (для unlisted и private треков нужен JWT), с normalized=true - нормализованный по громкости
+// master-normalized.m3u8. Остальные запросы принимаются только с exp и sig
 func streamMusicHLS(w http.ResponseWriter, r *http.Request) {
 	username := r.URL.Query().Get("username")
 	trackID := r.URL.Query().Get("trackID")
@@ -292,46 +449,228 @@ func streamMusicHLS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Формируем путь к папке трека
-	baseDir := filepath.Join("..", "music-service", "songs")
-	hlsDir := filepath.Join(baseDir, username, username+"-"+trackID)
+	// Ключ папки трека в хранилище. После замены звука HLS лежит в поддиректории v<N>:
+	// по умолчанию играет текущая версия, параметр version закрепляет сессию за одной версией,
+	// чтобы переключение не смешало сегменты разного звука
+	playback, err := trackPlaybackInfo(r.Context(), trackID, username)
+	if err != nil {
+		sendTrackError(w, err, "Failed to get track")
+		return
+	}
+	currentVersion := playback.AudioVersion
+	previousVersion := playback.PreviousAudioVersion
+	owner := playback.Owner
+	visibility := playback.Visibility
 
-	// Проверка существования директории
-	if _, err := os.Stat(hlsDir); os.IsNotExist(err) {
-		logger.Println("Track directory not found", "directory", hlsDir)
+	if owner != username {
+		logger.Println("Track owner mismatch", "trackID", trackID, "username", username)
 		http.Error(w, "Track not found", http.StatusNotFound)
 		return
 	}
 
+	audioVersion := currentVersion
+	if requestedVersion := r.URL.Query().Get("version"); requestedVersion != "" {
+		if requestedVersion != currentVersion && requestedVersion != previousVersion {
+			logger.Println("Unknown audio version", "trackID", trackID, "version", requestedVersion)
+			http.Error(w, "Audio version not found", http.StatusNotFound)
+			return
+		}
+		audioVersion = requestedVersion
+	}
+
+	hlsDir := path.Join("songs", username, username+"-"+trackID)
+	if audioVersion != "" && audioVersion != "0" {
+		hlsDir = path.Join(hlsDir, "v"+audioVersion)
+	}
+
+	// Вариант лестницы битрейтов (64k/128k/256k, -norm у нормализованной по громкости),
+	// пустой для master плейлиста и старых треков
+	variant := r.URL.Query().Get("variant")
+	if variant != "" {
+		if !hlsVariantNameRegexp.MatchString(variant) {
+			logger.Println("Invalid variant", "variant", variant)
+			http.Error(w, "Invalid parameters", http.StatusBadRequest)
+			return
+		}
+		hlsDir = path.Join(hlsDir, variant)
+	}
+
 	// Проверка файла
 	requestedFile := r.URL.Query().Get("file")
+	entryRequest := variant == "" && requestedFile == ""
+
+	// normalized=true на входном запросе выбирает лестницу, приведенную к -14 LUFS
+	normalized := false
+	if value := r.URL.Query().Get("normalized"); value != "" && entryRequest {
+		normalized, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid normalized", http.StatusBadRequest)
+			return
+		}
+	}
+
 	if requestedFile == "" {
 		requestedFile = "playlist.m3u8"
+		// Новые треки транскодируются в несколько битрейтов и имеют master плейлист
+		if _, err := blobStorage.Stat(r.Context(), path.Join(hlsDir, "master.m3u8")); variant == "" && err == nil {
+			requestedFile = "master.m3u8"
+		}
+		if normalized {
+			if _, err := blobStorage.Stat(r.Context(), path.Join(hlsDir, "master-normalized.m3u8")); err != nil {
+				logger.Println("Normalized rendition not found", "trackID", trackID)
+				http.Error(w, "Normalized rendition not found", http.StatusNotFound)
+				return
+			}
+			requestedFile = "master-normalized.m3u8"
+		}
 	}
-	requestedFile = filepath.Base(requestedFile) // Предотвращаем Path Traversal
-	if !regexp.MustCompile(`^[a-zA-Z0-9_-]+\.(m3u8|ts)$`).MatchString(requestedFile) {
+	requestedFile = path.Base(requestedFile) // Предотвращаем Path Traversal
+	if !regexp.MustCompile(`^[a-zA-Z0-9_-]+\.(m3u8|ts|m4s|mp4)$`).MatchString(requestedFile) {
 		logger.Println("Invalid file name", "file", requestedFile)
 		http.Error(w, "Invalid file name", http.StatusBadRequest)
 		return
 	}
 
-	// Формируем полный путь
-	filePath := filepath.Join(hlsDir, requestedFile)
+	// Формируем ключ файла: username, trackID, variant и имя файла уже проверены регулярками,
+	// так что выйти за пределы папки трека нельзя
+	fileKey := path.Join(hlsDir, requestedFile)
 
-	// Проверка, что путь находится внутри baseDir
-	absBaseDir, _ := filepath.Abs(baseDir)
-	absFilePath, _ := filepath.Abs(filePath)
-	if !strings.HasPrefix(absFilePath, absBaseDir) {
-		logger.Println("Attempted path traversal", "filePath", absFilePath)
-		http.Error(w, "Invalid file path", http.StatusForbidden)
-		return
+	// Входной плейлист выдается после проверки видимости трека и начинает срок подписи.
+	// Вложенные плейлисты и сегменты отдаются только по подписанной ссылке из него
+	signer := hlsURLSigner{username: username, trackID: trackID, version: audioVersion}
+	if entryRequest {
+		if !authorizeTrackPlaylist(w, r, owner, visibility) {
+			return
+		}
+		signer.expires = time.Now().Add(hlsURLTTL).Unix()
+	} else {
+		signer, err = verifyHLSURL(r, signer, variant, r.URL.Query().Get("file"))
+		if err != nil {
+			logger.Println("Rejected HLS URL", "trackID", trackID, "file", requestedFile, "error", err)
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
 	}
 
-	// Формируем segmentBaseURL с экранированием
+	// Формируем trackBaseURL с экранированием
 	baseURL := "http://localhost:8080"
-	segmentBaseURL := fmt.Sprintf("%s/streammusicsend?username=%s&trackID=%s&file=", baseURL, url.QueryEscape(username), url.QueryEscape(trackID))
+	trackBaseURL := fmt.Sprintf("%s/streammusicsend?username=%s&trackID=%s", baseURL, url.QueryEscape(username), url.QueryEscape(trackID))
+	if audioVersion != "" {
+		trackBaseURL += "&version=" + url.QueryEscape(audioVersion)
+	}
+
+	keyURL := fmt.Sprintf("%s/trackkey?trackID=%s", baseURL, url.QueryEscape(trackID))
+
+	StreamHLS(w, r, username, trackID, variant, fileKey, trackBaseURL, keyURL, signer)
+}
+
+// trackPictureSizes стороны версий обложки, которые music-service сохраняет для каждого трека
+var trackPictureSizes = []int{64, 300, 1000}
+
+// trackPictureHandler отдает обложку трека нужного размера
+// @Summary Обложка трека
+// @Description Отдает JPEG обложки: наименьшую версию не меньше size (64, 300 или 1000, по умолчанию 300). Ссылки из picture_url содержат параметр v, который меняется вместе с файлом, поэтому такие ответы кэшируются как immutable. Без v ответ нужно перепроверять по ETag. Обложка unlisted трека требует входа, private - только владельцу
+// @Tags track
+// @Produce image/jpeg
+// @Param Authorization header string false "Access token (format: 'Bearer {token}'), нужен для unlisted и private треков"
+// @Param refresh_token header string false "Refresh token из cookies"
+// @Param username query string true "Владелец трека"
+// @Param trackID query int true "ID трека"
+// @Param size query int false "Сторона обложки в пикселях"
+// @Param v query string false "Версия файла из picture_url"
+// @Success 200 {file} binary "JPEG"
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid parameters"
+// @Failure 401 {string} string "Authorization required"
+// @Failure 404 {string} string "Picture not found"
+// @Failure 405 {string} string "Method Not Allowed - Invalid request method"
+// @Router /trackpicture [get]
+func trackPictureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Недопустимый метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	trackID := r.URL.Query().Get("trackID")
+
+	if len(username) > 50 || len(trackID) > 20 ||
+		!regexp.MustCompile(`^[a-zA-Z0-9_-]+$`).MatchString(username) || !regexp.MustCompile(`^[0-9]+$`).MatchString(trackID) {
+		logger.Println("Invalid username or trackID format", "username", username, "trackID", trackID)
+		http.Error(w, "Invalid parameters", http.StatusBadRequest)
+		return
+	}
+
+	// Обложка закрытого трека видна тем же, кому доступен сам трек
+	playback, err := trackPlaybackInfo(r.Context(), trackID, username)
+	if err != nil {
+		sendTrackError(w, err, "Picture not found")
+		return
+	}
+	owner := playback.Owner
+	visibility := playback.Visibility
+
+	if owner != username {
+		logger.Println("Track owner mismatch", "trackID", trackID, "username", username)
+		http.Error(w, "Picture not found", http.StatusNotFound)
+		return
+	}
+	if !authorizeTrackPlaylist(w, r, owner, visibility) {
+		return
+	}
+
+	cacheScope := "public"
+	if visibility != "" && visibility != trackVisibilityPublic {
+		cacheScope = "private"
+	}
+
+	size := 300
+	if sizeString := r.URL.Query().Get("size"); sizeString != "" {
+		var err error
+		size, err = strconv.Atoi(sizeString)
+		if err != nil || size <= 0 {
+			http.Error(w, "Invalid parameters", http.StatusBadRequest)
+			return
+		}
+	}
+
+	chosen := trackPictureSizes[len(trackPictureSizes)-1]
+	for _, available := range trackPictureSizes {
+		if available >= size {
+			chosen = available
+			break
+		}
+	}
+
+	// У треков, загруженных до появления версий, есть только исходный <user>-<id>.jpeg
+	baseDir := path.Join("pictures", username)
+	body, info, err := blobStorage.Get(r.Context(), path.Join(baseDir, username+"-"+trackID+"-"+strconv.Itoa(chosen)+".jpeg"))
+	if errors.Is(err, storage.ErrNotFound) {
+		body, info, err = blobStorage.Get(r.Context(), path.Join(baseDir, username+"-"+trackID+".jpeg"))
+	}
+	if err != nil {
+		logger.Println("Track picture not found", "username", username, "trackID", trackID, err)
+		http.Error(w, "Picture not found", http.StatusNotFound)
+		return
+	}
+	defer body.Close()
+
+	content, err := seekableBody(body)
+	if err != nil {
+		http.Error(w, "Picture not found", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", cacheScope+", max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", cacheScope+", no-cache")
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size, info.ModTime.UnixNano()))
 
-	StreamHLS(w, r, username, trackID, filePath, segmentBaseURL)
+	// ServeContent сам отвечает 304 на If-None-Match / If-Modified-Since
+	http.ServeContent(w, r, username+"-"+trackID+".jpeg", info.ModTime, content)
 }
 
 func playsCountReset() {
@@ -414,13 +753,13 @@ func enableCORS(next http.Handler) http.Handler {
 		// Разрешаем запросы с нужного источника
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173") // Укажи правильный источник
 		// Указываем разрешенные методы
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, HEAD")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS, HEAD")
 		// Указываем разрешенные заголовки
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Music-Duration, refresh_token, playlist-name")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Music-Duration, refresh_token, playlist-name, Upload-Length, Upload-Offset")
 		// Разрешаем передачу cookie
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		// Дополнительно можно указать заголовки, которые будут доступны клиенту
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "Authorization, Location, Upload-Length, Upload-Offset")
 
 		// Обработка preflight запросов (OPTIONS)
 		if r.Method == http.MethodOptions {
@@ -0,0 +1,517 @@
+package main
+
+import (
+	gen "aiartistprod/backend/auth-service/proto-gen-files"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы управляющих сообщений клиента в WebSocket плеера. played_60_sec и finish
+// по-прежнему принимаются и голой строкой, как до появления JSON протокола
+const (
+	playbackSeek       = "seek"
+	playbackPause      = "pause"
+	playbackResume     = "resume"
+	playbackSetQuality = "set_quality"
+	playbackAck        = "ack"
+	playbackPlayed60   = "played_60_sec"
+	playbackFinish     = "finish"
+)
+
+// Состояния воспроизведения в сообщениях state
+const (
+	playbackPlaying = "playing"
+	playbackPaused  = "paused"
+	playbackEnded   = "ended"
+)
+
+// playbackStateInterval как часто сервер сообщает состояние, пока трек передается
+const playbackStateInterval = time.Second
+
+// PlaybackControl управляющее сообщение клиента
+type PlaybackControl struct {
+	// seek, pause, resume, set_quality, ack, played_60_sec или finish
+	Type string `json:"type"`
+	// В seek - секунда, с которой продолжить, в ack - текущая позиция воспроизведения клиента
+	Position *float64 `json:"position,omitempty"`
+	// Ступень для set_quality: 64k, 128k или 256k
+	Quality string `json:"quality,omitempty"`
+	// В ack - сколько еще бинарных сообщений клиент готов принять
+	Credits int `json:"credits,omitempty"`
+}
+
+// PlaybackEvent сообщение сервера: started после (пере)запуска потока, state с позицией
+// и буфером, error при ошибке команды
+type PlaybackEvent struct {
+	Type string `json:"type"`
+	// started: запрошенная позиция и фактическое начало потока (начало сегмента)
+	Requested float64 `json:"requested,omitempty"`
+	Start     float64 `json:"start,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Quality   string  `json:"quality,omitempty"`
+	Format    string  `json:"format,omitempty"`
+	// state: playing, paused или ended
+	State string `json:"state,omitempty"`
+	// Позиция воспроизведения из последнего ack клиента
+	Position float64 `json:"position,omitempty"`
+	// До какой секунды трек уже отправлен клиенту, с точностью до сегмента
+	SentPosition float64 `json:"sent_position,omitempty"`
+	// Сколько секунд отправлено, но еще не проиграно: sent_position - position
+	Buffered float64 `json:"buffered,omitempty"`
+	// Оставшийся кредит сообщений, только если клиент включил управление потоком
+	Credits *int   `json:"credits,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// playbackChunk ответ StreamMusic или его ошибка, помеченные номером потока: куски потока,
+// замененного seek, отбрасываются
+type playbackChunk struct {
+	streamID int
+	resp     *gen.StreamMusicResponse
+	err      error
+}
+
+// playbackSession одно WebSocket соединение плеера. Писать в conn может только run,
+// читает только readControls
+type playbackSession struct {
+	conn *websocket.Conn
+	// username владелец трека, listener вошедший пользователь, который слушает
+	username string
+	listener string
+	trackID  string
+
+	ctx          context.Context
+	cancelStream context.CancelFunc
+	streamID     int
+	chunks       chan playbackChunk
+	controls     chan PlaybackControl
+
+	quality      string
+	duration     float64
+	position     float64
+	sentPosition float64
+	paused       bool
+	ended        bool
+
+	// flowControl включено, если клиент передал credits при подключении
+	flowControl bool
+	credits     int
+
+	playsCountedAt time.Time
+}
+
+// streamMusicHandler плеер по WebSocket поверх StreamMusic
+// @Summary Потоковое воспроизведение трека по WebSocket с управлением
+// @Description Бинарные сообщения - куски сегментов из StreamMusic: MPEG-TS или CMAF (init сегмент, затем .m4s). Текстовые - JSON с полем type.
+// @Description Клиент: {"type":"seek","position":42}, {"type":"pause"}, {"type":"resume"}, {"type":"set_quality","quality":"256k"},
+// @Description {"type":"ack","credits":8,"position":12.5}, {"type":"played_60_sec"}, {"type":"finish"}.
+// @Description Сервер: started после каждого (пере)запуска потока (start, duration, quality, format mpegts или fmp4), затем куски этого потока;
+// @Description state раз в секунду и после команд (state, position, sent_position, buffered, credits); error при ошибке команды.
+// @Description seek и set_quality перезапускают StreamMusic в том же соединении. Если передан credits, сервер отправляет не больше
+// @Description credits бинарных сообщений и ждет ack, иначе шлет без ограничений. После конца трека соединение остается открытым для seek
+// @Tags track
+// @Produce application/octet-stream
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param username query string true "Владелец трека"
+// @Param trackID query string true "ID трека"
+// @Param startPosition query int true "Стартовая позиция воспроизведения в секундах"
+// @Param quality query string false "Ступень: 64k, 128k или 256k, по умолчанию 128k"
+// @Param credits query int false "Начальный кредит бинарных сообщений, включает управление потоком через ack"
+// @Success 101 {string} string "Switching Protocols"
+// @Failure 400 {string} string "Bad Request - некорректные startPosition или credits"
+// @Failure 401 {string} string "Unauthorized - нет входа"
+// @Failure 404 {string} string "Not Found - трек не найден"
+// @Failure 416 {string} string "Requested Range Not Satisfiable - startPosition за концом трека"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /streammusicws [get]
+// @Security CookieAuth
+// @Security BearerAuth
+func streamMusicHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	trackID := r.URL.Query().Get("trackID")
+	quality := r.URL.Query().Get("quality")
+
+	startPosition, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("startPosition")))
+	if err != nil {
+		http.Error(w, "Invalid startPosition: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credits := 0
+	if value := r.URL.Query().Get("credits"); value != "" {
+		credits, err = strconv.Atoi(value)
+		if err != nil || credits <= 0 {
+			http.Error(w, "Invalid credits", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Права на трек (видимость, зашифрованные сегменты) music-service проверяет по listener
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println("Streaming music denied:", err)
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	// Закрытие WebSocket отменяет ctx, и music-service перестает читать сегменты
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	stream, md, err := openTrackStream(streamCtx, username, claims.Username, trackID, int64(startPosition), quality)
+	if err != nil {
+		cancelStream()
+		logger.Println("Error getting metadata:", err)
+		sendTrackError(w, err, "Streaming music error")
+		return
+	}
+
+	// Заголовки уходят в ответе на upgrade, w.Header() Upgrader не использует
+	responseHeader := http.Header{}
+	if authorization := w.Header().Get("Authorization"); authorization != "" {
+		responseHeader.Set("Authorization", authorization)
+	}
+	for _, field := range []struct {
+		metadataKey string
+		header      string
+	}{
+		{"music-duration", "Music-Duration"},
+		{"music-start", "Music-Start"},
+		{"music-format", "Music-Format"},
+		{"music-quality", "Music-Quality"},
+	} {
+		if values := md.Get(field.metadataKey); len(values) > 0 {
+			responseHeader.Set(field.header, values[0])
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		cancelStream()
+		logger.Printf("Websocker connection error: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	session := &playbackSession{
+		conn:           conn,
+		username:       username,
+		listener:       claims.Username,
+		trackID:        trackID,
+		ctx:            ctx,
+		chunks:         make(chan playbackChunk),
+		controls:       make(chan PlaybackControl),
+		flowControl:    credits > 0,
+		credits:        credits,
+		playsCountedAt: time.Now(),
+	}
+
+	if err := session.adopt(streamCtx, cancelStream, stream, md, float64(startPosition)); err != nil {
+		logger.Printf("Ошибка отправки по WebSocket: %v\n", err)
+		return
+	}
+
+	go session.readControls()
+
+	session.run()
+}
+
+// openTrackStream открывает StreamMusic и дожидается метаданных потока. Ошибки до начала потока
+// (трек не найден, позиция за концом трека, нет права слушать) приходят вместо заголовков
+func openTrackStream(ctx context.Context, username, listener, trackID string, position int64, quality string) (gen.MusicService_StreamMusicClient, metadata.MD, error) {
+	stream, err := musicClient.StreamMusic(ctx, &gen.StreamMusicRequest{
+		Username:      username,
+		Listener:      listener,
+		TrackId:       trackID,
+		StartPosition: position,
+		Quality:       quality,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md, err := stream.Header()
+	if err == nil && len(md.Get("music-duration")) == 0 {
+		_, err = stream.Recv()
+		if err == nil {
+			err = errors.New("music-duration metadata not found")
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stream, md, nil
+}
+
+// adopt делает поток текущим: прежний отменяется, клиент получает started, и куски
+// нового потока начинают поступать в chunks
+func (p *playbackSession) adopt(ctx context.Context, cancel context.CancelFunc, stream gen.MusicService_StreamMusicClient, md metadata.MD, requested float64) error {
+	if p.cancelStream != nil {
+		p.cancelStream()
+	}
+	p.cancelStream = cancel
+	p.streamID++
+	p.ended = false
+
+	mdFloat := func(key string) float64 {
+		values := md.Get(key)
+		if len(values) == 0 {
+			return 0
+		}
+		value, _ := strconv.ParseFloat(values[0], 64)
+		return value
+	}
+
+	p.duration = mdFloat("music-duration")
+	p.sentPosition = mdFloat("music-start")
+	p.position = p.sentPosition
+	if values := md.Get("music-quality"); len(values) > 0 {
+		p.quality = values[0]
+	}
+
+	formats := md.Get("music-format")
+	format := ""
+	if len(formats) > 0 {
+		format = formats[0]
+	}
+
+	go receivePlayback(ctx, stream, p.streamID, p.chunks)
+
+	return p.send(PlaybackEvent{
+		Type:      "started",
+		Requested: requested,
+		Start:     p.sentPosition,
+		Duration:  p.duration,
+		Quality:   p.quality,
+		Format:    format,
+	})
+}
+
+// receivePlayback перекладывает ответы StreamMusic в out, пока поток не закончится или не будет отменен
+func receivePlayback(ctx context.Context, stream gen.MusicService_StreamMusicClient, streamID int, out chan<- playbackChunk) {
+	for {
+		resp, err := stream.Recv()
+
+		select {
+		case out <- playbackChunk{streamID: streamID, resp: resp, err: err}:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readControls разбирает сообщения клиента. Закрытый controls означает, что соединение закрыто
+func (p *playbackSession) readControls() {
+	defer close(p.controls)
+
+	for {
+		msgType, msg, err := p.conn.ReadMessage()
+		if err != nil {
+			logger.Printf("Ошибка при чтении из WebSocket: %v\n", err)
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		var control PlaybackControl
+		if err := json.Unmarshal(msg, &control); err != nil {
+			control = PlaybackControl{Type: strings.TrimSpace(string(msg))}
+		}
+
+		select {
+		case p.controls <- control:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// run основной цикл соединения: команды клиента, отправка кусков в пределах кредита
+// и периодический отчет о состоянии
+func (p *playbackSession) run() {
+	defer func() {
+		if p.cancelStream != nil {
+			p.cancelStream()
+		}
+	}()
+
+	ticker := time.NewTicker(playbackStateInterval)
+	defer ticker.Stop()
+
+	for {
+		// Пока пауза или кредит исчерпан, куски не забираются, и StreamMusic
+		// упирается в управление потоком gRPC
+		var chunks <-chan playbackChunk
+		if !p.paused && !p.ended && (!p.flowControl || p.credits > 0) {
+			chunks = p.chunks
+		}
+
+		var err error
+		select {
+		case control, ok := <-p.controls:
+			if !ok {
+				return
+			}
+			if control.Type == playbackFinish {
+				return
+			}
+			err = p.handleControl(control)
+
+		case chunk := <-chunks:
+			err = p.handleChunk(chunk)
+
+		case <-ticker.C:
+			if !p.paused && !p.ended {
+				err = p.sendState()
+			}
+		}
+
+		if err != nil {
+			logger.Printf("Ошибка отправки по WebSocket: %v\n", err)
+			return
+		}
+	}
+}
+
+func (p *playbackSession) handleControl(control PlaybackControl) error {
+	switch control.Type {
+	case playbackSeek:
+		if control.Position == nil {
+			return p.sendError("seek без position")
+		}
+		return p.restart(*control.Position, p.quality)
+
+	case playbackSetQuality:
+		if control.Quality == "" {
+			return p.sendError("set_quality без quality")
+		}
+		// Продолжаем с того, что клиент сейчас слышит, данные старой ступени он отбрасывает по started
+		return p.restart(p.position, control.Quality)
+
+	case playbackPause:
+		p.paused = true
+		return p.sendState()
+
+	case playbackResume:
+		p.paused = false
+		return p.sendState()
+
+	case playbackAck:
+		if control.Credits < 0 {
+			return p.sendError("credits не может быть отрицательным")
+		}
+		p.credits += control.Credits
+		if control.Position != nil {
+			p.position = *control.Position
+		}
+		return p.sendState()
+
+	case playbackPlayed60:
+		if time.Since(p.playsCountedAt).Seconds() > 55 {
+			p.playsCountedAt = time.Now()
+			playsIncr(p.username, p.trackID)
+		}
+		return nil
+	}
+
+	return p.sendError("неизвестный тип сообщения: " + control.Type)
+}
+
+// restart открывает новый поток с позиции position. Текущий поток отменяется только после
+// успешного открытия нового, поэтому неудачный seek не прерывает воспроизведение
+func (p *playbackSession) restart(position float64, quality string) error {
+	if position < 0 {
+		return p.sendError("position не может быть отрицательной")
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	stream, md, err := openTrackStream(ctx, p.username, p.listener, p.trackID, int64(position), quality)
+	if err != nil {
+		cancel()
+		if st, ok := status.FromError(err); ok {
+			return p.sendError(st.Message())
+		}
+		return p.sendError(err.Error())
+	}
+
+	return p.adopt(ctx, cancel, stream, md, position)
+}
+
+func (p *playbackSession) handleChunk(chunk playbackChunk) error {
+	if chunk.streamID != p.streamID {
+		return nil
+	}
+
+	if errors.Is(chunk.err, io.EOF) {
+		logger.Println("End of streaming file")
+		p.ended = true
+		p.sentPosition = p.duration
+		return p.sendState()
+	}
+	if chunk.err != nil {
+		logger.Printf("Error streaming music: %v\n", chunk.err)
+		p.ended = true
+		return p.sendError("ошибка стрима трека")
+	}
+
+	err := p.conn.WriteMessage(websocket.BinaryMessage, chunk.resp.GetData())
+	if err != nil {
+		return err
+	}
+
+	p.sentPosition = chunk.resp.GetSegmentStart() + chunk.resp.GetSegmentDuration()
+	if p.flowControl {
+		p.credits--
+	}
+	return nil
+}
+
+func (p *playbackSession) sendState() error {
+	state := playbackPlaying
+	switch {
+	case p.ended:
+		state = playbackEnded
+	case p.paused:
+		state = playbackPaused
+	}
+
+	event := PlaybackEvent{
+		Type:         "state",
+		State:        state,
+		Quality:      p.quality,
+		Position:     p.position,
+		SentPosition: p.sentPosition,
+		Buffered:     max(p.sentPosition-p.position, 0),
+	}
+	if p.flowControl {
+		credits := p.credits
+		event.Credits = &credits
+	}
+
+	return p.send(event)
+}
+
+func (p *playbackSession) sendError(message string) error {
+	return p.send(PlaybackEvent{Type: "error", Message: message})
+}
+
+func (p *playbackSession) send(event PlaybackEvent) error {
+	return p.conn.WriteJSON(event)
+}
@@ -673,7 +673,7 @@ func getTracksFromPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		trackToSend, err := getMetaTracksFromSlice(tracks)
+		trackToSend, err := getMetaTracksFromSlice(tracks, owner)
 		if err != nil {
 			logger.Println(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -696,7 +696,7 @@ func getTracksFromPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		trackToSend, err := getMetaTracksFromSlice(tracks)
+		trackToSend, err := getMetaTracksFromSlice(tracks, requestListener(w, r))
 		if err != nil {
 			logger.Println(err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
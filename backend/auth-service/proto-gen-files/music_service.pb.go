@@ -24,21 +24,26 @@ const (
 )
 
 type UploadMusicRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ArtistName    string                 `protobuf:"bytes,1,opt,name=artist_name,json=artistName,proto3" json:"artist_name,omitempty"`
-	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
-	AlbumName     string                 `protobuf:"bytes,3,opt,name=album_name,json=albumName,proto3" json:"album_name,omitempty"`
-	Genre         string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
-	Description   string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
-	Duration      int32                  `protobuf:"varint,6,opt,name=duration,proto3" json:"duration,omitempty"`
-	ReleaseYear   int32                  `protobuf:"varint,7,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
-	AddToDbDate   *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=add_to_db_date,json=addToDbDate,proto3" json:"add_to_db_date,omitempty"`
-	TrackPicture  []byte                 `protobuf:"bytes,9,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
-	MusicContent  []byte                 `protobuf:"bytes,10,opt,name=music_content,json=musicContent,proto3" json:"music_content,omitempty"`
-	Owner         string                 `protobuf:"bytes,11,opt,name=owner,proto3" json:"owner,omitempty"`
-	TrackID       int32                  `protobuf:"varint,12,opt,name=trackID,proto3" json:"trackID,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ArtistName        string                 `protobuf:"bytes,1,opt,name=artist_name,json=artistName,proto3" json:"artist_name,omitempty"`
+	Title             string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	AlbumName         string                 `protobuf:"bytes,3,opt,name=album_name,json=albumName,proto3" json:"album_name,omitempty"`
+	Genre             string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
+	Description       string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
+	Duration          int32                  `protobuf:"varint,6,opt,name=duration,proto3" json:"duration,omitempty"`
+	ReleaseYear       int32                  `protobuf:"varint,7,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
+	AddToDbDate       *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=add_to_db_date,json=addToDbDate,proto3" json:"add_to_db_date,omitempty"`
+	TrackPicture      []byte                 `protobuf:"bytes,9,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
+	MusicContent      []byte                 `protobuf:"bytes,10,opt,name=music_content,json=musicContent,proto3" json:"music_content,omitempty"`
+	Owner             string                 `protobuf:"bytes,11,opt,name=owner,proto3" json:"owner,omitempty"`
+	TrackID           int32                  `protobuf:"varint,12,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	NormalizeLoudness bool                   `protobuf:"varint,13,opt,name=normalize_loudness,json=normalizeLoudness,proto3" json:"normalize_loudness,omitempty"`
+	// upload_id ключ идемпотентности загрузки: повтор с тем же ключом возвращает уже принятый трек
+	UploadId string `protobuf:"bytes,14,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+	// encrypt_segments шифровать сегменты HLS ключом AES-128 трека, ключ выдает GetTrackKey
+	EncryptSegments bool `protobuf:"varint,15,opt,name=encrypt_segments,json=encryptSegments,proto3" json:"encrypt_segments,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *UploadMusicRequest) Reset() {
@@ -155,16 +160,126 @@ func (x *UploadMusicRequest) GetTrackID() int32 {
 	return 0
 }
 
+func (x *UploadMusicRequest) GetNormalizeLoudness() bool {
+	if x != nil {
+		return x.NormalizeLoudness
+	}
+	return false
+}
+
+func (x *UploadMusicRequest) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+func (x *UploadMusicRequest) GetEncryptSegments() bool {
+	if x != nil {
+		return x.EncryptSegments
+	}
+	return false
+}
+
+// UploadMusicChunk первое сообщение потока несет метаданные и обложку (music_content пустой),
+// все последующие - очередные куски аудиофайла. В ReplaceTrackAudio из метаданных нужны только
+// trackID, owner и normalize_loudness
+type UploadMusicChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*UploadMusicChunk_Meta
+	//	*UploadMusicChunk_MusicChunk
+	Payload       isUploadMusicChunk_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadMusicChunk) Reset() {
+	*x = UploadMusicChunk{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadMusicChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadMusicChunk) ProtoMessage() {}
+
+func (x *UploadMusicChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadMusicChunk.ProtoReflect.Descriptor instead.
+func (*UploadMusicChunk) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *UploadMusicChunk) GetPayload() isUploadMusicChunk_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *UploadMusicChunk) GetMeta() *UploadMusicRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*UploadMusicChunk_Meta); ok {
+			return x.Meta
+		}
+	}
+	return nil
+}
+
+func (x *UploadMusicChunk) GetMusicChunk() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*UploadMusicChunk_MusicChunk); ok {
+			return x.MusicChunk
+		}
+	}
+	return nil
+}
+
+type isUploadMusicChunk_Payload interface {
+	isUploadMusicChunk_Payload()
+}
+
+type UploadMusicChunk_Meta struct {
+	Meta *UploadMusicRequest `protobuf:"bytes,1,opt,name=meta,proto3,oneof"`
+}
+
+type UploadMusicChunk_MusicChunk struct {
+	MusicChunk []byte `protobuf:"bytes,2,opt,name=music_chunk,json=musicChunk,proto3,oneof"`
+}
+
+func (*UploadMusicChunk_Meta) isUploadMusicChunk_Payload() {}
+
+func (*UploadMusicChunk_MusicChunk) isUploadMusicChunk_Payload() {}
+
 type UploadMusicResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Result        string                 `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Result  string                 `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	TrackID int32                  `protobuf:"varint,2,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	Status  string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	// ID трека с тем же звуком, если загрузка принята с пометкой на проверку
+	DuplicateOf   int32 `protobuf:"varint,4,opt,name=duplicate_of,json=duplicateOf,proto3" json:"duplicate_of,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *UploadMusicResponse) Reset() {
 	*x = UploadMusicResponse{}
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[1]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -176,7 +291,7 @@ func (x *UploadMusicResponse) String() string {
 func (*UploadMusicResponse) ProtoMessage() {}
 
 func (x *UploadMusicResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[1]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -189,7 +304,7 @@ func (x *UploadMusicResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UploadMusicResponse.ProtoReflect.Descriptor instead.
 func (*UploadMusicResponse) Descriptor() ([]byte, []int) {
-	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{1}
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *UploadMusicResponse) GetResult() string {
@@ -199,18 +314,44 @@ func (x *UploadMusicResponse) GetResult() string {
 	return ""
 }
 
+func (x *UploadMusicResponse) GetTrackID() int32 {
+	if x != nil {
+		return x.TrackID
+	}
+	return 0
+}
+
+func (x *UploadMusicResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *UploadMusicResponse) GetDuplicateOf() int32 {
+	if x != nil {
+		return x.DuplicateOf
+	}
+	return 0
+}
+
 type StreamMusicRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
 	TrackId       string                 `protobuf:"bytes,2,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
 	StartPosition int64                  `protobuf:"varint,3,opt,name=start_position,json=startPosition,proto3" json:"start_position,omitempty"`
+	// quality ступень лестницы битрейтов (64k, 128k, 256k). Пустая - 128k
+	Quality string `protobuf:"bytes,4,opt,name=quality,proto3" json:"quality,omitempty"`
+	// listener вошедший пользователь, проверенный auth-service. Без него отдаются только
+	// публичные незашифрованные треки
+	Listener      string `protobuf:"bytes,5,opt,name=listener,proto3" json:"listener,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *StreamMusicRequest) Reset() {
 	*x = StreamMusicRequest{}
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[2]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -222,7 +363,7 @@ func (x *StreamMusicRequest) String() string {
 func (*StreamMusicRequest) ProtoMessage() {}
 
 func (x *StreamMusicRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[2]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -235,7 +376,7 @@ func (x *StreamMusicRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StreamMusicRequest.ProtoReflect.Descriptor instead.
 func (*StreamMusicRequest) Descriptor() ([]byte, []int) {
-	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{2}
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *StreamMusicRequest) GetUsername() string {
@@ -259,16 +400,34 @@ func (x *StreamMusicRequest) GetStartPosition() int64 {
 	return 0
 }
 
+func (x *StreamMusicRequest) GetQuality() string {
+	if x != nil {
+		return x.Quality
+	}
+	return ""
+}
+
+func (x *StreamMusicRequest) GetListener() string {
+	if x != nil {
+		return x.Listener
+	}
+	return ""
+}
+
+// StreamMusicResponse кусок сегмента HLS. segment_start и segment_duration - положение сегмента
+// на шкале трека в секундах, по ним клиент знает, до какого места трек уже передан
 type StreamMusicResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Data            []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	SegmentStart    float64                `protobuf:"fixed64,2,opt,name=segment_start,json=segmentStart,proto3" json:"segment_start,omitempty"`
+	SegmentDuration float64                `protobuf:"fixed64,3,opt,name=segment_duration,json=segmentDuration,proto3" json:"segment_duration,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *StreamMusicResponse) Reset() {
 	*x = StreamMusicResponse{}
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[3]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -280,7 +439,7 @@ func (x *StreamMusicResponse) String() string {
 func (*StreamMusicResponse) ProtoMessage() {}
 
 func (x *StreamMusicResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[3]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -293,7 +452,7 @@ func (x *StreamMusicResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StreamMusicResponse.ProtoReflect.Descriptor instead.
 func (*StreamMusicResponse) Descriptor() ([]byte, []int) {
-	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{3}
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *StreamMusicResponse) GetData() []byte {
@@ -303,16 +462,37 @@ func (x *StreamMusicResponse) GetData() []byte {
 	return nil
 }
 
+func (x *StreamMusicResponse) GetSegmentStart() float64 {
+	if x != nil {
+		return x.SegmentStart
+	}
+	return 0
+}
+
+func (x *StreamMusicResponse) GetSegmentDuration() float64 {
+	if x != nil {
+		return x.SegmentDuration
+	}
+	return 0
+}
+
 type GetMetaRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	TrackId       int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	TrackId int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	// Сторона обложки в пикселях: 64, 300 или 1000. 0 - 300, промежуточные округляются вверх
+	PictureSize int32 `protobuf:"varint,2,opt,name=picture_size,json=pictureSize,proto3" json:"picture_size,omitempty"`
+	// Вернуть байты обложки в track_picture. По умолчанию отдается только picture_url
+	IncludePicture bool `protobuf:"varint,3,opt,name=include_picture,json=includePicture,proto3" json:"include_picture,omitempty"`
+	// listener вошедший пользователь, пустой у анонимного запроса. Непубличные треки
+	// отдаются по тем же правилам, что и звук в StreamMusic
+	Listener      string `protobuf:"bytes,4,opt,name=listener,proto3" json:"listener,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetMetaRequest) Reset() {
 	*x = GetMetaRequest{}
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[4]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -324,7 +504,7 @@ func (x *GetMetaRequest) String() string {
 func (*GetMetaRequest) ProtoMessage() {}
 
 func (x *GetMetaRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[4]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -337,7 +517,7 @@ func (x *GetMetaRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetMetaRequest.ProtoReflect.Descriptor instead.
 func (*GetMetaRequest) Descriptor() ([]byte, []int) {
-	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{4}
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *GetMetaRequest) GetTrackId() int32 {
@@ -347,28 +527,62 @@ func (x *GetMetaRequest) GetTrackId() int32 {
 	return 0
 }
 
+func (x *GetMetaRequest) GetPictureSize() int32 {
+	if x != nil {
+		return x.PictureSize
+	}
+	return 0
+}
+
+func (x *GetMetaRequest) GetIncludePicture() bool {
+	if x != nil {
+		return x.IncludePicture
+	}
+	return false
+}
+
+func (x *GetMetaRequest) GetListener() string {
+	if x != nil {
+		return x.Listener
+	}
+	return ""
+}
+
 type GetMetaResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ArtistName    string                 `protobuf:"bytes,1,opt,name=artist_name,json=artistName,proto3" json:"artist_name,omitempty"`
-	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
-	AlbumName     string                 `protobuf:"bytes,3,opt,name=album_name,json=albumName,proto3" json:"album_name,omitempty"`
-	Genre         string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
-	Description   string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
-	Duration      int32                  `protobuf:"varint,8,opt,name=duration,proto3" json:"duration,omitempty"`
-	ReleaseYear   int32                  `protobuf:"varint,6,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
-	AddToDbDate   *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=add_to_db_date,json=addToDbDate,proto3" json:"add_to_db_date,omitempty"`
-	Owner         string                 `protobuf:"bytes,9,opt,name=owner,proto3" json:"owner,omitempty"`
-	Likes         int64                  `protobuf:"varint,10,opt,name=likes,proto3" json:"likes,omitempty"`
-	Plays         int64                  `protobuf:"varint,11,opt,name=plays,proto3" json:"plays,omitempty"`
-	TrackPicture  []byte                 `protobuf:"bytes,12,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
-	TrackID       int32                  `protobuf:"varint,13,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ArtistName   string                 `protobuf:"bytes,1,opt,name=artist_name,json=artistName,proto3" json:"artist_name,omitempty"`
+	Title        string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	AlbumName    string                 `protobuf:"bytes,3,opt,name=album_name,json=albumName,proto3" json:"album_name,omitempty"`
+	Genre        string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
+	Description  string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
+	Duration     int32                  `protobuf:"varint,8,opt,name=duration,proto3" json:"duration,omitempty"`
+	ReleaseYear  int32                  `protobuf:"varint,6,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
+	AddToDbDate  *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=add_to_db_date,json=addToDbDate,proto3" json:"add_to_db_date,omitempty"`
+	Owner        string                 `protobuf:"bytes,9,opt,name=owner,proto3" json:"owner,omitempty"`
+	Likes        int64                  `protobuf:"varint,10,opt,name=likes,proto3" json:"likes,omitempty"`
+	Plays        int64                  `protobuf:"varint,11,opt,name=plays,proto3" json:"plays,omitempty"`
+	TrackPicture []byte                 `protobuf:"bytes,12,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
+	TrackID      int32                  `protobuf:"varint,13,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	// Громкость по EBU R128: LUFS, dBTP и LU
+	IntegratedLoudness  float64 `protobuf:"fixed64,14,opt,name=integrated_loudness,json=integratedLoudness,proto3" json:"integrated_loudness,omitempty"`
+	TruePeak            float64 `protobuf:"fixed64,15,opt,name=true_peak,json=truePeak,proto3" json:"true_peak,omitempty"`
+	LoudnessRange       float64 `protobuf:"fixed64,16,opt,name=loudness_range,json=loudnessRange,proto3" json:"loudness_range,omitempty"`
+	NormalizedRendition bool    `protobuf:"varint,17,opt,name=normalized_rendition,json=normalizedRendition,proto3" json:"normalized_rendition,omitempty"`
+	// Относительный URL обложки в auth-service, меняется вместе с файлом и кэшируется навсегда
+	PictureUrl string `protobuf:"bytes,18,opt,name=picture_url,json=pictureUrl,proto3" json:"picture_url,omitempty"`
+	// Текущая версия звука: 0 - исходная загрузка, дальше растет с каждой заменой
+	AudioVersion int32 `protobuf:"varint,19,opt,name=audio_version,json=audioVersion,proto3" json:"audio_version,omitempty"`
+	// Сохранена предыдущая версия звука, на нее можно откатиться через RollbackTrackAudio
+	RollbackAvailable bool `protobuf:"varint,20,opt,name=rollback_available,json=rollbackAvailable,proto3" json:"rollback_available,omitempty"`
+	// Доступ к треку: public, unlisted или private
+	Visibility    string `protobuf:"bytes,21,opt,name=visibility,proto3" json:"visibility,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetMetaResponse) Reset() {
 	*x = GetMetaResponse{}
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[5]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -380,7 +594,7 @@ func (x *GetMetaResponse) String() string {
 func (*GetMetaResponse) ProtoMessage() {}
 
 func (x *GetMetaResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[5]
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -393,7 +607,7 @@ func (x *GetMetaResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetMetaResponse.ProtoReflect.Descriptor instead.
 func (*GetMetaResponse) Descriptor() ([]byte, []int) {
-	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{5}
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *GetMetaResponse) GetArtistName() string {
@@ -487,109 +701,1025 @@ func (x *GetMetaResponse) GetTrackID() int32 {
 	return 0
 }
 
-var File_backend_music_service_api_proto_music_service_proto protoreflect.FileDescriptor
+func (x *GetMetaResponse) GetIntegratedLoudness() float64 {
+	if x != nil {
+		return x.IntegratedLoudness
+	}
+	return 0
+}
 
-const file_backend_music_service_api_proto_music_service_proto_rawDesc = "" +
-	"\n" +
-	"3backend/music-service/api/proto/music_service.proto\x12\rmusic_service\x1a\x1fgoogle/protobuf/timestamp.proto\"\x9c\x03\n" +
-	"\x12UploadMusicRequest\x12\x1f\n" +
-	"\vartist_name\x18\x01 \x01(\tR\n" +
-	"artistName\x12\x14\n" +
-	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1d\n" +
-	"\n" +
-	"album_name\x18\x03 \x01(\tR\talbumName\x12\x14\n" +
-	"\x05genre\x18\x04 \x01(\tR\x05genre\x12 \n" +
-	"\vdescription\x18\x05 \x01(\tR\vdescription\x12\x1a\n" +
-	"\bduration\x18\x06 \x01(\x05R\bduration\x12!\n" +
-	"\frelease_year\x18\a \x01(\x05R\vreleaseYear\x12?\n" +
-	"\x0eadd_to_db_date\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\vaddToDbDate\x12#\n" +
-	"\rtrack_picture\x18\t \x01(\fR\ftrackPicture\x12#\n" +
-	"\rmusic_content\x18\n" +
-	" \x01(\fR\fmusicContent\x12\x14\n" +
-	"\x05owner\x18\v \x01(\tR\x05owner\x12\x18\n" +
-	"\atrackID\x18\f \x01(\x05R\atrackID\"-\n" +
-	"\x13UploadMusicResponse\x12\x16\n" +
-	"\x06result\x18\x01 \x01(\tR\x06result\"r\n" +
-	"\x12StreamMusicRequest\x12\x1a\n" +
-	"\busername\x18\x01 \x01(\tR\busername\x12\x19\n" +
-	"\btrack_id\x18\x02 \x01(\tR\atrackId\x12%\n" +
-	"\x0estart_position\x18\x03 \x01(\x03R\rstartPosition\")\n" +
-	"\x13StreamMusicResponse\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\fR\x04data\"+\n" +
-	"\x0eGetMetaRequest\x12\x19\n" +
-	"\btrack_id\x18\x01 \x01(\x05R\atrackId\"\xa0\x03\n" +
-	"\x0fGetMetaResponse\x12\x1f\n" +
-	"\vartist_name\x18\x01 \x01(\tR\n" +
-	"artistName\x12\x14\n" +
-	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1d\n" +
-	"\n" +
-	"album_name\x18\x03 \x01(\tR\talbumName\x12\x14\n" +
-	"\x05genre\x18\x04 \x01(\tR\x05genre\x12 \n" +
-	"\vdescription\x18\x05 \x01(\tR\vdescription\x12\x1a\n" +
-	"\bduration\x18\b \x01(\x05R\bduration\x12!\n" +
-	"\frelease_year\x18\x06 \x01(\x05R\vreleaseYear\x12?\n" +
-	"\x0eadd_to_db_date\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vaddToDbDate\x12\x14\n" +
-	"\x05owner\x18\t \x01(\tR\x05owner\x12\x14\n" +
-	"\x05likes\x18\n" +
-	" \x01(\x03R\x05likes\x12\x14\n" +
-	"\x05plays\x18\v \x01(\x03R\x05plays\x12#\n" +
-	"\rtrack_picture\x18\f \x01(\fR\ftrackPicture\x12\x18\n" +
-	"\atrackID\x18\r \x01(\x05R\atrackID2\x86\x02\n" +
-	"\fMusicService\x12T\n" +
-	"\vUploadMusic\x12!.music_service.UploadMusicRequest\x1a\".music_service.UploadMusicResponse\x12V\n" +
-	"\vStreamMusic\x12!.music_service.StreamMusicRequest\x1a\".music_service.StreamMusicResponse0\x01\x12H\n" +
-	"\aGetMeta\x12\x1d.music_service.GetMetaRequest\x1a\x1e.music_service.GetMetaResponseB\x1dZ\x1bmusic-service/api/proto/genb\x06proto3"
+func (x *GetMetaResponse) GetTruePeak() float64 {
+	if x != nil {
+		return x.TruePeak
+	}
+	return 0
+}
 
-var (
-	file_backend_music_service_api_proto_music_service_proto_rawDescOnce sync.Once
-	file_backend_music_service_api_proto_music_service_proto_rawDescData []byte
-)
+func (x *GetMetaResponse) GetLoudnessRange() float64 {
+	if x != nil {
+		return x.LoudnessRange
+	}
+	return 0
+}
 
-func file_backend_music_service_api_proto_music_service_proto_rawDescGZIP() []byte {
-	file_backend_music_service_api_proto_music_service_proto_rawDescOnce.Do(func() {
-		file_backend_music_service_api_proto_music_service_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_backend_music_service_api_proto_music_service_proto_rawDesc), len(file_backend_music_service_api_proto_music_service_proto_rawDesc)))
-	})
-	return file_backend_music_service_api_proto_music_service_proto_rawDescData
+func (x *GetMetaResponse) GetNormalizedRendition() bool {
+	if x != nil {
+		return x.NormalizedRendition
+	}
+	return false
 }
 
-var file_backend_music_service_api_proto_music_service_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
-var file_backend_music_service_api_proto_music_service_proto_goTypes = []any{
-	(*UploadMusicRequest)(nil),    // 0: music_service.UploadMusicRequest
-	(*UploadMusicResponse)(nil),   // 1: music_service.UploadMusicResponse
-	(*StreamMusicRequest)(nil),    // 2: music_service.StreamMusicRequest
-	(*StreamMusicResponse)(nil),   // 3: music_service.StreamMusicResponse
-	(*GetMetaRequest)(nil),        // 4: music_service.GetMetaRequest
-	(*GetMetaResponse)(nil),       // 5: music_service.GetMetaResponse
-	(*timestamppb.Timestamp)(nil), // 6: google.protobuf.Timestamp
+func (x *GetMetaResponse) GetPictureUrl() string {
+	if x != nil {
+		return x.PictureUrl
+	}
+	return ""
 }
-var file_backend_music_service_api_proto_music_service_proto_depIdxs = []int32{
-	6, // 0: music_service.UploadMusicRequest.add_to_db_date:type_name -> google.protobuf.Timestamp
-	6, // 1: music_service.GetMetaResponse.add_to_db_date:type_name -> google.protobuf.Timestamp
-	0, // 2: music_service.MusicService.UploadMusic:input_type -> music_service.UploadMusicRequest
-	2, // 3: music_service.MusicService.StreamMusic:input_type -> music_service.StreamMusicRequest
-	4, // 4: music_service.MusicService.GetMeta:input_type -> music_service.GetMetaRequest
-	1, // 5: music_service.MusicService.UploadMusic:output_type -> music_service.UploadMusicResponse
-	3, // 6: music_service.MusicService.StreamMusic:output_type -> music_service.StreamMusicResponse
-	5, // 7: music_service.MusicService.GetMeta:output_type -> music_service.GetMetaResponse
-	5, // [5:8] is the sub-list for method output_type
-	2, // [2:5] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+
+func (x *GetMetaResponse) GetAudioVersion() int32 {
+	if x != nil {
+		return x.AudioVersion
+	}
+	return 0
 }
 
-func init() { file_backend_music_service_api_proto_music_service_proto_init() }
-func file_backend_music_service_api_proto_music_service_proto_init() {
-	if File_backend_music_service_api_proto_music_service_proto != nil {
-		return
+func (x *GetMetaResponse) GetRollbackAvailable() bool {
+	if x != nil {
+		return x.RollbackAvailable
+	}
+	return false
+}
+
+func (x *GetMetaResponse) GetVisibility() string {
+	if x != nil {
+		return x.Visibility
+	}
+	return ""
+}
+
+type GetUploadStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TrackId       int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUploadStatusRequest) Reset() {
+	*x = GetUploadStatusRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUploadStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUploadStatusRequest) ProtoMessage() {}
+
+func (x *GetUploadStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUploadStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetUploadStatusRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetUploadStatusRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+// GetUploadStatusResponse status - одно из queued/processing/ready/failed, error заполнен для failed
+type GetUploadStatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TrackID       int32                  `protobuf:"varint,1,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Owner         string                 `protobuf:"bytes,4,opt,name=owner,proto3" json:"owner,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUploadStatusResponse) Reset() {
+	*x = GetUploadStatusResponse{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUploadStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUploadStatusResponse) ProtoMessage() {}
+
+func (x *GetUploadStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUploadStatusResponse.ProtoReflect.Descriptor instead.
+func (*GetUploadStatusResponse) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetUploadStatusResponse) GetTrackID() int32 {
+	if x != nil {
+		return x.TrackID
+	}
+	return 0
+}
+
+func (x *GetUploadStatusResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *GetUploadStatusResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *GetUploadStatusResponse) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+// GetWaveformRequest resolution - желаемое число пар min/max, 0 - наибольшее доступное
+type GetWaveformRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	TrackId    int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Resolution int32                  `protobuf:"varint,2,opt,name=resolution,proto3" json:"resolution,omitempty"`
+	// listener как в GetMetaRequest
+	Listener      string `protobuf:"bytes,3,opt,name=listener,proto3" json:"listener,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWaveformRequest) Reset() {
+	*x = GetWaveformRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWaveformRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWaveformRequest) ProtoMessage() {}
+
+func (x *GetWaveformRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWaveformRequest.ProtoReflect.Descriptor instead.
+func (*GetWaveformRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetWaveformRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+func (x *GetWaveformRequest) GetResolution() int32 {
+	if x != nil {
+		return x.Resolution
+	}
+	return 0
+}
+
+func (x *GetWaveformRequest) GetListener() string {
+	if x != nil {
+		return x.Listener
+	}
+	return ""
+}
+
+// GetWaveformResponse peaks - чередующиеся min,max в диапазоне -128..127
+type GetWaveformResponse struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	TrackID              int32                  `protobuf:"varint,1,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	Resolution           int32                  `protobuf:"varint,2,opt,name=resolution,proto3" json:"resolution,omitempty"`
+	Duration             float64                `protobuf:"fixed64,3,opt,name=duration,proto3" json:"duration,omitempty"`
+	Peaks                []int32                `protobuf:"zigzag32,4,rep,packed,name=peaks,proto3" json:"peaks,omitempty"`
+	AvailableResolutions []int32                `protobuf:"varint,5,rep,packed,name=available_resolutions,json=availableResolutions,proto3" json:"available_resolutions,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *GetWaveformResponse) Reset() {
+	*x = GetWaveformResponse{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWaveformResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWaveformResponse) ProtoMessage() {}
+
+func (x *GetWaveformResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWaveformResponse.ProtoReflect.Descriptor instead.
+func (*GetWaveformResponse) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetWaveformResponse) GetTrackID() int32 {
+	if x != nil {
+		return x.TrackID
+	}
+	return 0
+}
+
+func (x *GetWaveformResponse) GetResolution() int32 {
+	if x != nil {
+		return x.Resolution
+	}
+	return 0
+}
+
+func (x *GetWaveformResponse) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *GetWaveformResponse) GetPeaks() []int32 {
+	if x != nil {
+		return x.Peaks
+	}
+	return nil
+}
+
+func (x *GetWaveformResponse) GetAvailableResolutions() []int32 {
+	if x != nil {
+		return x.AvailableResolutions
+	}
+	return nil
+}
+
+// ProbeUploadResponse метаданные и обложка, найденные в тегах файла, для предзаполнения формы загрузки
+type ProbeUploadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ArtistName    string                 `protobuf:"bytes,1,opt,name=artist_name,json=artistName,proto3" json:"artist_name,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	AlbumName     string                 `protobuf:"bytes,3,opt,name=album_name,json=albumName,proto3" json:"album_name,omitempty"`
+	Genre         string                 `protobuf:"bytes,4,opt,name=genre,proto3" json:"genre,omitempty"`
+	Description   string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
+	ReleaseYear   int32                  `protobuf:"varint,6,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
+	Duration      int32                  `protobuf:"varint,7,opt,name=duration,proto3" json:"duration,omitempty"`
+	TrackPicture  []byte                 `protobuf:"bytes,8,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProbeUploadResponse) Reset() {
+	*x = ProbeUploadResponse{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProbeUploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProbeUploadResponse) ProtoMessage() {}
+
+func (x *ProbeUploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProbeUploadResponse.ProtoReflect.Descriptor instead.
+func (*ProbeUploadResponse) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ProbeUploadResponse) GetArtistName() string {
+	if x != nil {
+		return x.ArtistName
+	}
+	return ""
+}
+
+func (x *ProbeUploadResponse) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *ProbeUploadResponse) GetAlbumName() string {
+	if x != nil {
+		return x.AlbumName
+	}
+	return ""
+}
+
+func (x *ProbeUploadResponse) GetGenre() string {
+	if x != nil {
+		return x.Genre
+	}
+	return ""
+}
+
+func (x *ProbeUploadResponse) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ProbeUploadResponse) GetReleaseYear() int32 {
+	if x != nil {
+		return x.ReleaseYear
+	}
+	return 0
+}
+
+func (x *ProbeUploadResponse) GetDuration() int32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *ProbeUploadResponse) GetTrackPicture() []byte {
+	if x != nil {
+		return x.TrackPicture
+	}
+	return nil
+}
+
+// DeleteTrackRequest username - пользователь, от имени которого удаляется трек, должен быть его владельцем
+type DeleteTrackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TrackId       int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteTrackRequest) Reset() {
+	*x = DeleteTrackRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteTrackRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteTrackRequest) ProtoMessage() {}
+
+func (x *DeleteTrackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteTrackRequest.ProtoReflect.Descriptor instead.
+func (*DeleteTrackRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *DeleteTrackRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+func (x *DeleteTrackRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+// DeleteTrackResponse playlists_updated - из скольких плейлистов пришлось убрать трек
+type DeleteTrackResponse struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	TrackID          int32                  `protobuf:"varint,1,opt,name=trackID,proto3" json:"trackID,omitempty"`
+	PlaylistsUpdated int32                  `protobuf:"varint,2,opt,name=playlists_updated,json=playlistsUpdated,proto3" json:"playlists_updated,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *DeleteTrackResponse) Reset() {
+	*x = DeleteTrackResponse{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteTrackResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteTrackResponse) ProtoMessage() {}
+
+func (x *DeleteTrackResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteTrackResponse.ProtoReflect.Descriptor instead.
+func (*DeleteTrackResponse) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *DeleteTrackResponse) GetTrackID() int32 {
+	if x != nil {
+		return x.TrackID
+	}
+	return 0
+}
+
+func (x *DeleteTrackResponse) GetPlaylistsUpdated() int32 {
+	if x != nil {
+		return x.PlaylistsUpdated
+	}
+	return 0
+}
+
+// UpdateTrackMetaRequest меняет только переданные поля, username должен быть владельцем трека.
+// Новая обложка проходит ту же обработку, что и при загрузке, пустая track_picture оставляет старую
+type UpdateTrackMetaRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	TrackId      int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Username     string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Title        *string                `protobuf:"bytes,3,opt,name=title,proto3,oneof" json:"title,omitempty"`
+	AlbumName    *string                `protobuf:"bytes,4,opt,name=album_name,json=albumName,proto3,oneof" json:"album_name,omitempty"`
+	Genre        *string                `protobuf:"bytes,5,opt,name=genre,proto3,oneof" json:"genre,omitempty"`
+	Description  *string                `protobuf:"bytes,6,opt,name=description,proto3,oneof" json:"description,omitempty"`
+	ReleaseYear  *int32                 `protobuf:"varint,7,opt,name=release_year,json=releaseYear,proto3,oneof" json:"release_year,omitempty"`
+	TrackPicture []byte                 `protobuf:"bytes,8,opt,name=track_picture,json=trackPicture,proto3" json:"track_picture,omitempty"`
+	// public, unlisted или private: ссылки на HLS непубличного трека выдаются только после проверки JWT
+	Visibility    *string `protobuf:"bytes,9,opt,name=visibility,proto3,oneof" json:"visibility,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateTrackMetaRequest) Reset() {
+	*x = UpdateTrackMetaRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateTrackMetaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateTrackMetaRequest) ProtoMessage() {}
+
+func (x *UpdateTrackMetaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateTrackMetaRequest.ProtoReflect.Descriptor instead.
+func (*UpdateTrackMetaRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *UpdateTrackMetaRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+func (x *UpdateTrackMetaRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *UpdateTrackMetaRequest) GetTitle() string {
+	if x != nil && x.Title != nil {
+		return *x.Title
+	}
+	return ""
+}
+
+func (x *UpdateTrackMetaRequest) GetAlbumName() string {
+	if x != nil && x.AlbumName != nil {
+		return *x.AlbumName
+	}
+	return ""
+}
+
+func (x *UpdateTrackMetaRequest) GetGenre() string {
+	if x != nil && x.Genre != nil {
+		return *x.Genre
+	}
+	return ""
+}
+
+func (x *UpdateTrackMetaRequest) GetDescription() string {
+	if x != nil && x.Description != nil {
+		return *x.Description
+	}
+	return ""
+}
+
+func (x *UpdateTrackMetaRequest) GetReleaseYear() int32 {
+	if x != nil && x.ReleaseYear != nil {
+		return *x.ReleaseYear
+	}
+	return 0
+}
+
+func (x *UpdateTrackMetaRequest) GetTrackPicture() []byte {
+	if x != nil {
+		return x.TrackPicture
+	}
+	return nil
+}
+
+func (x *UpdateTrackMetaRequest) GetVisibility() string {
+	if x != nil && x.Visibility != nil {
+		return *x.Visibility
+	}
+	return ""
+}
+
+// RollbackTrackAudioRequest возвращает предыдущую версию звука, текущая становится предыдущей
+type RollbackTrackAudioRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TrackId       int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RollbackTrackAudioRequest) Reset() {
+	*x = RollbackTrackAudioRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RollbackTrackAudioRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RollbackTrackAudioRequest) ProtoMessage() {}
+
+func (x *RollbackTrackAudioRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RollbackTrackAudioRequest.ProtoReflect.Descriptor instead.
+func (*RollbackTrackAudioRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *RollbackTrackAudioRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+func (x *RollbackTrackAudioRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+// GetTrackKeyRequest username - вошедший пользователь, которому нужен ключ для воспроизведения
+type GetTrackKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TrackId       int32                  `protobuf:"varint,1,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Username      string                 `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackKeyRequest) Reset() {
+	*x = GetTrackKeyRequest{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackKeyRequest) ProtoMessage() {}
+
+func (x *GetTrackKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackKeyRequest.ProtoReflect.Descriptor instead.
+func (*GetTrackKeyRequest) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *GetTrackKeyRequest) GetTrackId() int32 {
+	if x != nil {
+		return x.TrackId
+	}
+	return 0
+}
+
+func (x *GetTrackKeyRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+// GetTrackKeyResponse ключ AES-128 сегментов HLS трека
+type GetTrackKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackKeyResponse) Reset() {
+	*x = GetTrackKeyResponse{}
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackKeyResponse) ProtoMessage() {}
+
+func (x *GetTrackKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_backend_music_service_api_proto_music_service_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackKeyResponse.ProtoReflect.Descriptor instead.
+func (*GetTrackKeyResponse) Descriptor() ([]byte, []int) {
+	return file_backend_music_service_api_proto_music_service_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetTrackKeyResponse) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+var File_backend_music_service_api_proto_music_service_proto protoreflect.FileDescriptor
+
+const file_backend_music_service_api_proto_music_service_proto_rawDesc = "" +
+	"\n" +
+	"3backend/music-service/api/proto/music_service.proto\x12\rmusic_service\x1a\x1fgoogle/protobuf/timestamp.proto\"\x93\x04\n" +
+	"\x12UploadMusicRequest\x12\x1f\n" +
+	"\vartist_name\x18\x01 \x01(\tR\n" +
+	"artistName\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1d\n" +
+	"\n" +
+	"album_name\x18\x03 \x01(\tR\talbumName\x12\x14\n" +
+	"\x05genre\x18\x04 \x01(\tR\x05genre\x12 \n" +
+	"\vdescription\x18\x05 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bduration\x18\x06 \x01(\x05R\bduration\x12!\n" +
+	"\frelease_year\x18\a \x01(\x05R\vreleaseYear\x12?\n" +
+	"\x0eadd_to_db_date\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\vaddToDbDate\x12#\n" +
+	"\rtrack_picture\x18\t \x01(\fR\ftrackPicture\x12#\n" +
+	"\rmusic_content\x18\n" +
+	" \x01(\fR\fmusicContent\x12\x14\n" +
+	"\x05owner\x18\v \x01(\tR\x05owner\x12\x18\n" +
+	"\atrackID\x18\f \x01(\x05R\atrackID\x12-\n" +
+	"\x12normalize_loudness\x18\r \x01(\bR\x11normalizeLoudness\x12\x1b\n" +
+	"\tupload_id\x18\x0e \x01(\tR\buploadId\x12)\n" +
+	"\x10encrypt_segments\x18\x0f \x01(\bR\x0fencryptSegments\"y\n" +
+	"\x10UploadMusicChunk\x127\n" +
+	"\x04meta\x18\x01 \x01(\v2!.music_service.UploadMusicRequestH\x00R\x04meta\x12!\n" +
+	"\vmusic_chunk\x18\x02 \x01(\fH\x00R\n" +
+	"musicChunkB\t\n" +
+	"\apayload\"\x82\x01\n" +
+	"\x13UploadMusicResponse\x12\x16\n" +
+	"\x06result\x18\x01 \x01(\tR\x06result\x12\x18\n" +
+	"\atrackID\x18\x02 \x01(\x05R\atrackID\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12!\n" +
+	"\fduplicate_of\x18\x04 \x01(\x05R\vduplicateOf\"\xa8\x01\n" +
+	"\x12StreamMusicRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x19\n" +
+	"\btrack_id\x18\x02 \x01(\tR\atrackId\x12%\n" +
+	"\x0estart_position\x18\x03 \x01(\x03R\rstartPosition\x12\x18\n" +
+	"\aquality\x18\x04 \x01(\tR\aquality\x12\x1a\n" +
+	"\blistener\x18\x05 \x01(\tR\blistener\"y\n" +
+	"\x13StreamMusicResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12#\n" +
+	"\rsegment_start\x18\x02 \x01(\x01R\fsegmentStart\x12)\n" +
+	"\x10segment_duration\x18\x03 \x01(\x01R\x0fsegmentDuration\"\x93\x01\n" +
+	"\x0eGetMetaRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12!\n" +
+	"\fpicture_size\x18\x02 \x01(\x05R\vpictureSize\x12'\n" +
+	"\x0finclude_picture\x18\x03 \x01(\bR\x0eincludePicture\x12\x1a\n" +
+	"\blistener\x18\x04 \x01(\tR\blistener\"\xdd\x05\n" +
+	"\x0fGetMetaResponse\x12\x1f\n" +
+	"\vartist_name\x18\x01 \x01(\tR\n" +
+	"artistName\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1d\n" +
+	"\n" +
+	"album_name\x18\x03 \x01(\tR\talbumName\x12\x14\n" +
+	"\x05genre\x18\x04 \x01(\tR\x05genre\x12 \n" +
+	"\vdescription\x18\x05 \x01(\tR\vdescription\x12\x1a\n" +
+	"\bduration\x18\b \x01(\x05R\bduration\x12!\n" +
+	"\frelease_year\x18\x06 \x01(\x05R\vreleaseYear\x12?\n" +
+	"\x0eadd_to_db_date\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vaddToDbDate\x12\x14\n" +
+	"\x05owner\x18\t \x01(\tR\x05owner\x12\x14\n" +
+	"\x05likes\x18\n" +
+	" \x01(\x03R\x05likes\x12\x14\n" +
+	"\x05plays\x18\v \x01(\x03R\x05plays\x12#\n" +
+	"\rtrack_picture\x18\f \x01(\fR\ftrackPicture\x12\x18\n" +
+	"\atrackID\x18\r \x01(\x05R\atrackID\x12/\n" +
+	"\x13integrated_loudness\x18\x0e \x01(\x01R\x12integratedLoudness\x12\x1b\n" +
+	"\ttrue_peak\x18\x0f \x01(\x01R\btruePeak\x12%\n" +
+	"\x0eloudness_range\x18\x10 \x01(\x01R\rloudnessRange\x121\n" +
+	"\x14normalized_rendition\x18\x11 \x01(\bR\x13normalizedRendition\x12\x1f\n" +
+	"\vpicture_url\x18\x12 \x01(\tR\n" +
+	"pictureUrl\x12#\n" +
+	"\raudio_version\x18\x13 \x01(\x05R\faudioVersion\x12-\n" +
+	"\x12rollback_available\x18\x14 \x01(\bR\x11rollbackAvailable\x12\x1e\n" +
+	"\n" +
+	"visibility\x18\x15 \x01(\tR\n" +
+	"visibility\"3\n" +
+	"\x16GetUploadStatusRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\"w\n" +
+	"\x17GetUploadStatusResponse\x12\x18\n" +
+	"\atrackID\x18\x01 \x01(\x05R\atrackID\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12\x14\n" +
+	"\x05owner\x18\x04 \x01(\tR\x05owner\"k\n" +
+	"\x12GetWaveformRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12\x1e\n" +
+	"\n" +
+	"resolution\x18\x02 \x01(\x05R\n" +
+	"resolution\x12\x1a\n" +
+	"\blistener\x18\x03 \x01(\tR\blistener\"\xb6\x01\n" +
+	"\x13GetWaveformResponse\x12\x18\n" +
+	"\atrackID\x18\x01 \x01(\x05R\atrackID\x12\x1e\n" +
+	"\n" +
+	"resolution\x18\x02 \x01(\x05R\n" +
+	"resolution\x12\x1a\n" +
+	"\bduration\x18\x03 \x01(\x01R\bduration\x12\x14\n" +
+	"\x05peaks\x18\x04 \x03(\x11R\x05peaks\x123\n" +
+	"\x15available_resolutions\x18\x05 \x03(\x05R\x14availableResolutions\"\x87\x02\n" +
+	"\x13ProbeUploadResponse\x12\x1f\n" +
+	"\vartist_name\x18\x01 \x01(\tR\n" +
+	"artistName\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x1d\n" +
+	"\n" +
+	"album_name\x18\x03 \x01(\tR\talbumName\x12\x14\n" +
+	"\x05genre\x18\x04 \x01(\tR\x05genre\x12 \n" +
+	"\vdescription\x18\x05 \x01(\tR\vdescription\x12!\n" +
+	"\frelease_year\x18\x06 \x01(\x05R\vreleaseYear\x12\x1a\n" +
+	"\bduration\x18\a \x01(\x05R\bduration\x12#\n" +
+	"\rtrack_picture\x18\b \x01(\fR\ftrackPicture\"K\n" +
+	"\x12DeleteTrackRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\"\\\n" +
+	"\x13DeleteTrackResponse\x12\x18\n" +
+	"\atrackID\x18\x01 \x01(\x05R\atrackID\x12+\n" +
+	"\x11playlists_updated\x18\x02 \x01(\x05R\x10playlistsUpdated\"\x95\x03\n" +
+	"\x16UpdateTrackMetaRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\x12\x19\n" +
+	"\x05title\x18\x03 \x01(\tH\x00R\x05title\x88\x01\x01\x12\"\n" +
+	"\n" +
+	"album_name\x18\x04 \x01(\tH\x01R\talbumName\x88\x01\x01\x12\x19\n" +
+	"\x05genre\x18\x05 \x01(\tH\x02R\x05genre\x88\x01\x01\x12%\n" +
+	"\vdescription\x18\x06 \x01(\tH\x03R\vdescription\x88\x01\x01\x12&\n" +
+	"\frelease_year\x18\a \x01(\x05H\x04R\vreleaseYear\x88\x01\x01\x12#\n" +
+	"\rtrack_picture\x18\b \x01(\fR\ftrackPicture\x12#\n" +
+	"\n" +
+	"visibility\x18\t \x01(\tH\x05R\n" +
+	"visibility\x88\x01\x01B\b\n" +
+	"\x06_titleB\r\n" +
+	"\v_album_nameB\b\n" +
+	"\x06_genreB\x0e\n" +
+	"\f_descriptionB\x0f\n" +
+	"\r_release_yearB\r\n" +
+	"\v_visibility\"R\n" +
+	"\x19RollbackTrackAudioRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\"K\n" +
+	"\x12GetTrackKeyRequest\x12\x19\n" +
+	"\btrack_id\x18\x01 \x01(\x05R\atrackId\x12\x1a\n" +
+	"\busername\x18\x02 \x01(\tR\busername\"'\n" +
+	"\x13GetTrackKeyResponse\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key2\xb2\b\n" +
+	"\fMusicService\x12T\n" +
+	"\vUploadMusic\x12!.music_service.UploadMusicRequest\x1a\".music_service.UploadMusicResponse\x12Z\n" +
+	"\x11UploadMusicStream\x12\x1f.music_service.UploadMusicChunk\x1a\".music_service.UploadMusicResponse(\x01\x12T\n" +
+	"\vProbeUpload\x12\x1f.music_service.UploadMusicChunk\x1a\".music_service.ProbeUploadResponse(\x01\x12V\n" +
+	"\vStreamMusic\x12!.music_service.StreamMusicRequest\x1a\".music_service.StreamMusicResponse0\x01\x12H\n" +
+	"\aGetMeta\x12\x1d.music_service.GetMetaRequest\x1a\x1e.music_service.GetMetaResponse\x12`\n" +
+	"\x0fGetUploadStatus\x12%.music_service.GetUploadStatusRequest\x1a&.music_service.GetUploadStatusResponse\x12T\n" +
+	"\vGetWaveform\x12!.music_service.GetWaveformRequest\x1a\".music_service.GetWaveformResponse\x12T\n" +
+	"\vDeleteTrack\x12!.music_service.DeleteTrackRequest\x1a\".music_service.DeleteTrackResponse\x12X\n" +
+	"\x0fUpdateTrackMeta\x12%.music_service.UpdateTrackMetaRequest\x1a\x1e.music_service.GetMetaResponse\x12Z\n" +
+	"\x11ReplaceTrackAudio\x12\x1f.music_service.UploadMusicChunk\x1a\".music_service.UploadMusicResponse(\x01\x12^\n" +
+	"\x12RollbackTrackAudio\x12(.music_service.RollbackTrackAudioRequest\x1a\x1e.music_service.GetMetaResponse\x12T\n" +
+	"\vGetTrackKey\x12!.music_service.GetTrackKeyRequest\x1a\".music_service.GetTrackKeyResponseB\x1dZ\x1bmusic-service/api/proto/genb\x06proto3"
+
+var (
+	file_backend_music_service_api_proto_music_service_proto_rawDescOnce sync.Once
+	file_backend_music_service_api_proto_music_service_proto_rawDescData []byte
+)
+
+func file_backend_music_service_api_proto_music_service_proto_rawDescGZIP() []byte {
+	file_backend_music_service_api_proto_music_service_proto_rawDescOnce.Do(func() {
+		file_backend_music_service_api_proto_music_service_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_backend_music_service_api_proto_music_service_proto_rawDesc), len(file_backend_music_service_api_proto_music_service_proto_rawDesc)))
+	})
+	return file_backend_music_service_api_proto_music_service_proto_rawDescData
+}
+
+var file_backend_music_service_api_proto_music_service_proto_msgTypes = make([]protoimpl.MessageInfo, 18)
+var file_backend_music_service_api_proto_music_service_proto_goTypes = []any{
+	(*UploadMusicRequest)(nil),        // 0: music_service.UploadMusicRequest
+	(*UploadMusicChunk)(nil),          // 1: music_service.UploadMusicChunk
+	(*UploadMusicResponse)(nil),       // 2: music_service.UploadMusicResponse
+	(*StreamMusicRequest)(nil),        // 3: music_service.StreamMusicRequest
+	(*StreamMusicResponse)(nil),       // 4: music_service.StreamMusicResponse
+	(*GetMetaRequest)(nil),            // 5: music_service.GetMetaRequest
+	(*GetMetaResponse)(nil),           // 6: music_service.GetMetaResponse
+	(*GetUploadStatusRequest)(nil),    // 7: music_service.GetUploadStatusRequest
+	(*GetUploadStatusResponse)(nil),   // 8: music_service.GetUploadStatusResponse
+	(*GetWaveformRequest)(nil),        // 9: music_service.GetWaveformRequest
+	(*GetWaveformResponse)(nil),       // 10: music_service.GetWaveformResponse
+	(*ProbeUploadResponse)(nil),       // 11: music_service.ProbeUploadResponse
+	(*DeleteTrackRequest)(nil),        // 12: music_service.DeleteTrackRequest
+	(*DeleteTrackResponse)(nil),       // 13: music_service.DeleteTrackResponse
+	(*UpdateTrackMetaRequest)(nil),    // 14: music_service.UpdateTrackMetaRequest
+	(*RollbackTrackAudioRequest)(nil), // 15: music_service.RollbackTrackAudioRequest
+	(*GetTrackKeyRequest)(nil),        // 16: music_service.GetTrackKeyRequest
+	(*GetTrackKeyResponse)(nil),       // 17: music_service.GetTrackKeyResponse
+	(*timestamppb.Timestamp)(nil),     // 18: google.protobuf.Timestamp
+}
+var file_backend_music_service_api_proto_music_service_proto_depIdxs = []int32{
+	18, // 0: music_service.UploadMusicRequest.add_to_db_date:type_name -> google.protobuf.Timestamp
+	0,  // 1: music_service.UploadMusicChunk.meta:type_name -> music_service.UploadMusicRequest
+	18, // 2: music_service.GetMetaResponse.add_to_db_date:type_name -> google.protobuf.Timestamp
+	0,  // 3: music_service.MusicService.UploadMusic:input_type -> music_service.UploadMusicRequest
+	1,  // 4: music_service.MusicService.UploadMusicStream:input_type -> music_service.UploadMusicChunk
+	1,  // 5: music_service.MusicService.ProbeUpload:input_type -> music_service.UploadMusicChunk
+	3,  // 6: music_service.MusicService.StreamMusic:input_type -> music_service.StreamMusicRequest
+	5,  // 7: music_service.MusicService.GetMeta:input_type -> music_service.GetMetaRequest
+	7,  // 8: music_service.MusicService.GetUploadStatus:input_type -> music_service.GetUploadStatusRequest
+	9,  // 9: music_service.MusicService.GetWaveform:input_type -> music_service.GetWaveformRequest
+	12, // 10: music_service.MusicService.DeleteTrack:input_type -> music_service.DeleteTrackRequest
+	14, // 11: music_service.MusicService.UpdateTrackMeta:input_type -> music_service.UpdateTrackMetaRequest
+	1,  // 12: music_service.MusicService.ReplaceTrackAudio:input_type -> music_service.UploadMusicChunk
+	15, // 13: music_service.MusicService.RollbackTrackAudio:input_type -> music_service.RollbackTrackAudioRequest
+	16, // 14: music_service.MusicService.GetTrackKey:input_type -> music_service.GetTrackKeyRequest
+	2,  // 15: music_service.MusicService.UploadMusic:output_type -> music_service.UploadMusicResponse
+	2,  // 16: music_service.MusicService.UploadMusicStream:output_type -> music_service.UploadMusicResponse
+	11, // 17: music_service.MusicService.ProbeUpload:output_type -> music_service.ProbeUploadResponse
+	4,  // 18: music_service.MusicService.StreamMusic:output_type -> music_service.StreamMusicResponse
+	6,  // 19: music_service.MusicService.GetMeta:output_type -> music_service.GetMetaResponse
+	8,  // 20: music_service.MusicService.GetUploadStatus:output_type -> music_service.GetUploadStatusResponse
+	10, // 21: music_service.MusicService.GetWaveform:output_type -> music_service.GetWaveformResponse
+	13, // 22: music_service.MusicService.DeleteTrack:output_type -> music_service.DeleteTrackResponse
+	6,  // 23: music_service.MusicService.UpdateTrackMeta:output_type -> music_service.GetMetaResponse
+	2,  // 24: music_service.MusicService.ReplaceTrackAudio:output_type -> music_service.UploadMusicResponse
+	6,  // 25: music_service.MusicService.RollbackTrackAudio:output_type -> music_service.GetMetaResponse
+	17, // 26: music_service.MusicService.GetTrackKey:output_type -> music_service.GetTrackKeyResponse
+	15, // [15:27] is the sub-list for method output_type
+	3,  // [3:15] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_backend_music_service_api_proto_music_service_proto_init() }
+func file_backend_music_service_api_proto_music_service_proto_init() {
+	if File_backend_music_service_api_proto_music_service_proto != nil {
+		return
+	}
+	file_backend_music_service_api_proto_music_service_proto_msgTypes[1].OneofWrappers = []any{
+		(*UploadMusicChunk_Meta)(nil),
+		(*UploadMusicChunk_MusicChunk)(nil),
 	}
+	file_backend_music_service_api_proto_music_service_proto_msgTypes[14].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_backend_music_service_api_proto_music_service_proto_rawDesc), len(file_backend_music_service_api_proto_music_service_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   18,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -20,9 +20,18 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MusicService_UploadMusic_FullMethodName = "/music_service.MusicService/UploadMusic"
-	MusicService_StreamMusic_FullMethodName = "/music_service.MusicService/StreamMusic"
-	MusicService_GetMeta_FullMethodName     = "/music_service.MusicService/GetMeta"
+	MusicService_UploadMusic_FullMethodName        = "/music_service.MusicService/UploadMusic"
+	MusicService_UploadMusicStream_FullMethodName  = "/music_service.MusicService/UploadMusicStream"
+	MusicService_ProbeUpload_FullMethodName        = "/music_service.MusicService/ProbeUpload"
+	MusicService_StreamMusic_FullMethodName        = "/music_service.MusicService/StreamMusic"
+	MusicService_GetMeta_FullMethodName            = "/music_service.MusicService/GetMeta"
+	MusicService_GetUploadStatus_FullMethodName    = "/music_service.MusicService/GetUploadStatus"
+	MusicService_GetWaveform_FullMethodName        = "/music_service.MusicService/GetWaveform"
+	MusicService_DeleteTrack_FullMethodName        = "/music_service.MusicService/DeleteTrack"
+	MusicService_UpdateTrackMeta_FullMethodName    = "/music_service.MusicService/UpdateTrackMeta"
+	MusicService_ReplaceTrackAudio_FullMethodName  = "/music_service.MusicService/ReplaceTrackAudio"
+	MusicService_RollbackTrackAudio_FullMethodName = "/music_service.MusicService/RollbackTrackAudio"
+	MusicService_GetTrackKey_FullMethodName        = "/music_service.MusicService/GetTrackKey"
 )
 
 // MusicServiceClient is the client API for MusicService service.
@@ -30,8 +39,17 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MusicServiceClient interface {
 	UploadMusic(ctx context.Context, in *UploadMusicRequest, opts ...grpc.CallOption) (*UploadMusicResponse, error)
+	UploadMusicStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse], error)
+	ProbeUpload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, ProbeUploadResponse], error)
 	StreamMusic(ctx context.Context, in *StreamMusicRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamMusicResponse], error)
 	GetMeta(ctx context.Context, in *GetMetaRequest, opts ...grpc.CallOption) (*GetMetaResponse, error)
+	GetUploadStatus(ctx context.Context, in *GetUploadStatusRequest, opts ...grpc.CallOption) (*GetUploadStatusResponse, error)
+	GetWaveform(ctx context.Context, in *GetWaveformRequest, opts ...grpc.CallOption) (*GetWaveformResponse, error)
+	DeleteTrack(ctx context.Context, in *DeleteTrackRequest, opts ...grpc.CallOption) (*DeleteTrackResponse, error)
+	UpdateTrackMeta(ctx context.Context, in *UpdateTrackMetaRequest, opts ...grpc.CallOption) (*GetMetaResponse, error)
+	ReplaceTrackAudio(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse], error)
+	RollbackTrackAudio(ctx context.Context, in *RollbackTrackAudioRequest, opts ...grpc.CallOption) (*GetMetaResponse, error)
+	GetTrackKey(ctx context.Context, in *GetTrackKeyRequest, opts ...grpc.CallOption) (*GetTrackKeyResponse, error)
 }
 
 type musicServiceClient struct {
@@ -52,9 +70,35 @@ func (c *musicServiceClient) UploadMusic(ctx context.Context, in *UploadMusicReq
 	return out, nil
 }
 
+func (c *musicServiceClient) UploadMusicStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MusicService_ServiceDesc.Streams[0], MusicService_UploadMusicStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadMusicChunk, UploadMusicResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_UploadMusicStreamClient = grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse]
+
+func (c *musicServiceClient) ProbeUpload(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, ProbeUploadResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MusicService_ServiceDesc.Streams[1], MusicService_ProbeUpload_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadMusicChunk, ProbeUploadResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_ProbeUploadClient = grpc.ClientStreamingClient[UploadMusicChunk, ProbeUploadResponse]
+
 func (c *musicServiceClient) StreamMusic(ctx context.Context, in *StreamMusicRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamMusicResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &MusicService_ServiceDesc.Streams[0], MusicService_StreamMusic_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &MusicService_ServiceDesc.Streams[2], MusicService_StreamMusic_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,13 +125,95 @@ func (c *musicServiceClient) GetMeta(ctx context.Context, in *GetMetaRequest, op
 	return out, nil
 }
 
+func (c *musicServiceClient) GetUploadStatus(ctx context.Context, in *GetUploadStatusRequest, opts ...grpc.CallOption) (*GetUploadStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUploadStatusResponse)
+	err := c.cc.Invoke(ctx, MusicService_GetUploadStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicServiceClient) GetWaveform(ctx context.Context, in *GetWaveformRequest, opts ...grpc.CallOption) (*GetWaveformResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetWaveformResponse)
+	err := c.cc.Invoke(ctx, MusicService_GetWaveform_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicServiceClient) DeleteTrack(ctx context.Context, in *DeleteTrackRequest, opts ...grpc.CallOption) (*DeleteTrackResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteTrackResponse)
+	err := c.cc.Invoke(ctx, MusicService_DeleteTrack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicServiceClient) UpdateTrackMeta(ctx context.Context, in *UpdateTrackMetaRequest, opts ...grpc.CallOption) (*GetMetaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetaResponse)
+	err := c.cc.Invoke(ctx, MusicService_UpdateTrackMeta_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicServiceClient) ReplaceTrackAudio(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MusicService_ServiceDesc.Streams[3], MusicService_ReplaceTrackAudio_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadMusicChunk, UploadMusicResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_ReplaceTrackAudioClient = grpc.ClientStreamingClient[UploadMusicChunk, UploadMusicResponse]
+
+func (c *musicServiceClient) RollbackTrackAudio(ctx context.Context, in *RollbackTrackAudioRequest, opts ...grpc.CallOption) (*GetMetaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetaResponse)
+	err := c.cc.Invoke(ctx, MusicService_RollbackTrackAudio_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *musicServiceClient) GetTrackKey(ctx context.Context, in *GetTrackKeyRequest, opts ...grpc.CallOption) (*GetTrackKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTrackKeyResponse)
+	err := c.cc.Invoke(ctx, MusicService_GetTrackKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MusicServiceServer is the server API for MusicService service.
 // All implementations must embed UnimplementedMusicServiceServer
 // for forward compatibility.
 type MusicServiceServer interface {
 	UploadMusic(context.Context, *UploadMusicRequest) (*UploadMusicResponse, error)
+	UploadMusicStream(grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]) error
+	ProbeUpload(grpc.ClientStreamingServer[UploadMusicChunk, ProbeUploadResponse]) error
 	StreamMusic(*StreamMusicRequest, grpc.ServerStreamingServer[StreamMusicResponse]) error
 	GetMeta(context.Context, *GetMetaRequest) (*GetMetaResponse, error)
+	GetUploadStatus(context.Context, *GetUploadStatusRequest) (*GetUploadStatusResponse, error)
+	GetWaveform(context.Context, *GetWaveformRequest) (*GetWaveformResponse, error)
+	DeleteTrack(context.Context, *DeleteTrackRequest) (*DeleteTrackResponse, error)
+	UpdateTrackMeta(context.Context, *UpdateTrackMetaRequest) (*GetMetaResponse, error)
+	ReplaceTrackAudio(grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]) error
+	RollbackTrackAudio(context.Context, *RollbackTrackAudioRequest) (*GetMetaResponse, error)
+	GetTrackKey(context.Context, *GetTrackKeyRequest) (*GetTrackKeyResponse, error)
 	mustEmbedUnimplementedMusicServiceServer()
 }
 
@@ -101,12 +227,39 @@ type UnimplementedMusicServiceServer struct{}
 func (UnimplementedMusicServiceServer) UploadMusic(context.Context, *UploadMusicRequest) (*UploadMusicResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UploadMusic not implemented")
 }
+func (UnimplementedMusicServiceServer) UploadMusicStream(grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadMusicStream not implemented")
+}
+func (UnimplementedMusicServiceServer) ProbeUpload(grpc.ClientStreamingServer[UploadMusicChunk, ProbeUploadResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ProbeUpload not implemented")
+}
 func (UnimplementedMusicServiceServer) StreamMusic(*StreamMusicRequest, grpc.ServerStreamingServer[StreamMusicResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method StreamMusic not implemented")
 }
 func (UnimplementedMusicServiceServer) GetMeta(context.Context, *GetMetaRequest) (*GetMetaResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMeta not implemented")
 }
+func (UnimplementedMusicServiceServer) GetUploadStatus(context.Context, *GetUploadStatusRequest) (*GetUploadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUploadStatus not implemented")
+}
+func (UnimplementedMusicServiceServer) GetWaveform(context.Context, *GetWaveformRequest) (*GetWaveformResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWaveform not implemented")
+}
+func (UnimplementedMusicServiceServer) DeleteTrack(context.Context, *DeleteTrackRequest) (*DeleteTrackResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteTrack not implemented")
+}
+func (UnimplementedMusicServiceServer) UpdateTrackMeta(context.Context, *UpdateTrackMetaRequest) (*GetMetaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateTrackMeta not implemented")
+}
+func (UnimplementedMusicServiceServer) ReplaceTrackAudio(grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ReplaceTrackAudio not implemented")
+}
+func (UnimplementedMusicServiceServer) RollbackTrackAudio(context.Context, *RollbackTrackAudioRequest) (*GetMetaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RollbackTrackAudio not implemented")
+}
+func (UnimplementedMusicServiceServer) GetTrackKey(context.Context, *GetTrackKeyRequest) (*GetTrackKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTrackKey not implemented")
+}
 func (UnimplementedMusicServiceServer) mustEmbedUnimplementedMusicServiceServer() {}
 func (UnimplementedMusicServiceServer) testEmbeddedByValue()                      {}
 
@@ -146,6 +299,20 @@ func _MusicService_UploadMusic_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MusicService_UploadMusicStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MusicServiceServer).UploadMusicStream(&grpc.GenericServerStream[UploadMusicChunk, UploadMusicResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_UploadMusicStreamServer = grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]
+
+func _MusicService_ProbeUpload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MusicServiceServer).ProbeUpload(&grpc.GenericServerStream[UploadMusicChunk, ProbeUploadResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_ProbeUploadServer = grpc.ClientStreamingServer[UploadMusicChunk, ProbeUploadResponse]
+
 func _MusicService_StreamMusic_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(StreamMusicRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -175,6 +342,121 @@ func _MusicService_GetMeta_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MusicService_GetUploadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUploadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).GetUploadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_GetUploadStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).GetUploadStatus(ctx, req.(*GetUploadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicService_GetWaveform_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWaveformRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).GetWaveform(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_GetWaveform_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).GetWaveform(ctx, req.(*GetWaveformRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicService_DeleteTrack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTrackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).DeleteTrack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_DeleteTrack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).DeleteTrack(ctx, req.(*DeleteTrackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicService_UpdateTrackMeta_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateTrackMetaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).UpdateTrackMeta(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_UpdateTrackMeta_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).UpdateTrackMeta(ctx, req.(*UpdateTrackMetaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicService_ReplaceTrackAudio_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MusicServiceServer).ReplaceTrackAudio(&grpc.GenericServerStream[UploadMusicChunk, UploadMusicResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MusicService_ReplaceTrackAudioServer = grpc.ClientStreamingServer[UploadMusicChunk, UploadMusicResponse]
+
+func _MusicService_RollbackTrackAudio_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackTrackAudioRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).RollbackTrackAudio(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_RollbackTrackAudio_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).RollbackTrackAudio(ctx, req.(*RollbackTrackAudioRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MusicService_GetTrackKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrackKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MusicServiceServer).GetTrackKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MusicService_GetTrackKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MusicServiceServer).GetTrackKey(ctx, req.(*GetTrackKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MusicService_ServiceDesc is the grpc.ServiceDesc for MusicService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -190,13 +472,52 @@ var MusicService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetMeta",
 			Handler:    _MusicService_GetMeta_Handler,
 		},
+		{
+			MethodName: "GetUploadStatus",
+			Handler:    _MusicService_GetUploadStatus_Handler,
+		},
+		{
+			MethodName: "GetWaveform",
+			Handler:    _MusicService_GetWaveform_Handler,
+		},
+		{
+			MethodName: "DeleteTrack",
+			Handler:    _MusicService_DeleteTrack_Handler,
+		},
+		{
+			MethodName: "UpdateTrackMeta",
+			Handler:    _MusicService_UpdateTrackMeta_Handler,
+		},
+		{
+			MethodName: "RollbackTrackAudio",
+			Handler:    _MusicService_RollbackTrackAudio_Handler,
+		},
+		{
+			MethodName: "GetTrackKey",
+			Handler:    _MusicService_GetTrackKey_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadMusicStream",
+			Handler:       _MusicService_UploadMusicStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ProbeUpload",
+			Handler:       _MusicService_ProbeUpload_Handler,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "StreamMusic",
 			Handler:       _MusicService_StreamMusic_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ReplaceTrackAudio",
+			Handler:       _MusicService_ReplaceTrackAudio_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "backend/music-service/api/proto/music_service.proto",
 }
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	redisOrig "github.com/redis/go-redis/v9"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisIndexesMarker ставится после полной пересборки. Пропавший ключ (FLUSHALL, новый инстанс)
+// означает, что списки и рейтинги в Redis нужно восстановить из Postgres
+const redisIndexesMarker = "redisIndexes:builtAt"
+
+// redisRebuildBatch сколько команд отправляется в Redis одним конвейером
+const redisRebuildBatch = 1000
+
+// redisRebuildStats что было восстановлено при пересборке
+type redisRebuildStats struct {
+	Users  int
+	Tracks int
+	Lists  int
+}
+
+// rebuildRedisIndexesIfMissing пересобирает индексы при старте, если Redis их потерял
+func rebuildRedisIndexesIfMissing(ctx context.Context) error {
+	exists, err := rdb.Exists(ctx, redisIndexesMarker).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 1 {
+		return nil
+	}
+
+	logger.Println("Индексы Redis не найдены, пересборка из Postgres")
+
+	stats, err := rebuildRedisIndexes(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Индексы Redis восстановлены: пользователей %d, треков %d, списков %d", stats.Users, stats.Tracks, stats.Lists)
+	return nil
+}
+
+// rebuildRedisIndexes восстанавливает из users и trackMeta все производные структуры Redis:
+// хэши User:<user>, рейтинг Users, списки newTracks, жанров и UserTracks:<user>, рейтинги likes и plays.
+// Повторный запуск дает тот же результат: списки собираются во временном ключе и атомарно
+// подменяются через RENAME, в рейтингах счетчик только растет (ZADD GT), так что ушедшие вперед
+// значения Redis не откатываются к сохраненным в Postgres. Хэши track<ID> не трогаются - это кэш
+// music-service, он заполняется сам при первом чтении. Плейлисты пока хранятся только в Redis
+func rebuildRedisIndexes(ctx context.Context) (*redisRebuildStats, error) {
+	stats := &redisRebuildStats{}
+
+	owners, err := rebuildUsers(ctx, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rebuildTracks(ctx, stats, owners)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rdb.Set(ctx, redisIndexesMarker, time.Now().Format(time.RFC3339), 0).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// rebuildUsers восстанавливает хэши User:<user> и участие в рейтинге Users. Счетчики
+// прослушиваний пользователя есть только в Redis, поэтому существующие значения сохраняются,
+// а отсутствующие начинаются с 0, как при регистрации
+func rebuildUsers(ctx context.Context, stats *redisRebuildStats) ([]string, error) {
+	rows, err := DB.QueryContext(ctx, `SELECT user_name, first_name, artist_name, email, country, birth_date FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("getting users error: %w", err)
+	}
+	defer rows.Close()
+
+	var usernames []string
+	pipe := rdb.Pipeline()
+
+	for rows.Next() {
+		var username, firstName, artistName, email, country string
+		var birthDate time.Time
+		if err := rows.Scan(&username, &firstName, &artistName, &email, &country, &birthDate); err != nil {
+			return nil, fmt.Errorf("getting users error: %w", err)
+		}
+		usernames = append(usernames, username)
+
+		pipe.HSet(ctx, "User:"+username, map[string]interface{}{
+			"username":   username,
+			"firstName":  firstName,
+			"artistName": artistName,
+			"email":      email,
+			"country":    country,
+			"birthDate":  birthDate.Format("2006-01-02"),
+		})
+		pipe.HSetNX(ctx, "User:"+username, "playlists", "")
+		pipe.HSetNX(ctx, "User:"+username, "plays", 0)
+		pipe.HSetNX(ctx, "User:"+username, "likes", 0)
+		pipe.ZAddNX(ctx, "Users", redisOrig.Z{Score: 0, Member: username})
+
+		if pipe.Len() >= redisRebuildBatch {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return nil, fmt.Errorf("rebuilding users error: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting users error: %w", err)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("rebuilding users error: %w", err)
+	}
+
+	stats.Users = len(usernames)
+	return usernames, nil
+}
+
+// rebuildTracks восстанавливает списки и рейтинги опубликованных треков. Порядок списков тот же,
+// что дает LPush при публикации: сначала новые. Расхождения с Redis перед удалением сверяются
+// с trackMeta еще раз: треки, опубликованные во время пересборки, остаются на месте
+func rebuildTracks(ctx context.Context, stats *redisRebuildStats, usernames []string) error {
+	rows, err := DB.QueryContext(ctx, `SELECT id, owner, genre, COALESCE(likes, 0), COALESCE(plays, 0), visibility
+		FROM trackMeta WHERE published ORDER BY id DESC`)
+	if err != nil {
+		return fmt.Errorf("getting tracks error: %w", err)
+	}
+	defer rows.Close()
+
+	lists := map[string][]interface{}{"newTracks": nil}
+	for _, username := range usernames {
+		lists["UserTracks:"+username] = nil
+	}
+
+	// tracks - публичные треки: только они попадают в общие списки и рейтинги
+	tracks := map[string]bool{}
+	total := 0
+	pipe := rdb.Pipeline()
+
+	for rows.Next() {
+		var id, likes, plays int64
+		var owner, genre, visibility string
+		if err := rows.Scan(&id, &owner, &genre, &likes, &plays, &visibility); err != nil {
+			return fmt.Errorf("getting tracks error: %w", err)
+		}
+		total++
+
+		lists["UserTracks:"+owner] = append(lists["UserTracks:"+owner], strconv.FormatInt(id, 10))
+		if visibility != trackVisibilityPublic {
+			continue
+		}
+
+		trackKey := "track" + strconv.FormatInt(id, 10)
+		tracks[trackKey] = true
+
+		lists["newTracks"] = append(lists["newTracks"], trackKey)
+		lists[genre] = append(lists[genre], trackKey)
+
+		pipe.ZAddGT(ctx, "likes", redisOrig.Z{Score: float64(likes), Member: trackKey})
+		pipe.ZAddGT(ctx, "plays", redisOrig.Z{Score: float64(plays), Member: trackKey})
+
+		if pipe.Len() >= redisRebuildBatch {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return fmt.Errorf("rebuilding ratings error: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("getting tracks error: %w", err)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("rebuilding ratings error: %w", err)
+	}
+
+	// Удаленные и закрытые треки не должны оставаться в рейтингах
+	for _, rating := range []string{"likes", "plays"} {
+		members, err := rdb.ZRange(ctx, rating, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("rebuilding %s error: %w", rating, err)
+		}
+
+		var candidates []string
+		for _, member := range members {
+			if !tracks[member] {
+				candidates = append(candidates, member)
+			}
+		}
+
+		stale, err := staleTrackMembers(ctx, rating, candidates)
+		if err != nil {
+			return fmt.Errorf("rebuilding %s error: %w", rating, err)
+		}
+		if len(stale) > 0 {
+			if err := rdb.ZRem(ctx, rating, stale...).Err(); err != nil {
+				return fmt.Errorf("rebuilding %s error: %w", rating, err)
+			}
+		}
+	}
+
+	// Жанр, в котором не осталось публичных треков, в lists не попадает, и его старый список
+	// нужно удалить отдельно
+	staleGenres, err := staleGenreLists(ctx, lists)
+	if err != nil {
+		return fmt.Errorf("rebuilding genre lists error: %w", err)
+	}
+	for _, genre := range staleGenres {
+		lists[genre] = nil
+	}
+
+	for key, values := range lists {
+		values, err := mergeListChanges(ctx, key, values)
+		if err != nil {
+			return fmt.Errorf("rebuilding list %s error: %w", key, err)
+		}
+		if err := replaceRedisList(ctx, key, values); err != nil {
+			return fmt.Errorf("rebuilding list %s error: %w", key, err)
+		}
+	}
+
+	stats.Tracks = total
+	stats.Lists = len(lists)
+	return nil
+}
+
+// rebuildTrackState опубликованный трек, перечитанный из trackMeta после снимка
+type rebuildTrackState struct {
+	owner  string
+	genre  string
+	public bool
+}
+
+// belongsTo должен ли трек быть в списке или рейтинге key
+func (track rebuildTrackState) belongsTo(key string) bool {
+	switch {
+	case strings.HasPrefix(key, "UserTracks:"):
+		return track.owner == strings.TrimPrefix(key, "UserTracks:")
+	case key == "newTracks" || key == "likes" || key == "plays":
+		return track.public
+	default:
+		return track.public && track.genre == key
+	}
+}
+
+// memberTrackID ID трека из элемента: track<ID> в общих списках и рейтингах, <ID> в UserTracks
+func memberTrackID(member string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(member, "track"), 10, 64)
+	return id, err == nil
+}
+
+// currentTrackStates перечитывает опубликованные треки по ID. Пересборка идет, пока music-service
+// публикует треки, поэтому элемент, которого нет в снимке, может оказаться только что опубликованным
+func currentTrackStates(ctx context.Context, members []string) (map[int64]rebuildTrackState, error) {
+	ids := make([]int64, 0, len(members))
+	for _, member := range members {
+		if id, ok := memberTrackID(member); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	states := map[int64]rebuildTrackState{}
+	if len(ids) == 0 {
+		return states, nil
+	}
+
+	rows, err := DB.QueryContext(ctx, `SELECT id, owner, genre, visibility FROM trackMeta WHERE published AND id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var owner, genre, visibility string
+		if err := rows.Scan(&id, &owner, &genre, &visibility); err != nil {
+			return nil, err
+		}
+		states[id] = rebuildTrackState{owner: owner, genre: genre, public: visibility == trackVisibilityPublic}
+	}
+
+	return states, rows.Err()
+}
+
+// staleTrackMembers элементы рейтинга key из candidates (их нет в снимке), которые и сейчас
+// не должны в нем быть
+func staleTrackMembers(ctx context.Context, key string, candidates []string) ([]interface{}, error) {
+	states, err := currentTrackStates(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []interface{}
+	for _, member := range candidates {
+		id, _ := memberTrackID(member)
+		if state, ok := states[id]; !ok || !state.belongsTo(key) {
+			stale = append(stale, member)
+		}
+	}
+
+	return stale, nil
+}
+
+// mergeListChanges добавляет к пересобранному списку элементы, которые появились в Redis после
+// снимка: publishTrack делает LPush, поэтому они идут в начало списка в том же порядке
+func mergeListChanges(ctx context.Context, key string, values []interface{}) ([]interface{}, error) {
+	current, err := rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	inSnapshot := make(map[string]bool, len(values))
+	for _, value := range values {
+		inSnapshot[value.(string)] = true
+	}
+
+	var candidates []string
+	for _, member := range current {
+		if !inSnapshot[member] {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		return values, nil
+	}
+
+	states, err := currentTrackStates(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []interface{}
+	for _, member := range candidates {
+		id, _ := memberTrackID(member)
+		if state, ok := states[id]; ok && state.belongsTo(key) {
+			added = append(added, member)
+		}
+	}
+
+	return append(added, values...), nil
+}
+
+// genreListMemberRegexp элемент списка жанра: track<ID>
+var genreListMemberRegexp = regexp.MustCompile(`^track[0-9]+$`)
+
+// staleGenreLists списки жанров в Redis, которых нет среди пересобранных lists. Ключи жанров -
+// просто названия без префикса, поэтому жанром считается список без ":" в имени, элементы
+// которого - track<ID>
+func staleGenreLists(ctx context.Context, lists map[string][]interface{}) ([]string, error) {
+	var stale []string
+
+	iter := rdb.ScanType(ctx, 0, "*", redisRebuildBatch, "list").Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if _, rebuilt := lists[key]; rebuilt || strings.Contains(key, ":") {
+			continue
+		}
+
+		first, err := rdb.LIndex(ctx, key, 0).Result()
+		if errors.Is(err, redisOrig.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if genreListMemberRegexp.MatchString(first) {
+			stale = append(stale, key)
+		}
+	}
+
+	return stale, iter.Err()
+}
+
+// replaceRedisList атомарно заменяет список key на values. Пустой список просто удаляется
+func replaceRedisList(ctx context.Context, key string, values []interface{}) error {
+	if len(values) == 0 {
+		return rdb.Del(ctx, key).Err()
+	}
+
+	tmpKey := "redisRebuild:" + key
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	for start := 0; start < len(values); start += redisRebuildBatch {
+		end := min(start+redisRebuildBatch, len(values))
+		pipe.RPush(ctx, tmpKey, values[start:end]...)
+	}
+	pipe.Rename(ctx, tmpKey, key)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadSessionsDir = "uploads"
+	uploadSessionTTL  = 24 * time.Hour
+)
+
+var uploadSessionIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// uploadSessionLocks не дает двум PATCH одной сессии писать в файл одновременно
+var uploadSessionLocks sync.Map
+
+// UploadSession состояние возобновляемой загрузки
+type UploadSession struct {
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"upload_offset"`
+	Length   int64  `json:"upload_length"`
+}
+
+// createUploadSessionHandler Создает сессию возобновляемой загрузки трека
+// @Summary Создает сессию возобновляемой загрузки трека
+// @Description Принимает метаданные трека, обложку и полный размер трека в заголовке Upload-Length. Сам трек затем догружается кусками через PATCH /uploadsession
+// @Tags track
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param Upload-Length header int true "Полный размер трека в байтах"
+// @Param picture_file formData file false "Track cover image, тип multipart/form-data. Без нее берется обложка из тегов файла"
+// @Param meta_data formData string true "Метадата трека согласно TrackMeta структуры in JSON format, тип multipart/form-data"
+// @Param track_filename formData string true "Имя файла трека: .mp3, .wav или .flac"
+// @Success 201 {object} UploadSession
+// @Failure 400 {string} string "Bad Request"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 413 {string} string "Request Entity Too Large"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /uploadsession [post]
+// @Security CookieAuth
+// @Security BearerAuth
+func createUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Println(err)
+		return
+	}
+
+	uploadLength, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || uploadLength <= 0 {
+		logger.Println("invalid Upload-Length")
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	if uploadLength > maxMusicSize {
+		logger.Println("Music file is too large")
+		http.Error(w, "Music file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPictureSize+maxMetaDataSize+1<<20)
+	err = r.ParseMultipartForm(maxPictureSize + maxMetaDataSize)
+	if err != nil {
+		logger.Println("The body of upload session request is too large:", err)
+		http.Error(w, "The body of upload session request is too large", http.StatusBadRequest)
+		return
+	}
+
+	trackFilename := r.FormValue("track_filename")
+	if !isTrackFileName(trackFilename) {
+		http.Error(w, "File type error", http.StatusBadRequest)
+		return
+	}
+
+	metaData := r.FormValue("meta_data")
+	trackMeta := &TrackMeta{}
+	if metaData == "" || json.Unmarshal([]byte(metaData), trackMeta) != nil {
+		http.Error(w, "Getting metaData error", http.StatusBadRequest)
+		return
+	}
+
+	// Обложка необязательна: без нее music-service возьмет встроенную в файл
+	var pictureFileData []byte
+	pictureFile, pictureHeader, err := r.FormFile("picture_file")
+	if err != nil && !errors.Is(err, http.ErrMissingFile) {
+		logger.Println("Error getting picture file after parsing:", err)
+		http.Error(w, "Error getting picture file after parsing", http.StatusBadRequest)
+		return
+	}
+
+	if err == nil {
+		defer pictureFile.Close()
+
+		if pictureHeader.Size > maxPictureSize {
+			logger.Println("Picture file is too large")
+			http.Error(w, "Picture file is too large", http.StatusBadRequest)
+			return
+		}
+
+		// Содержимое проверяет music-service при декодировании, здесь отсекаются явно чужие файлы
+		if !strings.HasSuffix(pictureHeader.Filename, ".jpeg") &&
+			!strings.HasSuffix(pictureHeader.Filename, ".jpg") &&
+			!strings.HasSuffix(pictureHeader.Filename, ".png") {
+			http.Error(w, "File type error", http.StatusBadRequest)
+			return
+		}
+
+		pictureFileData, err = io.ReadAll(pictureFile)
+		if err != nil {
+			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	uploadID, err := newUploadSessionID()
+	if err != nil {
+		logger.Println("error creating upload session:", err)
+		http.Error(w, "error creating upload session", http.StatusInternalServerError)
+		return
+	}
+
+	err = os.MkdirAll(uploadSessionsDir, 0755)
+	if err != nil {
+		logger.Println("error creating upload session:", err)
+		http.Error(w, "error creating upload session", http.StatusInternalServerError)
+		return
+	}
+
+	if pictureFileData != nil {
+		err = os.WriteFile(uploadSessionPicturePath(uploadID), pictureFileData, 0644)
+		if err != nil {
+			logger.Println("error creating upload session:", err)
+			http.Error(w, "error creating upload session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = os.WriteFile(uploadSessionDataPath(uploadID), nil, 0644)
+	if err != nil {
+		removeUploadSessionFiles(uploadID)
+		logger.Println("error creating upload session:", err)
+		http.Error(w, "error creating upload session", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, "uploadSession:"+uploadID, map[string]interface{}{
+		"owner":    claims.Username,
+		"length":   uploadLength,
+		"metaData": metaData,
+	})
+	pipe.Expire(ctx, "uploadSession:"+uploadID, uploadSessionTTL)
+
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		removeUploadSessionFiles(uploadID)
+		logger.Println("error creating upload session:", err)
+		http.Error(w, "error creating upload session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/uploadsession?uploadID="+uploadID)
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Length", strconv.FormatInt(uploadLength, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(UploadSession{UploadID: uploadID, Offset: 0, Length: uploadLength})
+	if err != nil {
+		logger.Println("error sending upload session:", err)
+	}
+}
+
+// uploadSessionHandler Смещение и дозагрузка трека в сессии
+// @Summary Смещение и дозагрузка трека в сессии возобновляемой загрузки
+// @Description HEAD возвращает текущее смещение в заголовке Upload-Offset. PATCH дописывает кусок трека с Content-Type application/offset+octet-stream, заголовок Upload-Offset должен совпадать с текущим смещением
+// @Tags track
+// @Accept application/offset+octet-stream
+// @Produce text/plain
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param uploadID query string true "ID сессии загрузки"
+// @Param Upload-Offset header int false "Смещение куска, обязательно для PATCH"
+// @Success 204 {string} string "Upload-Offset в заголовке ответа"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Upload session not found or expired"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "Upload-Offset mismatch"
+// @Failure 415 {string} string "Unsupported Media Type"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /uploadsession [patch]
+// @Security CookieAuth
+// @Security BearerAuth
+func uploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		createUploadSessionHandler(w, r)
+		return
+	case http.MethodHead, http.MethodPatch:
+	default:
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uploadID, uploadLength, ok := uploadSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lock, _ := uploadSessionLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Смещение - фактический размер уже записанных данных, он переживает падение посреди PATCH
+	fileInfo, err := os.Stat(uploadSessionDataPath(uploadID))
+	if err != nil {
+		logger.Println("upload session data not found:", err)
+		http.Error(w, "upload session not found or expired", http.StatusNotFound)
+		return
+	}
+	offset := fileInfo.Size()
+
+	w.Header().Set("Upload-Length", strconv.FormatInt(uploadLength, 10))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	requestOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	if requestOffset != offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(uploadSessionDataPath(uploadID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logger.Println("error opening upload session data:", err)
+		http.Error(w, "error saving chunk", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// Обрыв соединения не страшен: записанное остается на диске, клиент узнает смещение через HEAD
+	written, err := io.Copy(file, io.LimitReader(r.Body, uploadLength-offset+1))
+	if written > uploadLength-offset {
+		file.Truncate(offset)
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	errExpire := rdb.Expire(ctx, "uploadSession:"+uploadID, uploadSessionTTL).Err()
+	if errExpire != nil {
+		logger.Println("error prolonging upload session:", errExpire)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset+written, 10))
+
+	if err != nil {
+		logger.Println("upload chunk interrupted:", err)
+		http.Error(w, "upload chunk interrupted", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUploadSessionHandler Завершает возобновляемую загрузку
+// @Summary Завершает возобновляемую загрузку трека
+// @Description Когда смещение сессии дошло до Upload-Length, собранный трек передается в music-service тем же путем, что и /uploadmusicsend, после чего сессия удаляется
+// @Tags track
+// @Produce application/json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param uploadID query string true "ID сессии загрузки"
+// @Success 202 {object} UploadResult "Трек принят, статус обработки - через /uploadstatus"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Upload session not found or expired"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "Upload is not complete or track duplicates an already uploaded track"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /uploadsessionfinish [post]
+// @Security CookieAuth
+// @Security BearerAuth
+func finishUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uploadID, uploadLength, ok := uploadSessionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lock, _ := uploadSessionLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	session, err := rdb.HGetAll(ctx, "uploadSession:"+uploadID).Result()
+	if err != nil || len(session) == 0 {
+		http.Error(w, "upload session not found or expired", http.StatusNotFound)
+		return
+	}
+
+	trackFile, err := os.Open(uploadSessionDataPath(uploadID))
+	if err != nil {
+		logger.Println("upload session data not found:", err)
+		http.Error(w, "upload session not found or expired", http.StatusNotFound)
+		return
+	}
+	defer trackFile.Close()
+
+	fileInfo, err := trackFile.Stat()
+	if err != nil || fileInfo.Size() != uploadLength {
+		http.Error(w, "upload is not complete", http.StatusConflict)
+		return
+	}
+
+	pictureFileData, err := os.ReadFile(uploadSessionPicturePath(uploadID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Println("upload session picture not found:", err)
+		http.Error(w, "upload session not found or expired", http.StatusNotFound)
+		return
+	}
+
+	trackMeta := &TrackMeta{}
+	err = json.Unmarshal([]byte(session["metaData"]), trackMeta)
+	if err != nil {
+		http.Error(w, "Getting metaData error", http.StatusBadRequest)
+		return
+	}
+
+	uploadResp, err := uploadTrackStream(r.Context(), newUploadMusicRequest(trackMeta, pictureFileData, session["owner"], uploadID), trackFile)
+	if err != nil {
+		sendUploadError(w, err)
+		return
+	}
+
+	deleteUploadSession(uploadID)
+
+	sendUploadResult(w, uploadResp)
+}
+
+// uploadSessionFromRequest проверяет токены и владельца сессии, возвращает ID и полный размер трека
+func uploadSessionFromRequest(w http.ResponseWriter, r *http.Request) (string, int64, bool) {
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Println(err)
+		return "", 0, false
+	}
+
+	uploadID := r.URL.Query().Get("uploadID")
+	if !uploadSessionIDRegexp.MatchString(uploadID) {
+		logger.Println("invalid uploadID")
+		http.Error(w, "invalid uploadID", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	session, err := rdb.HMGet(ctx, "uploadSession:"+uploadID, "owner", "length").Result()
+	if err != nil {
+		logger.Println("error getting upload session:", err)
+		http.Error(w, "error getting upload session", http.StatusInternalServerError)
+		return "", 0, false
+	}
+
+	owner, _ := session[0].(string)
+	lengthString, _ := session[1].(string)
+	if owner == "" {
+		http.Error(w, "upload session not found or expired", http.StatusNotFound)
+		return "", 0, false
+	}
+
+	if owner != claims.Username {
+		logger.Println("access denied")
+		http.Error(w, "access denied", http.StatusForbidden)
+		return "", 0, false
+	}
+
+	uploadLength, err := strconv.ParseInt(lengthString, 10, 64)
+	if err != nil {
+		logger.Println("invalid upload session length:", err)
+		http.Error(w, "error getting upload session", http.StatusInternalServerError)
+		return "", 0, false
+	}
+
+	return uploadID, uploadLength, true
+}
+
+func newUploadSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func uploadSessionDataPath(uploadID string) string {
+	return filepath.Join(uploadSessionsDir, uploadID+".part")
+}
+
+func uploadSessionPicturePath(uploadID string) string {
+	return filepath.Join(uploadSessionsDir, uploadID+".jpeg")
+}
+
+func removeUploadSessionFiles(uploadID string) {
+	os.Remove(uploadSessionDataPath(uploadID))
+	os.Remove(uploadSessionPicturePath(uploadID))
+	uploadSessionLocks.Delete(uploadID)
+}
+
+func deleteUploadSession(uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := rdb.Del(ctx, "uploadSession:"+uploadID).Err()
+	if err != nil {
+		logger.Println("error deleting upload session:", err)
+	}
+
+	removeUploadSessionFiles(uploadID)
+}
+
+// uploadSessionsCleanup удаляет с диска недогруженные данные сессий, ключ которых в Redis истек
+func uploadSessionsCleanup() {
+	for range time.Tick(10 * time.Minute) {
+		entries, err := os.ReadDir(uploadSessionsDir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Println("error reading upload sessions dir:", err)
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			uploadID := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			if !uploadSessionIDRegexp.MatchString(uploadID) {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			_, err := rdb.HGet(ctx, "uploadSession:"+uploadID, "owner").Result()
+			cancel()
+
+			if errors.Is(err, redis.Nil) {
+				logger.Printf("removing expired upload session %s\n", uploadID)
+				removeUploadSessionFiles(uploadID)
+			}
+		}
+	}
+}
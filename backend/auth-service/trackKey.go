@@ -0,0 +1,62 @@
+package main
+
+import (
+	gen "aiartistprod/backend/auth-service/proto-gen-files"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// hlsKeyLineRegexp адрес ключа, который music-service пишет в #EXT-X-KEY зашифрованных плейлистов
+var hlsKeyLineRegexp = regexp.MustCompile(`^(#EXT-X-KEY:.*URI=")trackkey(".*)$`)
+
+// trackKeyHandler выдает ключ AES-128 сегментов трека
+// @Summary Ключ сегментов трека
+// @Description Отдает 16 байт ключа AES-128, на который ссылается #EXT-X-KEY плейлиста зашифрованного трека. Ключ получают только вошедшие пользователи, которым разрешено слушать трек: для private трека - только владелец
+// @Tags track
+// @Produce application/octet-stream
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param trackID query int true "ID трека"
+// @Success 200 {file} binary "Ключ AES-128"
+// @Failure 400 {string} string "Invalid trackID"
+// @Failure 401 {string} string "Authorization required"
+// @Failure 404 {string} string "Track not found or not encrypted"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Router /trackkey [get]
+// @Security CookieAuth
+// @Security BearerAuth
+func trackKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Недопустимый метод запроса", http.StatusMethodNotAllowed)
+		return
+	}
+
+	trackID, err := strconv.Atoi(r.URL.Query().Get("trackID"))
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, "Invalid trackID", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println("Track key denied:", err)
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := musicClient.GetTrackKey(r.Context(), &gen.GetTrackKeyRequest{
+		TrackId:  int32(trackID),
+		Username: claims.Username,
+	})
+	if err != nil {
+		logger.Printf("getting track key error: %v\n", err)
+		sendTrackError(w, err, "Failed to get track key")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(res.Key)
+}
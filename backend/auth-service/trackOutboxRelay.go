@@ -0,0 +1,138 @@
+package main
+
+import (
+	"aiartistprod/backend/auth-service/Kafka"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// trackEventsTopic топик событий жизненного цикла трека из trackOutbox
+const trackEventsTopic = "track_events"
+
+// trackOutboxBatch сколько событий relay забирает из outbox за один проход
+const trackOutboxBatch = 100
+
+// trackOutboxRetention сколько хранятся уже опубликованные события
+const trackOutboxRetention = 7 * 24 * time.Hour
+
+// trackOutboxRelay публикует события из trackOutbox в Kafka. Событие отмечается опубликованным
+// только после подтверждения от брокера, поэтому доставка не реже одного раза: при падении между
+// отправкой и коммитом событие уйдет повторно с тем же dedupe_key. FOR UPDATE SKIP LOCKED позволяет
+// запускать несколько экземпляров auth-service без двойной отправки в нормальном режиме
+func trackOutboxRelay() {
+	lastCleanup := time.Now()
+
+	for range time.Tick(time.Second) {
+		for {
+			sent, err := relayTrackEvents(context.Background())
+			if err != nil {
+				logger.Println("track outbox relay error:", err)
+				break
+			}
+			if sent < trackOutboxBatch {
+				break
+			}
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+
+			_, err := DB.Exec(`DELETE FROM trackOutbox WHERE published_at < $1`, time.Now().Add(-trackOutboxRetention))
+			if err != nil {
+				logger.Println("track outbox cleanup error:", err)
+			}
+		}
+	}
+}
+
+// relayTrackEvents отправляет одну пачку неопубликованных событий по порядку. На первой ошибке
+// отправки пачка обрывается, чтобы события одного трека не обгоняли друг друга
+func relayTrackEvents(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, event_type, track_id, payload FROM trackOutbox
+		WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, trackOutboxBatch)
+	if err != nil {
+		return 0, fmt.Errorf("getting track events error: %w", err)
+	}
+
+	type outboxEvent struct {
+		id        int64
+		eventType string
+		trackID   int64
+		payload   []byte
+	}
+
+	var events []outboxEvent
+	for rows.Next() {
+		var event outboxEvent
+		if err := rows.Scan(&event.id, &event.eventType, &event.trackID, &event.payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("getting track events error: %w", err)
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("getting track events error: %w", err)
+	}
+
+	var sendErr error
+	var sent []int64
+	for _, event := range events {
+		dedupeKey := "trackOutbox:" + strconv.FormatInt(event.id, 10)
+
+		sendErr = Kafka.SendEvent(producer, trackEventsTopic, strconv.FormatInt(event.trackID, 10), dedupeKey, event.eventType, event.payload)
+		if sendErr != nil {
+			break
+		}
+		sent = append(sent, event.id)
+
+		if event.eventType == "ready" {
+			announceTrackReady(event.payload)
+		}
+	}
+
+	for _, id := range sent {
+		_, err := tx.ExecContext(ctx, `UPDATE trackOutbox SET published_at = now() WHERE id = $1`, id)
+		if err != nil {
+			return 0, fmt.Errorf("marking track event %d error: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(sent), sendErr
+}
+
+// announceTrackReady сообщение о новом треке в users_events для ленты в websocket. Трек попадает
+// в ленту, когда он уже доступен для прослушивания, а не в момент ответа на загрузку
+func announceTrackReady(payload []byte) {
+	var event struct {
+		ArtistName string `json:"artist_name"`
+		Title      string `json:"title"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		logger.Println("error reading track event:", err)
+		return
+	}
+
+	msgText := fmt.Sprintf("Artist %s has uploaded track %s", event.ArtistName, event.Title)
+
+	_, err := Kafka.SendMsg(producer, "users_events", msgText, logger)
+	if err != nil {
+		logger.Println("Failed to send message to Kafka:", err)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"aiartistprod/backend/auth-service/proto-gen-files"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -69,7 +75,7 @@ func getUserTracksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tracksMetaToSendJson, err := getMetaTracksFromSlice(tracks)
+	tracksMetaToSendJson, err := getMetaTracksFromSlice(tracks, claims.Username)
 	if err != nil {
 		logger.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -79,7 +85,9 @@ func getUserTracksHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(tracksMetaToSendJson)
 }
 
-func getMetaTracksFromSlice(tracks []string) ([]byte, error) {
+// getMetaTracksFromSlice метаданные треков списка от имени listener. Треки, недоступные слушателю
+// (чужие private или unlisted для анонима), пропускаются, а не ломают весь список
+func getMetaTracksFromSlice(tracks []string, listener string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
@@ -92,7 +100,10 @@ func getMetaTracksFromSlice(tracks []string) ([]byte, error) {
 			return nil, err
 		}
 
-		trackMeta, err := getTrackMetaFunc(ctx, vInt)
+		trackMeta, err := getTrackMetaFunc(ctx, vInt, 0, false, listener)
+		if code := status.Code(err); code == codes.NotFound || code == codes.Unauthenticated {
+			continue
+		}
 		if err != nil {
 			logger.Println(err)
 			return nil, err
@@ -111,18 +122,18 @@ func getMetaTracksFromSlice(tracks []string) ([]byte, error) {
 }
 
 // @Summary Удаления трека пользователя
-// @Description Этот эндпоинт позволяет удалить трек при наличии токенов
+// @Description Этот эндпоинт позволяет удалить трек при наличии токенов. Удалить можно только свой трек: music-service удаляет метаданные, файлы трека и убирает его из всех плейлистов
 // @Tags track
 // @Produce text/plain
 // @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
 // @Param refresh_token header string true "Refresh token из cookies"
 // @Param trackID query string true "ID трека"
-// @Param trackOwner query string true "Владелец трека - Username"
-// @Param genre query string true "Жанр трека"
 // @Success 200 {string} string "The track has been deleted successfully"
 // @Failure 400 {string} string "Bad Request"
-// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "You are not the owner of this track"
+// @Failure 404 {string} string "Track not found"
 // @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "The track is still being processed"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /deleteusertrack [delete]
 func deleteUserTrackHandler(w http.ResponseWriter, r *http.Request) {
@@ -132,16 +143,21 @@ func deleteUserTrackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trackOwner := r.URL.Query().Get("trackOwner")
 	trackID := r.URL.Query().Get("trackID")
-	genre := r.URL.Query().Get("genre")
 
-	if trackID == "" || trackOwner == "" || genre == "" {
+	if trackID == "" {
 		logger.Println("trackID is required")
 		http.Error(w, "trackID is required", http.StatusBadRequest)
 		return
 	}
 
+	trackIDInt, err := strconv.Atoi(trackID)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, "trackID is invalid", http.StatusBadRequest)
+		return
+	}
+
 	claims, err := tokensExtractionAndUpdate(w, r)
 	if err != nil {
 		logger.Println(err)
@@ -149,47 +165,338 @@ func deleteUserTrackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if claims.Username != trackOwner {
-		logger.Println("you are not the owner of this track")
-		http.Error(w, "you are not the owner of this track", http.StatusUnauthorized)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = musicClient.DeleteTrack(ctx, &gen.DeleteTrackRequest{
+		TrackId:  int32(trackIDInt),
+		Username: claims.Username,
+	})
+	if err != nil {
+		logger.Println(err)
+		sendTrackError(w, err, "error deleting track")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("The track has been deleted successfully"))
+}
+
+// TrackMetaUpdate изменяемые поля трека. Отсутствующее в JSON поле остается прежним
+type TrackMetaUpdate struct {
+	Title       *string `json:"title"`
+	AlbumName   *string `json:"album_name"`
+	Genre       *string `json:"genre"`
+	Description *string `json:"description"`
+	ReleaseYear *int32  `json:"release_year"`
+	// public, unlisted или private
+	Visibility *string `json:"visibility"`
+}
+
+// @Summary Редактирование трека
+// @Description Владелец трека меняет название, альбом, жанр, описание, год выпуска, видимость и обложку. Прослушивания, лайки и плейлисты сохраняются. Передаются только изменяемые поля: meta_data с частью полей TrackMetaUpdate и/или новая обложка
+// @Tags track
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param trackID query int true "ID трека"
+// @Param meta_data formData string false "Изменяемые поля согласно TrackMetaUpdate в JSON"
+// @Param picture_file formData file false "Новая обложка JPEG или PNG"
+// @Success 200 {object} TrackMeta "Метаданные трека после изменения"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 403 {string} string "You are not the owner of this track"
+// @Failure 404 {string} string "Track not found"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "The track is still being processed"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /updatetrackmeta [patch]
+// @Security CookieAuth
+// @Security BearerAuth
+func updateTrackMetaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	trackID, err := strconv.Atoi(r.URL.Query().Get("trackID"))
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, "trackID is invalid", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPictureSize+maxMetaDataSize+1<<10)
+	if err := r.ParseMultipartForm(maxPictureSize + maxMetaDataSize); err != nil {
+		logger.Println("Error reading multipart body:", err)
+		http.Error(w, "Error reading multipart body", http.StatusBadRequest)
+		return
+	}
+
+	req := &gen.UpdateTrackMetaRequest{
+		TrackId:  int32(trackID),
+		Username: claims.Username,
+	}
+
+	if metaData := r.FormValue("meta_data"); metaData != "" {
+		var update TrackMetaUpdate
+		if err := json.Unmarshal([]byte(metaData), &update); err != nil {
+			http.Error(w, "Getting metaData error", http.StatusBadRequest)
+			return
+		}
+
+		req.Title = update.Title
+		req.AlbumName = update.AlbumName
+		req.Genre = update.Genre
+		req.Description = update.Description
+		req.ReleaseYear = update.ReleaseYear
+		req.Visibility = update.Visibility
+	}
+
+	pictureFile, pictureHeader, err := r.FormFile("picture_file")
+	if err == nil {
+		defer pictureFile.Close()
+
+		// Содержимое проверяет music-service при декодировании, здесь отсекаются явно чужие файлы
+		if !strings.HasSuffix(pictureHeader.Filename, ".jpeg") &&
+			!strings.HasSuffix(pictureHeader.Filename, ".jpg") &&
+			!strings.HasSuffix(pictureHeader.Filename, ".png") {
+			http.Error(w, "File type error", http.StatusBadRequest)
+			return
+		}
+
+		req.TrackPicture, err = io.ReadAll(io.LimitReader(pictureFile, maxPictureSize+1))
+		if err != nil {
+			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+			return
+		}
+
+		if len(req.TrackPicture) > maxPictureSize {
+			logger.Println("Picture file is too large")
+			http.Error(w, "Picture file is too large", http.StatusBadRequest)
+			return
+		}
+	} else if !errors.Is(err, http.ErrMissingFile) {
+		http.Error(w, "Ошибка чтения файла", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	trackIDInt, err := strconv.Atoi(trackID)
+	res, err := musicClient.UpdateTrackMeta(ctx, req)
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, "range1 is invalid", http.StatusBadRequest)
+		sendTrackError(w, err, "error updating track")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trackMetaFromResponse(res)); err != nil {
+		logger.Printf("Ошибка сериализации JSON: %v\n", err)
+	}
+}
+
+// @Summary Замена звука трека
+// @Description Владелец загружает новый звук опубликованного трека (ремастер, исправленное сведение). ID трека, прослушивания, лайки, плейлисты и метаданные сохраняются. Новый звук проходит те же проверки, что и загрузка, и обрабатывается в фоне: до готовности играет прежний, статус - через /uploadstatus. Предыдущая версия хранится для отката через /rollbacktrackaudio
+// @Tags track
+// @Accept multipart/form-data
+// @Produce application/json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param trackID query int true "ID трека"
+// @Param normalize_loudness query bool false "Собрать дополнительную версию, нормализованную к -14 LUFS"
+// @Param track_file formData file true "Новый аудиофайл трека (.mp3, .wav или .flac)"
+// @Success 202 {object} UploadResult "Новый звук принят, статус обработки - через /uploadstatus"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 403 {string} string "You are not the owner of this track"
+// @Failure 404 {string} string "Track not found"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "The track is still being processed or duplicates another track"
+// @Failure 415 {string} string "Unsupported Media Type - Must be multipart/form-data"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /replacetrackaudio [post]
+// @Security CookieAuth
+// @Security BearerAuth
+func replaceTrackAudioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	trackID, err := strconv.Atoi(r.URL.Query().Get("trackID"))
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, "trackID is invalid", http.StatusBadRequest)
+		return
+	}
+
+	normalizeLoudness := false
+	if value := r.URL.Query().Get("normalize_loudness"); value != "" {
+		normalizeLoudness, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "normalize_loudness is invalid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" || !strings.HasPrefix(contentType, "multipart/form-data") {
+		http.Error(w, "Отсутствует заголовок multipart/form-data", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		logger.Println("Error reading multipart body:", err)
+		http.Error(w, "Error reading multipart body", http.StatusBadRequest)
+		return
+	}
+
+	// Кроме файла ничего не нужно, поэтому трек сразу уходит в music-service
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Println("The body of replace audio request is too large:", err)
+			http.Error(w, "The body of replace audio request is too large", http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() != "track_file" {
+			part.Close()
+			continue
+		}
+
+		if !isTrackFileName(part.FileName()) {
+			http.Error(w, "File type error", http.StatusBadRequest)
+			return
+		}
+
+		uploadResp, err := replaceTrackAudioStream(r.Context(), &gen.UploadMusicRequest{
+			TrackID:           int32(trackID),
+			Owner:             claims.Username,
+			NormalizeLoudness: normalizeLoudness,
+		}, part)
+		if err != nil {
+			sendTrackError(w, err, "Failed to replace track audio")
+			return
+		}
+
+		sendUploadResult(w, uploadResp)
+		return
+	}
+
+	logger.Println("Error getting music file after parsing")
+	http.Error(w, "Error getting music file after parsing", http.StatusBadRequest)
+}
+
+// replaceTrackAudioStream передает новый звук трека в ReplaceTrackAudio так же, как uploadTrackStream
+func replaceTrackAudioStream(ctx context.Context, meta *gen.UploadMusicRequest, track io.Reader) (*gen.UploadMusicResponse, error) {
+	stream, err := musicClient.ReplaceTrackAudio(ctx)
+	if err != nil {
+		logger.Println("Error opening replace audio stream:", err)
+		return nil, err
+	}
+
+	err = stream.Send(&gen.UploadMusicChunk{Payload: &gen.UploadMusicChunk_Meta{Meta: meta}})
+	if err != nil {
+		logger.Println("Error sending track metadata:", err)
+		return nil, err
+	}
+
+	err = sendTrackChunks(stream, track)
+	if errors.Is(err, errTrackStreamBroken) {
+		_, err = stream.CloseAndRecv()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		logger.Println("Error replacing track audio:", err)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// @Summary Откат звука трека
+// @Description Возвращает трек на предыдущую версию звука. Текущая версия становится предыдущей, так что повторный откат возвращает замену
+// @Tags track
+// @Produce application/json
+// @Param Authorization header string true "Access token (format: 'Bearer {token}') из header"
+// @Param refresh_token header string true "Refresh token из cookies"
+// @Param trackID query int true "ID трека"
+// @Success 200 {object} TrackMeta "Метаданные трека после отката"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 403 {string} string "You are not the owner of this track"
+// @Failure 404 {string} string "Track not found"
+// @Failure 405 {string} string "Method Not Allowed"
+// @Failure 409 {string} string "No previous audio version or the track is being processed"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /rollbacktrackaudio [post]
+// @Security CookieAuth
+// @Security BearerAuth
+func rollbackTrackAudioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger.Println("method not allowed")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	query := `DELETE FROM trackMeta WHERE id = $1`
-	_, err = DB.Exec(query, trackIDInt)
+	trackID, err := strconv.Atoi(r.URL.Query().Get("trackID"))
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, "error deleting track"+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "trackID is invalid", http.StatusBadRequest)
 		return
 	}
 
-	pipe := rdb.TxPipeline()
+	claims, err := tokensExtractionAndUpdate(w, r)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	pipe.LRem(ctx, "UserTracks:"+claims.Username, 0, trackID)
-	pipe.Del(ctx, "track"+trackID)
-	pipe.LRem(ctx, "newTracks", 0, "track"+trackID)
-	pipe.LRem(ctx, genre, 0, "track"+trackID)
-	pipe.ZRem(ctx, "likes", "track"+trackID)
-	pipe.ZRem(ctx, "plays", "track"+trackID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	_, err = pipe.Exec(ctx)
+	res, err := musicClient.RollbackTrackAudio(ctx, &gen.RollbackTrackAudioRequest{
+		TrackId:  int32(trackID),
+		Username: claims.Username,
+	})
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, "error deleting track"+err.Error(), http.StatusInternalServerError)
+		sendTrackError(w, err, "error rolling back track audio")
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("The track has been deleted successfully"))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trackMetaFromResponse(res)); err != nil {
+		logger.Printf("Ошибка сериализации JSON: %v\n", err)
+	}
 }
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// audioVersionDirRegexp поддиректории замененного звука внутри папки трека
+var audioVersionDirRegexp = regexp.MustCompile(`^v[0-9]+$`)
+
+// ReplaceTrackAudio принимает новый звук опубликованного трека тем же потоком, что и UploadMusicStream.
+// Звук проходит проверки загрузки и транскодируется в новую версию v<N>, воспроизведение
+// переключается на нее только после успешной обработки. ID трека, счетчики, плейлисты
+// и метаданные не меняются, предыдущая версия остается для отката
+func (s *MusicServiceServer) ReplaceTrackAudio(stream gen.MusicService_ReplaceTrackAudioServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		logging.Printf("ошибка получения метаданных трека: %v", err)
+		return fmt.Errorf("ошибка получения метаданных трека: %v", err)
+	}
+
+	req := first.GetMeta()
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "первое сообщение потока должно содержать метаданные")
+	}
+
+	ctx := stream.Context()
+	trackID := req.GetTrackID()
+	trackIDstring := strconv.FormatInt(int64(trackID), 10)
+
+	owner, _, err := s.trackOwnerAndGenre(ctx, trackID)
+	if err != nil {
+		return err
+	}
+
+	if owner != req.GetOwner() {
+		return status.Errorf(codes.PermissionDenied, "трек %s принадлежит другому пользователю", trackIDstring)
+	}
+
+	// Две замены одного трека одновременно получили бы один и тот же номер версии
+	locked, err := rdb.SetNX(ctx, "audioReplace:"+trackIDstring, owner, 10*time.Minute).Result()
+	if err != nil {
+		logging.Println("audio replace lock error: " + err.Error())
+		return status.Errorf(codes.Internal, "ошибка замены звука трека %s", trackIDstring)
+	}
+	if !locked {
+		return status.Errorf(codes.FailedPrecondition, "звук трека %s уже заменяется", trackIDstring)
+	}
+	defer rdb.Del(context.Background(), "audioReplace:"+trackIDstring)
+
+	if err := checkTrackNotProcessing(ctx, trackIDstring); err != nil {
+		return err
+	}
+
+	var currentVersion int
+	var previousVersion sql.NullInt64
+	err = s.db.QueryRowContext(ctx, `SELECT audio_version, previous_audio_version FROM trackMeta WHERE id = $1`, trackID).
+		Scan(&currentVersion, &previousVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "трек %s не найден", trackIDstring)
+	}
+	if err != nil {
+		logging.Printf("ошибка получения версии звука трека %s: %v", trackIDstring, err)
+		return status.Errorf(codes.Internal, "ошибка замены звука трека %s", trackIDstring)
+	}
+
+	audioPath, err := spoolToTempFile(&uploadChunkReader{stream: stream}, maxStreamUploadSize)
+	if err != nil {
+		logging.Printf("ошибка приема нового звука трека %s: %v", trackIDstring, err)
+		return fmt.Errorf("ошибка приема трека: %v", err)
+	}
+	defer os.Remove(audioPath)
+
+	duration, _, _, err := GetTrackInfo(audioPath)
+	if err != nil {
+		logging.Printf("ошибка получения длительности и битрейта: %v", err)
+		return status.Error(codes.InvalidArgument, "файл не распознан как аудио")
+	}
+
+	fingerprint, err := ComputeFingerprint(audioPath)
+	if err != nil {
+		logging.Printf("ошибка построения отпечатка нового звука трека %s: %v", trackIDstring, err)
+		return fmt.Errorf("ошибка сохранения трека\n")
+	}
+
+	// Совпадение с прежним звуком того же трека - ожидаемый случай ремастера, с чужим - нет
+	duplicateID, found, err := s.findDuplicateTrack(fingerprint, int64(trackID))
+	if err != nil {
+		logging.Printf("ошибка поиска дубликатов для трека %s: %v", trackIDstring, err)
+		return fmt.Errorf("ошибка сохранения трека\n")
+	}
+	if found {
+		logging.Printf("новый звук трека %s от %s совпадает с треком %d", trackIDstring, owner, duplicateID)
+		if duplicateUploadPolicy == duplicatePolicyReject {
+			return status.Errorf(codes.AlreadyExists, "трек совпадает с уже загруженным треком %d", duplicateID)
+		}
+	}
+
+	job := &transcodeJob{
+		TrackID:  int64(trackID),
+		Duration: duration,
+		Owner:    owner,
+
+		NormalizeLoudness: req.GetNormalizeLoudness(),
+		AudioVersion:      max(currentVersion, int(previousVersion.Int64)) + 1,
+		DuplicateOf:       duplicateID,
+		Fingerprint:       encodeFingerprint(fingerprint),
+	}
+
+	enqueueCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err = s.enqueueTranscodeJob(enqueueCtx, job, audioPath)
+	if err != nil {
+		logging.Printf("ошибка постановки замены звука трека %s в очередь: %v", trackIDstring, err)
+		return err
+	}
+
+	resp := &gen.UploadMusicResponse{
+		Result:  fmt.Sprintf("Новая версия звука %d принята в обработку", job.AudioVersion),
+		TrackID: trackID,
+		Status:  uploadStatusQueued,
+	}
+	if found {
+		resp.Result = fmt.Sprintf("Новая версия звука %d принята в обработку и отправлена на проверку: совпадает с треком %d", job.AudioVersion, duplicateID)
+		resp.DuplicateOf = int32(duplicateID)
+	}
+
+	return stream.SendAndClose(resp)
+}
+
+// trackAudioVersion сохраненные свойства одной версии звука трека
+type trackAudioVersion struct {
+	version             int
+	duration            int
+	integratedLoudness  sql.NullFloat64
+	truePeak            sql.NullFloat64
+	loudnessRange       sql.NullFloat64
+	normalizedRendition bool
+	fingerprint         []byte
+}
+
+// switchTrackAudio переключает трек на звук, собранный задачей job. В Postgres одной транзакцией
+// меняются версия, длительность, громкость и отпечаток трека, затем поле audioVersion в хэше
+// track<ID> - по нему auth-service выбирает папку HLS, так что слушатели переходят на новый
+// звук разом. Хранятся две версии: новая и та, что играла до нее, остальные удаляются
+func (s *MusicServiceServer) switchTrackAudio(job *transcodeJob) error {
+	trackIDstring := job.trackIDString()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Отпечаток посчитан в ReplaceTrackAudio. Без него могут прийти только задачи,
+	// поставленные в очередь до появления поля и восстановленные после перезапуска
+	fingerprint := job.Fingerprint
+	if len(fingerprint) == 0 {
+		computed, err := ComputeFingerprint(job.AudioPath)
+		if err != nil {
+			return fmt.Errorf("fingerprint error: %w", err)
+		}
+		fingerprint = encodeFingerprint(computed)
+	}
+
+	newVersion := trackAudioVersion{
+		version:             job.AudioVersion,
+		duration:            int(job.Duration),
+		normalizedRendition: job.normalizedRendition,
+		fingerprint:         fingerprint,
+	}
+	if job.loudness != nil {
+		newVersion.integratedLoudness = sql.NullFloat64{Float64: job.loudness.IntegratedLoudness, Valid: true}
+		newVersion.truePeak = sql.NullFloat64{Float64: job.loudness.TruePeak, Valid: true}
+		newVersion.loudnessRange = sql.NullFloat64{Float64: job.loudness.LoudnessRange, Valid: true}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentVersion int
+	err = tx.QueryRow(`SELECT audio_version FROM trackMeta WHERE id = $1 FOR UPDATE`, job.TrackID).Scan(&currentVersion)
+	if err != nil {
+		return fmt.Errorf("getting audio version error: %w", err)
+	}
+
+	// У исходной загрузки строки версии нет, она появляется при первой замене
+	_, err = tx.Exec(`INSERT INTO trackAudioVersions (track_id, version, duration, integrated_loudness, true_peak, loudness_range, normalized_rendition, fingerprint)
+		SELECT m.id, m.audio_version, m.duration, m.integrated_loudness, m.true_peak, m.loudness_range, m.normalized_rendition, f.fingerprint
+		FROM trackMeta m LEFT JOIN trackFingerprints f ON f.track_id = m.id WHERE m.id = $1
+		ON CONFLICT (track_id, version) DO NOTHING`, job.TrackID)
+	if err != nil {
+		return fmt.Errorf("saving current audio version error: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO trackAudioVersions (track_id, version, duration, integrated_loudness, true_peak, loudness_range, normalized_rendition, fingerprint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		job.TrackID, newVersion.version, newVersion.duration, newVersion.integratedLoudness, newVersion.truePeak,
+		newVersion.loudnessRange, newVersion.normalizedRendition, newVersion.fingerprint)
+	if err != nil {
+		return fmt.Errorf("saving new audio version error: %w", err)
+	}
+
+	var duplicateOf sql.NullInt64
+	if job.DuplicateOf != 0 {
+		duplicateOf = sql.NullInt64{Int64: job.DuplicateOf, Valid: true}
+	}
+
+	_, err = tx.Exec(`UPDATE trackMeta SET duplicate_of = $2 WHERE id = $1`, job.TrackID, duplicateOf)
+	if err != nil {
+		return fmt.Errorf("updating duplicate mark error: %w", err)
+	}
+
+	err = applyAudioVersion(tx, job.TrackID, newVersion, currentVersion)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`DELETE FROM trackAudioVersions WHERE track_id = $1 AND version NOT IN ($2, $3) RETURNING version`,
+		job.TrackID, newVersion.version, currentVersion)
+	if err != nil {
+		return fmt.Errorf("evicting audio versions error: %w", err)
+	}
+
+	var evicted []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("evicting audio versions error: %w", err)
+		}
+		evicted = append(evicted, version)
+	}
+	rows.Close()
+
+	err = writeTrackEventFor(tx, job.TrackID, trackEventUpdated, trackEventReasonAudioReplaced)
+	if err != nil {
+		return fmt.Errorf("writing track event error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// После коммита новая версия уже основная: ошибки дальше не должны удалять ее файлы
+	err = s.resetTrackCache(ctx, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка: кэш трека %s указывает на прежнюю версию звука до истечения TTL: %v", trackIDstring, err)
+	}
+
+	for _, version := range evicted {
+		if err := removeTrackAudioVersion(ctx, job.Owner, trackIDstring, version); err != nil {
+			logging.Printf("ошибка удаления версии %d трека %s: %v", version, trackIDstring, err)
+		}
+	}
+
+	logging.Printf("трек %s переключен на версию звука %d, предыдущая - %d", trackIDstring, newVersion.version, currentVersion)
+	return nil
+}
+
+// RollbackTrackAudio возвращает трек на предыдущую версию звука. Текущая становится предыдущей,
+// поэтому повторный вызов возвращает замену обратно
+func (s *MusicServiceServer) RollbackTrackAudio(ctx context.Context, req *gen.RollbackTrackAudioRequest) (*gen.GetMetaResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	owner, _, err := s.trackOwnerAndGenre(ctx, req.GetTrackId())
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != req.GetUsername() {
+		return nil, status.Errorf(codes.PermissionDenied, "трек %s принадлежит другому пользователю", trackIDstring)
+	}
+
+	if err := checkTrackNotProcessing(ctx, trackIDstring); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.Printf("ошибка отката звука трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка отката звука трека %s", trackIDstring)
+	}
+	defer tx.Rollback()
+
+	var currentVersion int
+	var previousVersion sql.NullInt64
+	err = tx.QueryRow(`SELECT audio_version, previous_audio_version FROM trackMeta WHERE id = $1 FOR UPDATE`, req.GetTrackId()).
+		Scan(&currentVersion, &previousVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "трек %s не найден", trackIDstring)
+	}
+	if err != nil {
+		logging.Printf("ошибка получения версии звука трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка отката звука трека %s", trackIDstring)
+	}
+	if !previousVersion.Valid {
+		return nil, status.Errorf(codes.FailedPrecondition, "у трека %s нет предыдущей версии звука", trackIDstring)
+	}
+
+	target := trackAudioVersion{version: int(previousVersion.Int64)}
+	err = tx.QueryRow(`SELECT duration, integrated_loudness, true_peak, loudness_range, normalized_rendition, fingerprint
+		FROM trackAudioVersions WHERE track_id = $1 AND version = $2`, req.GetTrackId(), target.version).
+		Scan(&target.duration, &target.integratedLoudness, &target.truePeak, &target.loudnessRange, &target.normalizedRendition, &target.fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.FailedPrecondition, "версия звука %d трека %s не сохранилась", target.version, trackIDstring)
+	}
+	if err != nil {
+		logging.Printf("ошибка получения версии звука %d трека %s: %v", target.version, trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка отката звука трека %s", trackIDstring)
+	}
+
+	err = applyAudioVersion(tx, int64(req.GetTrackId()), target, currentVersion)
+	if err == nil {
+		err = writeTrackEventFor(tx, int64(req.GetTrackId()), trackEventUpdated, trackEventReasonAudioRolledBack)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.Printf("ошибка отката звука трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка отката звука трека %s", trackIDstring)
+	}
+
+	err = s.resetTrackCache(ctx, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка переключения версии звука трека %s в Redis: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка отката звука трека %s", trackIDstring)
+	}
+
+	logging.Printf("трек %s откачен на версию звука %d владельцем %s", trackIDstring, target.version, owner)
+
+	return s.GetMeta(ctx, &gen.GetMetaRequest{TrackId: req.GetTrackId(), Listener: owner})
+}
+
+// applyAudioVersion делает version текущей версией трека в trackMeta, а previousVersion - предыдущей
+func applyAudioVersion(tx *sql.Tx, trackID int64, version trackAudioVersion, previousVersion int) error {
+	_, err := tx.Exec(`UPDATE trackMeta SET audio_version = $2, previous_audio_version = $3, duration = $4,
+		integrated_loudness = $5, true_peak = $6, loudness_range = $7, normalized_rendition = $8 WHERE id = $1`,
+		trackID, version.version, previousVersion, version.duration,
+		version.integratedLoudness, version.truePeak, version.loudnessRange, version.normalizedRendition)
+	if err != nil {
+		return fmt.Errorf("switching audio version error: %w", err)
+	}
+
+	// Поиск дубликатов должен сравнивать с тем звуком, который сейчас играет
+	var fingerprint []uint32
+	if len(version.fingerprint) > 0 {
+		fingerprint = decodeFingerprint(version.fingerprint)
+	}
+
+	return writeFingerprint(tx, trackID, fingerprint)
+}
+
+// removeTrackAudioVersion удаляет HLS, исходник и волну одной версии звука. Версия 0 лежит в корне папки
+// трека вместе с поддиректориями v<N> остальных версий, поэтому они при удалении пропускаются
+func removeTrackAudioVersion(ctx context.Context, owner, trackIDstring string, version int) error {
+	dir := trackAudioDir(owner, trackIDstring, version)
+
+	if version > 0 {
+		if err := blobStorage.DeleteDir(ctx, dir); err != nil {
+			return err
+		}
+	} else {
+		keys, err := blobStorage.List(ctx, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			first, _, _ := strings.Cut(strings.TrimPrefix(key, dir+"/"), "/")
+			if audioVersionDirRegexp.MatchString(first) {
+				continue
+			}
+			if err := blobStorage.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := blobStorage.Delete(ctx, originalAudioPath(owner, trackIDstring, version)); err != nil {
+		return err
+	}
+
+	return blobStorage.Delete(ctx, waveformPath(owner, trackIDstring, version))
+}
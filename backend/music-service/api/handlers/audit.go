@@ -0,0 +1,612 @@
+package main
+
+import (
+	"aiartistprod/backend/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	redisOrig "github.com/redis/go-redis/v9"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Категории расхождений между Postgres, Redis и хранилищем файлов
+const (
+	auditRedisOrphanTrack    = "redis-orphan-track"
+	auditUserRedisOrphan     = "user-redis-orphan"
+	auditUserMissingInRedis  = "user-missing-in-redis"
+	auditRowWithoutHLS       = "row-without-hls"
+	auditStaleUnpublishedRow = "stale-unpublished-row"
+	auditOrphanMedia         = "orphan-media"
+)
+
+const (
+	// Неопубликованная строка моложе этого могла просто еще не дойти до очереди конвертации
+	auditStaleUnpublishedTime = time.Hour
+	auditQuarantinePrefix     = "quarantine"
+)
+
+// auditCategories описания категорий в порядке вывода отчета
+var auditCategories = []struct {
+	name        string
+	description string
+}{
+	{auditRedisOrphanTrack, "трек в структурах Redis без строки trackMeta. repair: убрать из Redis"},
+	{auditUserRedisOrphan, "хэш User:<user> без строки users (signupSend пишет Redis раньше Postgres). repair: удалить из Redis"},
+	{auditUserMissingInRedis, "строка users без хэша User:<user>. repair: создать хэш"},
+	{auditRowWithoutHLS, "опубликованный трек без HLS текущей версии. repair: пересобрать из исходника, quarantine: снять с публикации"},
+	{auditStaleUnpublishedRow, "неопубликованная строка trackMeta без задачи конвертации. repair: удалить трек"},
+	{auditOrphanMedia, "файлы в хранилище без строки trackMeta. repair: удалить, quarantine: перенести в quarantine/"},
+}
+
+// auditFinding одно расхождение и способы его исправить. nil - действие для категории не предусмотрено
+type auditFinding struct {
+	category   string
+	subject    string
+	repair     func(ctx context.Context) error
+	quarantine func(ctx context.Context) error
+}
+
+// auditTrackRow строка trackMeta, нужная для сверки
+type auditTrackRow struct {
+	owner        string
+	genre        string
+	published    bool
+	audioVersion int
+	stale        bool
+}
+
+// errAuditSkipped расхождение исчезло к моменту исправления, трогать ничего не нужно
+var errAuditSkipped = errors.New("skipped")
+
+// auditRedisRef место в Redis, где упоминается трек
+type auditRedisRef struct {
+	key    string
+	member string
+	kind   string
+}
+
+var (
+	mediaTrackIDRegexp  = regexp.MustCompile(`^[0-9]+`)
+	redisTrackKeyRegexp = regexp.MustCompile(`^track[0-9]+$`)
+)
+
+// auditCommand подкоманда обслуживания: music-service audit [флаги]. Сверяет Postgres, Redis
+// и хранилище файлов, печатает расхождения по категориям и с -fix исправляет их
+func (s *MusicServiceServer) auditCommand(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fix := flags.String("fix", "none", "что делать с найденным: none (только отчет), repair или quarantine")
+	categories := flags.String("category", "", "проверять только перечисленные через запятую категории")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *fix != "none" && *fix != "repair" && *fix != "quarantine" {
+		return fmt.Errorf("неизвестное значение -fix %q", *fix)
+	}
+
+	selected := map[string]bool{}
+	for _, category := range strings.Split(*categories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			selected[category] = true
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	findings, err := s.auditFindings(ctx)
+	if err != nil {
+		return err
+	}
+
+	byCategory := map[string][]auditFinding{}
+	for _, finding := range findings {
+		if len(selected) == 0 || selected[finding.category] {
+			byCategory[finding.category] = append(byCategory[finding.category], finding)
+		}
+	}
+
+	fixed, failed, skipped := 0, 0, 0
+	for _, category := range auditCategories {
+		if len(selected) > 0 && !selected[category.name] {
+			continue
+		}
+
+		categoryFindings := byCategory[category.name]
+		fmt.Printf("%s: %d (%s)\n", category.name, len(categoryFindings), category.description)
+
+		for _, finding := range categoryFindings {
+			action := finding.repair
+			if *fix == "quarantine" {
+				action = finding.quarantine
+			}
+
+			if *fix == "none" || ctx.Err() != nil {
+				fmt.Printf("  %s\n", finding.subject)
+				continue
+			}
+			if action == nil {
+				skipped++
+				fmt.Printf("  %s: %s не предусмотрен\n", finding.subject, *fix)
+				continue
+			}
+
+			err := action(ctx)
+			if errors.Is(err, errAuditSkipped) {
+				skipped++
+				fmt.Printf("  %s: пропущен (%v)\n", finding.subject, err)
+				continue
+			}
+			if err != nil {
+				failed++
+				logging.Printf("audit: %s %s: %v", finding.category, finding.subject, err)
+				fmt.Printf("  %s: ошибка %v\n", finding.subject, err)
+				continue
+			}
+			fixed++
+			fmt.Printf("  %s: %s выполнен\n", finding.subject, *fix)
+		}
+	}
+
+	if *fix != "none" {
+		fmt.Printf("audit: исправлено %d, ошибок %d, без действия %d\n", fixed, failed, skipped)
+	}
+
+	if ctx.Err() != nil {
+		return errors.New("прервано")
+	}
+	if failed > 0 {
+		return fmt.Errorf("не удалось исправить %d расхождений, подробности в логе", failed)
+	}
+	return nil
+}
+
+// auditFindings собирает все расхождения. Состояние читается целиком до начала исправлений
+func (s *MusicServiceServer) auditFindings(ctx context.Context) ([]auditFinding, error) {
+	tracks, err := s.auditTrackRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []auditFinding
+
+	redisFindings, err := s.auditRedisTracks(ctx, tracks)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, redisFindings...)
+
+	userFindings, err := s.auditUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, userFindings...)
+
+	mediaFindings, err := s.auditMedia(ctx, tracks)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, mediaFindings...)
+
+	return findings, nil
+}
+
+func (s *MusicServiceServer) auditTrackRows(ctx context.Context) (map[string]*auditTrackRow, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, owner, genre, published, audio_version,
+		add_to_db_date < $1 FROM trackMeta`, time.Now().Add(-auditStaleUnpublishedTime))
+	if err != nil {
+		return nil, fmt.Errorf("getting tracks error: %w", err)
+	}
+	defer rows.Close()
+
+	tracks := map[string]*auditTrackRow{}
+	for rows.Next() {
+		var id int64
+		row := &auditTrackRow{}
+		if err := rows.Scan(&id, &row.owner, &row.genre, &row.published, &row.audioVersion, &row.stale); err != nil {
+			return nil, fmt.Errorf("getting tracks error: %w", err)
+		}
+		tracks[strconv.FormatInt(id, 10)] = row
+	}
+
+	return tracks, rows.Err()
+}
+
+// auditRedisTracks ищет упоминания треков без строки trackMeta: хэши track<ID>, списки newTracks,
+// жанров, UserTracks:* и playlistTracks:*, рейтинги likes и plays
+func (s *MusicServiceServer) auditRedisTracks(ctx context.Context, tracks map[string]*auditTrackRow) ([]auditFinding, error) {
+	refs := map[string][]auditRedisRef{}
+	addRef := func(trackID string, ref auditRedisRef) {
+		if _, ok := tracks[trackID]; !ok {
+			refs[trackID] = append(refs[trackID], ref)
+		}
+	}
+
+	iter := rdb.Scan(ctx, 0, "track*", 500).Iterator()
+	for iter.Next(ctx) {
+		if redisTrackKeyRegexp.MatchString(iter.Val()) {
+			addRef(strings.TrimPrefix(iter.Val(), "track"), auditRedisRef{key: iter.Val(), kind: "hash"})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	// Ключи жанров ничем не помечены, поэтому проверяются жанры, известные по trackMeta
+	trackLists := []string{"newTracks"}
+	genres := map[string]bool{}
+	for _, track := range tracks {
+		if track.genre != "" && !genres[track.genre] {
+			genres[track.genre] = true
+			trackLists = append(trackLists, track.genre)
+		}
+	}
+
+	for _, key := range trackLists {
+		members, err := rdb.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			addRef(strings.TrimPrefix(member, "track"), auditRedisRef{key: key, member: member, kind: "list"})
+		}
+	}
+
+	for _, pattern := range []string{"UserTracks:*", "playlistTracks:*"} {
+		iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			members, err := rdb.LRange(ctx, iter.Val(), 0, -1).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				addRef(member, auditRedisRef{key: iter.Val(), member: member, kind: "list"})
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rating := range []string{"likes", "plays"} {
+		members, err := rdb.ZRange(ctx, rating, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			addRef(strings.TrimPrefix(member, "track"), auditRedisRef{key: rating, member: member, kind: "zset"})
+		}
+	}
+
+	var findings []auditFinding
+	for trackID, trackRefs := range refs {
+		places := make([]string, 0, len(trackRefs))
+		for _, ref := range trackRefs {
+			places = append(places, ref.key)
+		}
+
+		findings = append(findings, auditFinding{
+			category: auditRedisOrphanTrack,
+			subject:  fmt.Sprintf("трек %s в %s", trackID, strings.Join(places, ", ")),
+			repair: func(ctx context.Context) error {
+				exists, err := s.trackRowExists(ctx, trackID)
+				if err != nil {
+					return err
+				}
+				if exists {
+					return fmt.Errorf("%w: трек %s появился после начала проверки, Redis оставлен", errAuditSkipped, trackID)
+				}
+				return removeRedisRefs(ctx, trackRefs)
+			},
+		})
+	}
+
+	sortFindings(findings)
+	return findings, nil
+}
+
+// removeRedisRefs убирает упоминания трека. Счетчик tracks плейлиста уменьшается, как в DeleteTrack
+func removeRedisRefs(ctx context.Context, refs []auditRedisRef) error {
+	for _, ref := range refs {
+		var err error
+		switch ref.kind {
+		case "hash":
+			err = rdb.Del(ctx, ref.key).Err()
+		case "zset":
+			err = rdb.ZRem(ctx, ref.key, ref.member).Err()
+		case "list":
+			var removed int64
+			removed, err = rdb.LRem(ctx, ref.key, 0, ref.member).Result()
+			if err == nil && removed > 0 && strings.HasPrefix(ref.key, "playlistTracks:") {
+				metaKey := "playlistMeta:" + strings.TrimPrefix(ref.key, "playlistTracks:")
+				if rdb.Exists(ctx, metaKey).Val() == 1 {
+					err = rdb.HIncrBy(ctx, metaKey, "tracks", -removed).Err()
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref.key, err)
+		}
+	}
+	return nil
+}
+
+// auditUsers сверяет таблицу users с хэшами User:<user>
+func (s *MusicServiceServer) auditUsers(ctx context.Context) ([]auditFinding, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_name, first_name, artist_name, email, country, birth_date FROM users`)
+	if err != nil {
+		return nil, fmt.Errorf("getting users error: %w", err)
+	}
+	defer rows.Close()
+
+	users := map[string]map[string]interface{}{}
+	for rows.Next() {
+		var username, firstName, artistName, email, country string
+		var birthDate time.Time
+		if err := rows.Scan(&username, &firstName, &artistName, &email, &country, &birthDate); err != nil {
+			return nil, fmt.Errorf("getting users error: %w", err)
+		}
+		users[username] = map[string]interface{}{
+			"username":   username,
+			"firstName":  firstName,
+			"artistName": artistName,
+			"email":      email,
+			"country":    country,
+			"birthDate":  birthDate.Format("2006-01-02"),
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting users error: %w", err)
+	}
+
+	var findings []auditFinding
+	cached := map[string]bool{}
+
+	iter := rdb.Scan(ctx, 0, "User:*", 500).Iterator()
+	for iter.Next(ctx) {
+		username := strings.TrimPrefix(iter.Val(), "User:")
+		cached[username] = true
+
+		if _, ok := users[username]; ok {
+			continue
+		}
+
+		findings = append(findings, auditFinding{
+			category: auditUserRedisOrphan,
+			subject:  "пользователь " + username,
+			repair: func(ctx context.Context) error {
+				pipe := rdb.TxPipeline()
+				pipe.Del(ctx, "User:"+username)
+				pipe.ZRem(ctx, "Users", username)
+				_, err := pipe.Exec(ctx)
+				return err
+			},
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	for username, fields := range users {
+		if cached[username] {
+			continue
+		}
+
+		findings = append(findings, auditFinding{
+			category: auditUserMissingInRedis,
+			subject:  "пользователь " + username,
+			repair: func(ctx context.Context) error {
+				fields["playlists"] = ""
+				fields["plays"] = 0
+				fields["likes"] = 0
+
+				pipe := rdb.TxPipeline()
+				pipe.HSet(ctx, "User:"+username, fields)
+				pipe.ZAddNX(ctx, "Users", redisOrig.Z{Score: 0, Member: username})
+				_, err := pipe.Exec(ctx)
+				return err
+			},
+		})
+	}
+
+	sortFindings(findings)
+	return findings, nil
+}
+
+// auditMedia сверяет trackMeta с файлами в songs, pictures, waveforms и originals
+func (s *MusicServiceServer) auditMedia(ctx context.Context, tracks map[string]*auditTrackRow) ([]auditFinding, error) {
+	songKeys := map[string]bool{}
+	orphans := map[string][]string{}
+
+	for _, dir := range []string{"songs", "pictures", "waveforms", "originals"} {
+		keys, err := blobStorage.List(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s error: %w", dir, err)
+		}
+
+		for _, key := range keys {
+			if dir == "songs" {
+				songKeys[key] = true
+			}
+
+			owner, trackID, ok := mediaTrackID(key)
+			if !ok {
+				continue
+			}
+			if track, exists := tracks[trackID]; !exists || track.owner != owner {
+				group := path.Join(dir, owner, owner+"-"+trackID)
+				orphans[group] = append(orphans[group], key)
+			}
+		}
+	}
+
+	var findings []auditFinding
+
+	for group, keys := range orphans {
+		owner, trackID, _ := mediaTrackID(keys[0])
+
+		findings = append(findings, auditFinding{
+			category: auditOrphanMedia,
+			subject:  fmt.Sprintf("%s* (файлов: %d)", group, len(keys)),
+			repair: func(ctx context.Context) error {
+				if err := s.checkMediaStillOrphaned(ctx, owner, trackID); err != nil {
+					return err
+				}
+				for _, key := range keys {
+					if err := blobStorage.Delete(ctx, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			quarantine: func(ctx context.Context) error {
+				if err := s.checkMediaStillOrphaned(ctx, owner, trackID); err != nil {
+					return err
+				}
+				for _, key := range keys {
+					if err := moveToQuarantine(ctx, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+
+	for trackID, track := range tracks {
+		trackDir := trackAudioDir(track.owner, trackID, track.audioVersion)
+
+		switch {
+		case track.published && !songKeys[path.Join(trackDir, hlsMasterPlaylist)] && !songKeys[path.Join(trackDir, "playlist.m3u8")]:
+			unit := retranscodeUnit{trackID: trackID, owner: track.owner, version: track.audioVersion}
+			genre := track.genre
+
+			findings = append(findings, auditFinding{
+				category: auditRowWithoutHLS,
+				subject:  fmt.Sprintf("трек %s (%s), версия %d", trackID, track.owner, track.audioVersion),
+				repair: func(ctx context.Context) error {
+					_, err := s.retranscodeTrack(ctx, unit)
+					if errors.Is(err, errRetranscodeSkipped) {
+						return fmt.Errorf("пересобрать нельзя: %w", err)
+					}
+					return err
+				},
+				quarantine: func(ctx context.Context) error {
+					id, _ := strconv.ParseInt(unit.trackID, 10, 64)
+					err := s.execTrackChange(ctx, id, trackEventUpdated, trackEventReasonUnpublished,
+						`UPDATE trackMeta SET published = FALSE WHERE id = $1`, id)
+					if err != nil {
+						return err
+					}
+					_, err = removeTrackFromRedis(ctx, unit.trackID, unit.owner, genre)
+					return err
+				},
+			})
+
+		case !track.published && track.stale:
+			if checkTrackNotProcessing(ctx, trackID) != nil {
+				continue
+			}
+
+			owner, genre := track.owner, track.genre
+			findings = append(findings, auditFinding{
+				category: auditStaleUnpublishedRow,
+				subject:  fmt.Sprintf("трек %s (%s)", trackID, owner),
+				repair: func(ctx context.Context) error {
+					id, _ := strconv.ParseInt(trackID, 10, 64)
+					err := s.deleteTrackRow(ctx, id, trackEventReasonAudit)
+					if err != nil {
+						return err
+					}
+					if _, err := removeTrackFromRedis(ctx, trackID, owner, genre); err != nil {
+						return err
+					}
+					return removeTrackMedia(ctx, owner, trackID)
+				},
+			})
+		}
+	}
+
+	sortFindings(findings)
+	return findings, nil
+}
+
+// checkMediaStillOrphaned перечитывает строку трека перед удалением файлов: снимок trackMeta
+// снимается до листинга хранилища, и трек, загруженный между ними, иначе лишился бы своих файлов
+func (s *MusicServiceServer) checkMediaStillOrphaned(ctx context.Context, owner, trackID string) error {
+	var currentOwner string
+	err := s.db.QueryRowContext(ctx, `SELECT owner FROM trackMeta WHERE id = $1`, trackID).Scan(&currentOwner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if currentOwner == owner {
+		return fmt.Errorf("%w: трек %s появился после начала проверки, файлы оставлены", errAuditSkipped, trackID)
+	}
+	return nil
+}
+
+// trackRowExists есть ли строка трека в trackMeta прямо сейчас. Как и checkMediaStillOrphaned,
+// защищает треки, опубликованные между снимком trackMeta и исправлением
+func (s *MusicServiceServer) trackRowExists(ctx context.Context, trackID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM trackMeta WHERE id = $1)`, trackID).Scan(&exists)
+	return exists, err
+}
+
+// mediaTrackID владелец и ID трека из ключа вида <dir>/<user>/<user>-<id>[...]
+func mediaTrackID(key string) (string, string, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return "", "", false
+	}
+
+	owner := parts[1]
+	name, _, _ := strings.Cut(parts[2], "/")
+	if !strings.HasPrefix(name, owner+"-") {
+		return "", "", false
+	}
+
+	trackID := mediaTrackIDRegexp.FindString(strings.TrimPrefix(name, owner+"-"))
+	return owner, trackID, trackID != ""
+}
+
+// moveToQuarantine переносит файл в quarantine/<ключ>, откуда его можно вернуть вручную
+func moveToQuarantine(ctx context.Context, key string) error {
+	body, info, err := blobStorage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	quarantineKey := path.Join(auditQuarantinePrefix, key)
+	err = blobStorage.Put(ctx, quarantineKey, body, info.Size, storage.ContentType(key))
+	if err != nil {
+		return err
+	}
+
+	return blobStorage.Delete(ctx, key)
+}
+
+func sortFindings(findings []auditFinding) {
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].category != findings[j].category {
+			return findings[i].category < findings[j].category
+		}
+		return findings[i].subject < findings[j].subject
+	})
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"aiartistprod/backend/storage"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// coverSizes стороны квадратных версий обложки, которые сохраняются для каждого трека
+var coverSizes = []int{64, 300, 1000}
+
+const (
+	// coverDefaultSize версия обложки, которая уходит в GetMeta, если клиент не попросил другую
+	coverDefaultSize = 300
+	coverMinSide     = 64
+	// Ограничение на разрешение до декодирования, чтобы картинка не съела всю память
+	coverMaxPixels = 40_000_000
+	coverQuality   = 85
+)
+
+var errInvalidCover = errors.New("обложка должна быть изображением JPEG или PNG не меньше 64x64")
+
+// processCover декодирует обложку, поворачивает ее по EXIF Orientation, обрезает по центру
+// до квадрата и перекодирует в JPEG каждого размера из coverSizes. EXIF и прочие метаданные
+// при перекодировании не сохраняются. Увеличения нет: маленькая обложка остается своего размера
+func processCover(data []byte) (map[int][]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png") {
+		return nil, errInvalidCover
+	}
+
+	if config.Width < coverMinSide || config.Height < coverMinSide || config.Width*config.Height > coverMaxPixels {
+		return nil, errInvalidCover
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidCover
+	}
+
+	// Квадрат по центру, прозрачность PNG ложится на белый фон
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(square, square.Bounds(), img, offset, draw.Over)
+
+	if format == "jpeg" {
+		square = orientSquare(square, jpegExifOrientation(data))
+	}
+
+	renditions := make(map[int][]byte, len(coverSizes))
+	for _, size := range coverSizes {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, resizeSquare(square, min(size, side)), &jpeg.Options{Quality: coverQuality})
+		if err != nil {
+			return nil, fmt.Errorf("cover encoding error: %w", err)
+		}
+		renditions[size] = buf.Bytes()
+	}
+
+	return renditions, nil
+}
+
+// resizeSquare уменьшает квадрат усреднением пикселей, попадающих в каждый пиксель результата
+func resizeSquare(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	if size == side {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, max((y+1)*side/size, y*side/size+1)
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, max((x+1)*side/size, x*side/size+1)
+
+			var r, g, b, count int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					count++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / count)
+			dst.Pix[i+1] = uint8(g / count)
+			dst.Pix[i+2] = uint8(b / count)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// orientSquare поворачивает и отражает квадрат по значению EXIF Orientation (1-8)
+func orientSquare(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	n := src.Bounds().Dx() - 1
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = n-x, y
+			case 3:
+				sx, sy = n-x, n-y
+			case 4:
+				sx, sy = x, n-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, n-x
+			case 7:
+				sx, sy = n-y, n-x
+			case 8:
+				sx, sy = n-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+
+	return dst
+}
+
+// jpegExifOrientation достает тег Orientation из APP1 Exif. 1 - без поворота, в том числе когда тега нет
+func jpegExifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 1
+		}
+
+		marker := data[pos+1]
+		// Начало сжатых данных: Exif дальше не встретится
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && len(segment) >= 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+
+		pos += 2 + length
+	}
+
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
+
+func coverPath(username, trackID string, size int) string {
+	return path.Join("pictures", username, username+"-"+trackID+"-"+strconv.Itoa(size)+".jpeg")
+}
+
+// legacyCoverPath исходная обложка треков, загруженных до появления версий
+func legacyCoverPath(username, trackID string) string {
+	return path.Join("pictures", username, username+"-"+trackID+".jpeg")
+}
+
+// saveCover сохраняет версии обложки из processCover рядом: pictures/<user>/<user>-<id>-<size>.jpeg
+func saveCover(username, trackID string, renditions map[int][]byte) error {
+	for _, size := range coverSizes {
+		err := saveFile("pictures", "-"+strconv.Itoa(size)+".jpeg", username, trackID, renditions[size])
+		if err != nil {
+			removeCover(username, trackID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceCover перезаписывает версии обложки уже опубликованного трека. У старых треков заодно
+// удаляется исходный файл без размера, чтобы resolveCover не вернул его вместо новой версии
+func replaceCover(ctx context.Context, username, trackID string, renditions map[int][]byte) error {
+	for _, size := range coverSizes {
+		key := coverPath(username, trackID, size)
+		err := blobStorage.Put(ctx, key, bytes.NewReader(renditions[size]), int64(len(renditions[size])), storage.ContentType(key))
+		if err != nil {
+			return fmt.Errorf("cover saving error: %w", err)
+		}
+	}
+
+	return blobStorage.Delete(ctx, legacyCoverPath(username, trackID))
+}
+
+// resolveCover находит наименьшую сохраненную версию обложки не меньше size (0 - coverDefaultSize).
+// У треков, загруженных до появления версий, есть только исходный <user>-<id>.jpeg
+func resolveCover(ctx context.Context, username, trackID string, size int) (string, *storage.ObjectInfo, error) {
+	if size <= 0 {
+		size = coverDefaultSize
+	}
+
+	chosen := coverSizes[len(coverSizes)-1]
+	for _, available := range coverSizes {
+		if available >= size {
+			chosen = available
+			break
+		}
+	}
+
+	key := coverPath(username, trackID, chosen)
+	info, err := blobStorage.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		key = legacyCoverPath(username, trackID)
+		info, err = blobStorage.Stat(ctx, key)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, info, nil
+}
+
+func readCover(ctx context.Context, username, trackID string, size int) ([]byte, error) {
+	key, _, err := resolveCover(ctx, username, trackID, size)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := storage.ReadAll(ctx, blobStorage, key)
+	return data, err
+}
+
+// coverURL ссылка на обложку в auth-service (/trackpicture). Параметр v меняется вместе с файлом,
+// поэтому по такой ссылке картинку можно кэшировать как immutable
+func coverURL(ctx context.Context, username, trackID string, size int) (string, error) {
+	if size <= 0 {
+		size = coverDefaultSize
+	}
+
+	_, info, err := resolveCover(ctx, username, trackID, size)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("username", username)
+	query.Set("trackID", trackID)
+	query.Set("size", strconv.Itoa(size))
+	query.Set("v", strconv.FormatInt(info.ModTime.UnixNano(), 36))
+
+	return "/trackpicture?" + query.Encode(), nil
+}
+
+// removeCover удаляет все версии обложки трека, включая исходный файл старых треков
+func removeCover(username, trackID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, size := range coverSizes {
+		blobStorage.Delete(ctx, coverPath(username, trackID, size))
+	}
+	blobStorage.Delete(ctx, legacyCoverPath(username, trackID))
+}
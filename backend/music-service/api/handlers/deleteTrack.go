@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeleteTrack удаляет трек владельца целиком: строку trackMeta (отпечатки уходят каскадом),
+// все упоминания в Redis, включая плейлисты всех пользователей, и медиафайлы из хранилища.
+// Каждый шаг можно повторить: если прошлый вызов оборвался после Postgres, владелец и жанр
+// берутся из хэша трека в Redis
+func (s *MusicServiceServer) DeleteTrack(ctx context.Context, req *gen.DeleteTrackRequest) (*gen.DeleteTrackResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	owner, genre, err := s.trackOwnerAndGenre(ctx, req.GetTrackId())
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != req.GetUsername() {
+		return nil, status.Errorf(codes.PermissionDenied, "трек %s принадлежит другому пользователю", trackIDstring)
+	}
+
+	// Трек в очереди транскодирования удалит сам воркер при ошибке, а посреди обработки
+	// удаление столкнется с записью сегментов
+	if err := checkTrackNotProcessing(ctx, trackIDstring); err != nil {
+		return nil, err
+	}
+
+	err = s.deleteTrackRow(ctx, int64(req.GetTrackId()), trackEventReasonOwner)
+	if err != nil {
+		logging.Printf("ошибка удаления из постгре метаданных трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка удаления трека %s", trackIDstring)
+	}
+
+	// duplicate_of не внешний ключ: пометки на удаленный трек снимаются вручную
+	_, err = s.db.ExecContext(ctx, `UPDATE trackMeta SET duplicate_of = NULL WHERE duplicate_of = $1`, req.GetTrackId())
+	if err != nil {
+		logging.Printf("ошибка снятия пометок дубликата трека %s: %v", trackIDstring, err)
+	}
+
+	playlistsUpdated, err := removeTrackFromRedis(ctx, trackIDstring, owner, genre)
+	if err != nil {
+		logging.Printf("ошибка удаления трека %s из Redis: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка удаления трека %s", trackIDstring)
+	}
+
+	err = removeTrackMedia(ctx, owner, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка удаления файлов трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка удаления файлов трека %s", trackIDstring)
+	}
+
+	logging.Printf("трек %s пользователя %s удален, плейлистов изменено: %d", trackIDstring, owner, playlistsUpdated)
+
+	return &gen.DeleteTrackResponse{
+		TrackID:          req.GetTrackId(),
+		PlaylistsUpdated: int32(playlistsUpdated),
+	}, nil
+}
+
+// trackOwnerAndGenre владелец и жанр трека из Postgres, а если строки уже нет - из Redis
+func (s *MusicServiceServer) trackOwnerAndGenre(ctx context.Context, trackID int32) (string, string, error) {
+	trackIDstring := strconv.FormatInt(int64(trackID), 10)
+
+	var owner, genre string
+	err := s.db.QueryRowContext(ctx, `SELECT owner, genre FROM trackMeta WHERE id = $1`, trackID).Scan(&owner, &genre)
+	if err == nil {
+		return owner, genre, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logging.Printf("ошибка получения владельца трека %s: %v", trackIDstring, err)
+		return "", "", status.Errorf(codes.Internal, "ошибка получения трека %s", trackIDstring)
+	}
+
+	trackMeta, err := rdb.HMGet(ctx, "track"+trackIDstring, "owner", "genre").Result()
+	if err != nil {
+		logging.Printf("ошибка получения владельца трека %s: %v", trackIDstring, err)
+		return "", "", status.Errorf(codes.Internal, "ошибка получения трека %s", trackIDstring)
+	}
+
+	owner, _ = trackMeta[0].(string)
+	genre, _ = trackMeta[1].(string)
+	if owner == "" {
+		return "", "", status.Errorf(codes.NotFound, "трек %s не найден", trackIDstring)
+	}
+
+	return owner, genre, nil
+}
+
+// removeTrackFromRedis убирает трек из всех структур, куда его кладет publishTrack, и из плейлистов.
+// Плейлисты перебираются SCAN по playlistTracks:*, счетчик tracks в playlistMeta уменьшается
+// на число удаленных вхождений. Возвращает количество измененных плейлистов
+func removeTrackFromRedis(ctx context.Context, trackIDstring, owner, genre string) (int, error) {
+	pipe := rdb.TxPipeline()
+
+	pipe.Del(ctx, "track"+trackIDstring)
+	pipe.Del(ctx, "uploadStatus:"+trackIDstring)
+	pipe.LRem(ctx, "UserTracks:"+owner, 0, trackIDstring)
+	pipe.LRem(ctx, "newTracks", 0, "track"+trackIDstring)
+	if genre != "" {
+		pipe.LRem(ctx, genre, 0, "track"+trackIDstring)
+	}
+	pipe.ZRem(ctx, "likes", "track"+trackIDstring)
+	pipe.ZRem(ctx, "plays", "track"+trackIDstring)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	playlistsUpdated := 0
+	iter := rdb.Scan(ctx, 0, "playlistTracks:*", 500).Iterator()
+	for iter.Next(ctx) {
+		playlistKey := iter.Val()
+
+		removed, err := rdb.LRem(ctx, playlistKey, 0, trackIDstring).Result()
+		if err != nil {
+			return playlistsUpdated, fmt.Errorf("error deleting track from %s: %v", playlistKey, err)
+		}
+		if removed == 0 {
+			continue
+		}
+		playlistsUpdated++
+
+		metaKey := "playlistMeta:" + strings.TrimPrefix(playlistKey, "playlistTracks:")
+		exists, err := rdb.Exists(ctx, metaKey).Result()
+		if err != nil {
+			return playlistsUpdated, fmt.Errorf("error updating %s: %v", metaKey, err)
+		}
+		if exists == 1 {
+			err = rdb.HIncrBy(ctx, metaKey, "tracks", -removed).Err()
+			if err != nil {
+				return playlistsUpdated, fmt.Errorf("error updating %s: %v", metaKey, err)
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return playlistsUpdated, err
+	}
+
+	return playlistsUpdated, nil
+}
+
+// removeTrackMedia удаляет HLS всех версий звука, исходники, обложки и волны трека из хранилища
+func removeTrackMedia(ctx context.Context, owner, trackIDstring string) error {
+	removeCover(owner, trackIDstring)
+
+	err := blobStorage.DeleteDir(ctx, trackAudioDir(owner, trackIDstring, 0))
+	if err != nil {
+		return err
+	}
+
+	// Кроме файлов версии 0 могут остаться файлы замененного звука: волны <user>-<id>-v<N>.json
+	// и исходники <user>-<id>-v<N>
+	for _, dir := range []string{"waveforms", "originals"} {
+		keys, err := blobStorage.List(ctx, path.Join(dir, owner))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			name := strings.TrimSuffix(path.Base(key), ".json")
+			if name == owner+"-"+trackIDstring || strings.HasPrefix(name, owner+"-"+trackIDstring+"-v") {
+				if err := blobStorage.Delete(ctx, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
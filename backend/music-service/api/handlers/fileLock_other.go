@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile без flock блокировку проверить нечем: своя папка считается заблокированной,
+// чужие - занятыми, поэтому removeStaleWorkDirs их не удаляет
+func tryLockFile(file *os.File) error {
+	if file == transcodeWorkLock || transcodeWorkLock == nil {
+		return nil
+	}
+	return errors.New("file locking is not supported")
+}
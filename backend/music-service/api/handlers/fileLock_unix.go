@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile берет эксклюзивную блокировку без ожидания. Блокировка снимается вместе с
+// закрытием файла, в том числе когда процесс падает
+func tryLockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"math/bits"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	// Акустический отпечаток строится по схеме Haitsma-Kalker: моно 5512 Гц, окна по 2048 сэмплов,
+	// на каждое окно 32-битный суботпечаток из разностей энергий 33 полос 300-2000 Гц
+	fingerprintSampleRate = 5512
+	fingerprintFrameSize  = 2048
+	fingerprintHopSize    = 256
+	fingerprintBands      = 33
+	fingerprintMinFreq    = 300.0
+	fingerprintMaxFreq    = 2000.0
+	// Для сравнения достаточно начала трека, так отпечаток не зависит от длины файла
+	fingerprintMaxSeconds = 120
+
+	// Доля несовпавших бит, ниже которой треки считаются одной записью
+	duplicateMaxBitErrorRate = 0.35
+	// Сколько суботпечатков должно совпасть точно, чтобы трек стал кандидатом на сравнение
+	duplicateMinVotes      = 10
+	duplicateMaxCandidates = 5
+	// Допустимый сдвиг начала трека (обрезанная тишина и т.п.), в суботпечатках (~46 мс каждый)
+	duplicateMaxShift   = 40
+	duplicateMinOverlap = 200
+)
+
+const (
+	duplicatePolicyReject = "reject"
+	duplicatePolicyFlag   = "flag"
+)
+
+// duplicateUploadPolicy что делать с загрузкой, совпавшей с уже существующим треком:
+// reject - отказать, flag - принять и пометить в trackMeta.duplicate_of для проверки
+var duplicateUploadPolicy = duplicatePolicyReject
+
+// ComputeFingerprint декодирует начало трека и строит его акустический отпечаток
+func ComputeFingerprint(audioPath string) ([]uint32, error) {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", audioPath,
+		"-t", strconv.Itoa(fingerprintMaxSeconds),
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(fingerprintSampleRate),
+		"-f", "s16le",
+		"pipe:1",
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\n%s", err, stderr.String())
+	}
+
+	pcm := stdout.Bytes()
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+
+	bandEdges := make([]int, fingerprintBands+1)
+	for i := range bandEdges {
+		freq := fingerprintMinFreq * math.Pow(fingerprintMaxFreq/fingerprintMinFreq, float64(i)/fingerprintBands)
+		bandEdges[i] = int(freq * fingerprintFrameSize / fingerprintSampleRate)
+	}
+
+	var fingerprint []uint32
+	var prevEnergies []float64
+	frame := make([]complex128, fingerprintFrameSize)
+
+	for start := 0; start+fingerprintFrameSize <= len(samples); start += fingerprintHopSize {
+		for i := range frame {
+			frame[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(frame)
+
+		energies := make([]float64, fingerprintBands)
+		for band := 0; band < fingerprintBands; band++ {
+			for bin := bandEdges[band]; bin < bandEdges[band+1]; bin++ {
+				re, im := real(frame[bin]), imag(frame[bin])
+				energies[band] += re*re + im*im
+			}
+		}
+
+		if prevEnergies != nil {
+			var subFingerprint uint32
+			for band := 0; band < fingerprintBands-1; band++ {
+				diff := (energies[band] - energies[band+1]) - (prevEnergies[band] - prevEnergies[band+1])
+				if diff > 0 {
+					subFingerprint |= 1 << band
+				}
+			}
+			fingerprint = append(fingerprint, subFingerprint)
+		}
+		prevEnergies = energies
+	}
+
+	return fingerprint, nil
+}
+
+// fft итеративное БПФ по основанию 2 на месте, len(a) должна быть степенью двойки
+func fft(a []complex128) {
+	n := len(a)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	for length := 2; length <= n; length <<= 1 {
+		angle := -2 * math.Pi / float64(length)
+		step := complex(math.Cos(angle), math.Sin(angle))
+		for i := 0; i < n; i += length {
+			w := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				u := a[i+k]
+				v := a[i+k+length/2] * w
+				a[i+k] = u + v
+				a[i+k+length/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+// fingerprintHashes уникальные ненулевые суботпечатки для индекса trackFingerprintHashes.
+// Нулевой суботпечаток дает тишина, по нему искать бессмысленно
+func fingerprintHashes(fingerprint []uint32) []int64 {
+	seen := make(map[uint32]struct{}, len(fingerprint))
+	hashes := make([]int64, 0, len(fingerprint))
+
+	for _, subFingerprint := range fingerprint {
+		if subFingerprint == 0 {
+			continue
+		}
+		if _, ok := seen[subFingerprint]; ok {
+			continue
+		}
+		seen[subFingerprint] = struct{}{}
+		hashes = append(hashes, int64(int32(subFingerprint)))
+	}
+
+	return hashes
+}
+
+func encodeFingerprint(fingerprint []uint32) []byte {
+	data := make([]byte, len(fingerprint)*4)
+	for i, subFingerprint := range fingerprint {
+		binary.LittleEndian.PutUint32(data[i*4:], subFingerprint)
+	}
+	return data
+}
+
+func decodeFingerprint(data []byte) []uint32 {
+	fingerprint := make([]uint32, len(data)/4)
+	for i := range fingerprint {
+		fingerprint[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return fingerprint
+}
+
+// fingerprintBitErrorRate наименьшая доля различающихся бит среди сдвигов в пределах duplicateMaxShift
+func fingerprintBitErrorRate(a, b []uint32) float64 {
+	minOverlap := min(duplicateMinOverlap, min(len(a), len(b))*3/4)
+	best := 1.0
+
+	for shift := -duplicateMaxShift; shift <= duplicateMaxShift; shift++ {
+		errorBits, overlap := 0, 0
+		for i := max(0, -shift); i < len(a) && i+shift < len(b); i++ {
+			errorBits += bits.OnesCount32(a[i] ^ b[i+shift])
+			overlap++
+		}
+
+		if overlap == 0 || overlap < minOverlap {
+			continue
+		}
+
+		best = min(best, float64(errorBits)/float64(overlap*32))
+	}
+
+	return best
+}
+
+// findDuplicateTrack ищет уже загруженный трек с тем же звуком. Кандидаты отбираются по точным
+// совпадениям суботпечатков, затем сравниваются полные отпечатки с учетом сдвига
+// excludeTrackID не считается дубликатом: при замене звука трек может совпасть сам с собой
+func (s *MusicServiceServer) findDuplicateTrack(fingerprint []uint32, excludeTrackID int64) (int64, bool, error) {
+	hashes := fingerprintHashes(fingerprint)
+	if len(hashes) == 0 {
+		return 0, false, nil
+	}
+
+	rows, err := s.db.Query(`SELECT track_id FROM trackFingerprintHashes WHERE hash = ANY($1) AND track_id <> $4
+		GROUP BY track_id HAVING COUNT(*) >= $2 ORDER BY COUNT(*) DESC LIMIT $3`,
+		pq.Array(hashes), duplicateMinVotes, duplicateMaxCandidates, excludeTrackID)
+	if err != nil {
+		return 0, false, fmt.Errorf("fingerprint lookup error: %w", err)
+	}
+
+	var candidates []int64
+	for rows.Next() {
+		var trackID int64
+		if err := rows.Scan(&trackID); err != nil {
+			rows.Close()
+			return 0, false, fmt.Errorf("fingerprint lookup error: %w", err)
+		}
+		candidates = append(candidates, trackID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, false, fmt.Errorf("fingerprint lookup error: %w", err)
+	}
+
+	for _, trackID := range candidates {
+		var data []byte
+		err := s.db.QueryRow(`SELECT fingerprint FROM trackFingerprints WHERE track_id = $1`, trackID).Scan(&data)
+		if err != nil {
+			logging.Printf("ошибка чтения отпечатка трека %d: %v", trackID, err)
+			continue
+		}
+
+		if fingerprintBitErrorRate(fingerprint, decodeFingerprint(data)) < duplicateMaxBitErrorRate {
+			return trackID, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// saveFingerprint сохраняет отпечаток трека и индексирует его суботпечатки.
+// Обе таблицы ссылаются на trackMeta с ON DELETE CASCADE и чистятся вместе с треком
+func (s *MusicServiceServer) saveFingerprint(trackID int64, fingerprint []uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("fingerprint saving error: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := writeFingerprint(tx, trackID, fingerprint); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeFingerprint заменяет отпечаток трека внутри транзакции. Пустой отпечаток только удаляет старый
+func writeFingerprint(tx *sql.Tx, trackID int64, fingerprint []uint32) error {
+	_, err := tx.Exec(`DELETE FROM trackFingerprints WHERE track_id = $1`, trackID)
+	if err != nil {
+		return fmt.Errorf("fingerprint saving error: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM trackFingerprintHashes WHERE track_id = $1`, trackID)
+	if err != nil {
+		return fmt.Errorf("fingerprint indexing error: %w", err)
+	}
+
+	if len(fingerprint) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO trackFingerprints (track_id, fingerprint) VALUES ($1, $2)`, trackID, encodeFingerprint(fingerprint))
+	if err != nil {
+		return fmt.Errorf("fingerprint saving error: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO trackFingerprintHashes (hash, track_id) SELECT unnest($1::int[]), $2`,
+		pq.Array(fingerprintHashes(fingerprint)), trackID)
+	if err != nil {
+		return fmt.Errorf("fingerprint indexing error: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"aiartistprod/backend/storage"
 	"bytes"
 	"context"
 	"database/sql"
@@ -9,8 +10,9 @@ import (
 	_ "github.com/lib/pq"
 	redisOrig "github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
 	"log"
@@ -19,7 +21,7 @@ import (
 	"music-service/redisApi"
 	"net"
 	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"time"
 )
@@ -27,114 +29,346 @@ import (
 var logging = logger.Loggerfunc()
 var rdb *redisOrig.Client
 
+// blobStorage хранилище медиафайлов треков: HLS, обложки, волны. Выбирается через STORAGE_BACKEND
+var blobStorage storage.Storage
+
 type MusicServiceServer struct {
 	gen.UnimplementedMusicServiceServer
-	db *sql.DB
+	db             *sql.DB
+	transcodeQueue chan *transcodeJob
 }
 
+// maxStreamUploadSize предел размера аудиофайла, принимаемого через UploadMusicStream
+const maxStreamUploadSize = 500 << 20
+
 func (s *MusicServiceServer) UploadMusic(ctx context.Context, req *gen.UploadMusicRequest) (*gen.UploadMusicResponse, error) {
-	duration, extension, bitRateKbps, err := GetTrackInfo(req.GetMusicContent())
+	audioPath, err := spoolToTempFile(bytes.NewReader(req.GetMusicContent()), maxStreamUploadSize)
 	if err != nil {
-		logging.Printf("ошибка получения длительности и битрейта: %v", err)
+		logging.Printf("ошибка сохранения временного файла: %v", err)
 		return nil, fmt.Errorf("ошибка сохранения трека\n")
 	}
-	fmt.Println(duration, extension, bitRateKbps)
+	defer os.Remove(audioPath)
 
-	/*if extension != "mp3" && extension != "MP3" {
-		return nil, fmt.Errorf("ожидается трек в формате mp3\n")
-	}*/
+	return s.ingestTrack(ctx, req, audioPath)
+}
 
-	timeAddToDbDate := req.GetAddToDbDate().AsTime()
-	// тут реализовать добавку мета в бд
+// UploadMusicStream принимает трек потоком: первое сообщение - метаданные, дальше куски аудио,
+// которые сразу пишутся на диск, поэтому размер трека не ограничен MaxRecvMsgSize
+func (s *MusicServiceServer) UploadMusicStream(stream gen.MusicService_UploadMusicStreamServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		logging.Printf("ошибка получения метаданных трека: %v", err)
+		return fmt.Errorf("ошибка получения метаданных трека: %v", err)
+	}
 
-	var trackID int64
-	//первое добавление данных трека
-	query := `INSERT INTO trackMeta (artist_name, title, album_name, genre, description, duration, release_year, add_to_db_date, owner) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	req := first.GetMeta()
+	if req == nil {
+		logging.Println("первое сообщение потока должно содержать метаданные")
+		return errors.New("первое сообщение потока должно содержать метаданные")
+	}
 
-	err = s.db.QueryRow(query, req.ArtistName, req.Title, req.AlbumName, req.Genre, req.Description, int(duration), req.ReleaseYear, timeAddToDbDate, req.Owner).Scan(&trackID)
+	audioPath, err := spoolToTempFile(&uploadChunkReader{stream: stream}, maxStreamUploadSize)
 	if err != nil {
-		logging.Printf("ошибка добавления метаданных для %s,%s: %v", req.ArtistName, req.Title, err)
-		return nil, fmt.Errorf("ошибка добавления метаданных для %s,%s: %w", req.ArtistName, req.Title, err)
+		logging.Printf("ошибка приема трека %s,%s: %v", req.ArtistName, req.Title, err)
+		return fmt.Errorf("ошибка приема трека: %v", err)
 	}
+	defer os.Remove(audioPath)
 
-	trackIDstring := strconv.FormatInt(trackID, 10)
+	resp, err := s.ingestTrack(stream.Context(), req, audioPath)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(resp)
+}
 
-	err = saveFile("pictures", ".jpeg", req.Owner, trackIDstring, req.GetTrackPicture())
+// ProbeUpload принимает трек потоком кусков без метаданных и возвращает найденные в нем теги
+// и обложку, ничего не сохраняя
+func (s *MusicServiceServer) ProbeUpload(stream gen.MusicService_ProbeUploadServer) error {
+	audioPath, err := spoolToTempFile(&uploadChunkReader{stream: stream}, maxStreamUploadSize)
 	if err != nil {
-		return nil, fmt.Errorf("saving file error: %v", err)
+		logging.Printf("ошибка приема трека для разбора тегов: %v", err)
+		return fmt.Errorf("ошибка приема трека: %v", err)
 	}
+	defer os.Remove(audioPath)
 
-	err = ConvertAudioToHLS(req.GetMusicContent(), req.Owner, trackIDstring)
+	duration, _, _, err := GetTrackInfo(audioPath)
 	if err != nil {
-		query = `DELETE FROM trackMeta WHERE id = $1`
-		_, err = s.db.Exec(query, trackID)
+		logging.Printf("ошибка получения длительности и битрейта: %v", err)
+		return status.Error(codes.InvalidArgument, "файл не распознан как аудио")
+	}
+
+	tags, err := ExtractTrackTags(audioPath)
+	if err != nil {
+		logging.Printf("ошибка чтения тегов: %v", err)
+		return fmt.Errorf("ошибка чтения тегов\n")
+	}
+
+	return stream.SendAndClose(&gen.ProbeUploadResponse{
+		ArtistName:   tags.ArtistName,
+		Title:        tags.Title,
+		AlbumName:    tags.AlbumName,
+		Genre:        tags.Genre,
+		Description:  tags.Description,
+		ReleaseYear:  int32(tags.ReleaseYear),
+		Duration:     int32(duration),
+		TrackPicture: tags.Picture,
+	})
+}
+
+// uploadChunkReader представляет куски аудио из UploadMusicStream и ProbeUpload как io.Reader
+type uploadChunkReader struct {
+	stream interface {
+		Recv() (*gen.UploadMusicChunk, error)
+	}
+	buf []byte
+}
+
+func (r *uploadChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if chunk.GetMeta() != nil {
+			return 0, errors.New("метаданные допустимы только в первом сообщении потока")
+		}
+		r.buf = chunk.GetMusicChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// ingestTrack проверяет сохраненный на диск трек через ffprobe, записывает его метаданные в Postgres
+// и ставит HLS-конвертацию в очередь. Ответ уходит сразу, готовность трека - через GetUploadStatus
+func (s *MusicServiceServer) ingestTrack(ctx context.Context, req *gen.UploadMusicRequest, audioPath string) (*gen.UploadMusicResponse, error) {
+	// Повтор загрузки с тем же upload_id (ответ потерялся, клиент повторил запрос) возвращает
+	// уже принятый трек, а не создает второй
+	if req.GetUploadId() != "" {
+		release, err := lockIngestUpload(ctx, req.Owner, req.GetUploadId())
 		if err != nil {
-			logging.Printf("ошибка удаления из постгре метаданных для %s,%s: %v\n", req.ArtistName, req.Title, err)
+			return nil, err
 		}
+		defer release()
 
-		logging.Printf("ошибка добавления метаданных для %s,%s: %v\n", req.ArtistName, req.Title, err)
+		resp, found, err := s.findIngestedUpload(ctx, req.Owner, req.GetUploadId())
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			logging.Printf("загрузка %s пользователя %s уже принята как трек %d", req.GetUploadId(), req.Owner, resp.TrackID)
+			return resp, nil
+		}
+	}
+
+	duration, extension, bitRateKbps, err := GetTrackInfo(audioPath)
+	if err != nil {
+		logging.Printf("ошибка получения длительности и битрейта: %v", err)
 		return nil, fmt.Errorf("ошибка сохранения трека\n")
 	}
+	fmt.Println(duration, extension, bitRateKbps)
 
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
-	//второе добавление данных трека
-	err = rdb.HSet(ctx, "track"+trackIDstring, map[string]interface{}{
-		"artistName":  req.ArtistName,
-		"title":       req.Title,
-		"albumName":   req.AlbumName,
-		"genre":       req.Genre,
-		"description": req.Description,
-		"duration":    duration,
-		"releaseYear": req.ReleaseYear,
-		"addToDbDate": timeAddToDbDate,
-		"owner":       req.Owner,
-		"likes":       "0",
-		"plays":       "0",
-		"trackID":     trackIDstring,
-	}).Err()
+	// Пустые поля meta_data и отсутствующая обложка берутся из тегов файла
+	if req.Title == "" || req.ArtistName == "" || req.AlbumName == "" || req.Genre == "" ||
+		req.Description == "" || req.ReleaseYear == 0 || len(req.TrackPicture) == 0 {
+		tags, err := ExtractTrackTags(audioPath)
+		if err != nil {
+			logging.Printf("ошибка чтения тегов: %v", err)
+		} else {
+			applyTrackTags(req, tags)
+		}
+	}
+
+	if req.Title == "" || req.ArtistName == "" {
+		return nil, status.Error(codes.InvalidArgument, "не указаны название трека или исполнитель, в тегах файла их тоже нет")
+	}
+
+	if len(req.TrackPicture) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "не передана обложка, в файле ее тоже нет")
+	}
 
+	coverRenditions, err := processCover(req.GetTrackPicture())
 	if err != nil {
-		_, _ = s.db.Exec(`DELETE FROM trackMeta WHERE id = $1`, trackID)
-		logging.Println("Ошибка добавления метаданных в Redis")
-		return nil, errors.New("ошибка добавления метаданных в Redis")
+		logging.Printf("ошибка обработки обложки %s,%s: %v", req.ArtistName, req.Title, err)
+		return nil, status.Error(codes.InvalidArgument, errInvalidCover.Error())
 	}
-	//третье добавление данных трека
-	err = rdb.LPush(ctx, "UserTracks:"+req.Owner, trackIDstring).Err()
+
+	/*if extension != "mp3" && extension != "MP3" {
+		return nil, fmt.Errorf("ожидается трек в формате mp3\n")
+	}*/
+
+	fingerprint, err := ComputeFingerprint(audioPath)
 	if err != nil {
-		_, _ = s.db.Exec(`DELETE FROM trackMeta WHERE id = $1`, trackID)
-		logging.Println("Ошибка добавления метаданных в Redis")
-		return nil, errors.New("ошибка добавления метаданных в Redis")
+		logging.Printf("ошибка построения отпечатка трека %s,%s: %v", req.ArtistName, req.Title, err)
+		return nil, fmt.Errorf("ошибка сохранения трека\n")
 	}
 
-	//четвертое добавление данных трека
-	err = addToListRedis(ctx, "newTracks", "track"+trackIDstring)
+	// Один и тот же звук под разными названиями накручивает plays, а чужой трек перезаливать нельзя
+	var duplicateOf sql.NullInt64
+	duplicateID, found, err := s.findDuplicateTrack(fingerprint, 0)
 	if err != nil {
-		return nil, err
+		logging.Printf("ошибка поиска дубликатов для %s,%s: %v", req.ArtistName, req.Title, err)
+		return nil, fmt.Errorf("ошибка сохранения трека\n")
+	}
+	if found {
+		logging.Printf("трек %s,%s от %s совпадает с треком %d", req.ArtistName, req.Title, req.Owner, duplicateID)
+		if duplicateUploadPolicy == duplicatePolicyReject {
+			return nil, status.Errorf(codes.AlreadyExists, "трек совпадает с уже загруженным треком %d", duplicateID)
+		}
+		duplicateOf = sql.NullInt64{Int64: duplicateID, Valid: true}
+	}
+
+	timeAddToDbDate := req.GetAddToDbDate().AsTime()
+	// тут реализовать добавку мета в бд
+
+	// Прием загрузки - цепочка шагов с компенсациями: ошибка любого шага отменяет предыдущие,
+	// и после отказа от загрузки не остается ни строки, ни обложки, ни задачи, ни статуса в Redis
+	ingest := &saga{name: "загрузка " + req.ArtistName + "," + req.Title}
+
+	var uploadID sql.NullString
+	if req.GetUploadId() != "" {
+		uploadID = sql.NullString{String: req.GetUploadId(), Valid: true}
 	}
-	//пятое добавление данных трека
-	err = addToListRedis(ctx, req.Genre, "track"+trackIDstring)
+
+	// Ключ создается вместе со строкой трека и дальше общий для всех его версий звука
+	var hlsKey interface{}
+	if req.GetEncryptSegments() {
+		key, err := newHLSKey()
+		if err != nil {
+			logging.Printf("ошибка создания ключа для %s,%s: %v", req.ArtistName, req.Title, err)
+			return nil, status.Error(codes.Internal, "ошибка сохранения трека")
+		}
+		hlsKey = key
+	}
+
+	var trackID int64
+	var trackIDstring string
+	//первое добавление данных трека
+	// Трек не виден через GetMeta, пока publishTrack не отметит его опубликованным
+	err = ingest.run(ctx, sagaStep{
+		name: "trackMeta",
+		action: func(ctx context.Context) error {
+			query := `INSERT INTO trackMeta (artist_name, title, album_name, genre, description, duration, release_year, add_to_db_date, owner, duplicate_of, upload_id, hls_key, published) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, FALSE) RETURNING id`
+
+			tx, err := s.db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			err = tx.QueryRow(query, req.ArtistName, req.Title, req.AlbumName, req.Genre, req.Description, int(duration), req.ReleaseYear, timeAddToDbDate, req.Owner, duplicateOf, uploadID, hlsKey).Scan(&trackID)
+			if err != nil {
+				return err
+			}
+			trackIDstring = strconv.FormatInt(trackID, 10)
+
+			err = writeTrackEvent(tx, trackEvent{
+				Type:       trackEventCreated,
+				TrackID:    trackID,
+				Owner:      req.Owner,
+				ArtistName: req.ArtistName,
+				Title:      req.Title,
+			})
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		},
+		compensate: func(ctx context.Context) error {
+			return s.deleteTrackRow(ctx, trackID, trackEventReasonUploadFailed)
+		},
+	})
 	if err != nil {
-		return nil, err
+		logging.Printf("ошибка добавления метаданных для %s,%s: %v", req.ArtistName, req.Title, err)
+		return nil, fmt.Errorf("ошибка добавления метаданных для %s,%s: %w", req.ArtistName, req.Title, err)
 	}
-	//шестое добавление данных трека
-	err = addToSortedSetRedis(ctx, "likes", "track"+trackIDstring)
+
+	// Отпечатки удаляются каскадом вместе со строкой trackMeta
+	err = ingest.run(ctx, sagaStep{
+		name: "fingerprint",
+		action: func(ctx context.Context) error {
+			return s.saveFingerprint(trackID, fingerprint)
+		},
+	})
 	if err != nil {
-		return nil, err
+		logging.Printf("ошибка сохранения отпечатка трека %s: %v", trackIDstring, err)
+		return nil, fmt.Errorf("ошибка сохранения трека\n")
 	}
-	//седьмое добавление данных трека
-	err = addToSortedSetRedis(ctx, "plays", "track"+trackIDstring)
+
+	err = ingest.run(ctx, sagaStep{
+		name: "cover",
+		action: func(ctx context.Context) error {
+			return saveCover(req.Owner, trackIDstring, coverRenditions)
+		},
+		compensate: func(ctx context.Context) error {
+			removeCover(req.Owner, trackIDstring)
+			return nil
+		},
+	})
 	if err != nil {
+		return nil, fmt.Errorf("saving file error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	job := &transcodeJob{
+		TrackID:     trackID,
+		ArtistName:  req.ArtistName,
+		Title:       req.Title,
+		AlbumName:   req.AlbumName,
+		Genre:       req.Genre,
+		Description: req.Description,
+		Duration:    duration,
+		ReleaseYear: req.ReleaseYear,
+		AddToDbDate: timeAddToDbDate,
+		Owner:       req.Owner,
+
+		NormalizeLoudness: req.GetNormalizeLoudness(),
+	}
+
+	// Шаг последний, поэтому компенсация ему не нужна: при ошибке enqueueTranscodeJob
+	// сам убирает файлы задачи и статус
+	err = ingest.run(ctx, sagaStep{
+		name: "transcodeJob",
+		action: func(ctx context.Context) error {
+			return s.enqueueTranscodeJob(ctx, job, audioPath)
+		},
+	})
+	if err != nil {
+		logging.Printf("ошибка постановки трека %s в очередь: %v", trackIDstring, err)
 		return nil, err
 	}
 
+	return uploadResponse(trackID, uploadStatusQueued, duplicateOf), nil
+}
+
+// uploadResponse ответ на принятую загрузку. Трек с тем же звуком, что у уже загруженного,
+// принимается с пометкой на проверку
+func uploadResponse(trackID int64, uploadStatus string, duplicateOf sql.NullInt64) *gen.UploadMusicResponse {
+	if duplicateOf.Valid {
+		return &gen.UploadMusicResponse{
+			Result:      fmt.Sprintf("Трек принят в обработку и отправлен на проверку: совпадает с треком %d", duplicateOf.Int64),
+			TrackID:     int32(trackID),
+			Status:      uploadStatus,
+			DuplicateOf: int32(duplicateOf.Int64),
+		}
+	}
+
 	return &gen.UploadMusicResponse{
-		Result: "Файл успешно записан",
-	}, nil
+		Result:  "Трек принят в обработку",
+		TrackID: int32(trackID),
+		Status:  uploadStatus,
+	}
 }
 
+// addToSortedSetRedis добавляет трек в рейтинг с нулем. Уже набранный счет повторная
+// публикация (перезапуск задачи конвертации) не сбрасывает
 func addToSortedSetRedis(ctx context.Context, sortedSetName, trackName string) error {
-	err := rdb.ZAdd(ctx, sortedSetName, redisOrig.Z{
+	err := rdb.ZAddNX(ctx, sortedSetName, redisOrig.Z{
 		Score:  0,
 		Member: trackName,
 	}).Err()
@@ -145,8 +379,14 @@ func addToSortedSetRedis(ctx context.Context, sortedSetName, trackName string) e
 	return nil
 }
 
+// addToListRedis кладет трек в начало списка. Прежнее вхождение удаляется в той же транзакции,
+// поэтому повтор шага не дублирует трек в списке
 func addToListRedis(ctx context.Context, listName, trackName string) error {
-	err := rdb.LPush(ctx, listName, trackName).Err()
+	pipe := rdb.TxPipeline()
+	pipe.LRem(ctx, listName, 0, trackName)
+	pipe.LPush(ctx, listName, trackName)
+
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		logging.Printf("error to add %s to list %s", trackName, listName)
 		return fmt.Errorf("error to add %s to list %s", trackName, listName)
@@ -155,33 +395,24 @@ func addToListRedis(ctx context.Context, listName, trackName string) error {
 }
 
 func saveFile(fileType, fileExt, username, trackID string, data []byte) error {
-	filePath := filepath.Join(fileType, username, username+"-"+trackID+fileExt)
+	key := path.Join(fileType, username, username+"-"+trackID+fileExt)
 
-	dir := filepath.Dir(filePath)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	if _, err := os.Stat(filePath); err == nil {
-		logging.Printf("file with such name is already exists: %v", err)
-		return fmt.Errorf("file with such name is already exists: %v", err)
+	_, err := blobStorage.Stat(ctx, key)
+	if err == nil {
+		logging.Printf("file with such name is already exists: %s", key)
+		return fmt.Errorf("file with such name is already exists: %s", key)
 	}
-
-	if _, err := os.Stat(dir); err != nil {
-		err = os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			logging.Printf("Ошибка создания директории: %v", err)
-			return fmt.Errorf("не удалось создать директорию: %w", err)
-		}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("ошибка проверки файла: %w", err)
 	}
 
-	file, err := os.Create(filePath)
+	err = blobStorage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), storage.ContentType(key))
 	if err != nil {
 		return fmt.Errorf("ошибка сохранения файла: %v", err)
 	}
-	defer file.Close()
-
-	_, err = file.Write(data)
-	if err != nil {
-		return fmt.Errorf("ошибка записи файла")
-	}
 	return nil
 }
 
@@ -201,109 +432,21 @@ func fileToBytes(file *os.File) ([]byte, error) {
 	return data, nil
 }
 
-func (s *MusicServiceServer) StreamMusic(req *gen.StreamMusicRequest, stream gen.MusicService_StreamMusicServer) error {
-	filePath := filepath.Join("./songs", req.Username, req.Username+"-"+req.TrackId)
-
-	trackAAC_ADTS_CBR, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("ошибка открытия файла: %v", err)
-	}
-	defer trackAAC_ADTS_CBR.Close()
-
-	logging.Println("файл для стриминга открыт")
-
-	trackAAC_ADTS_CBR_bytes, err := io.ReadAll(trackAAC_ADTS_CBR)
-	if err != nil {
-		logging.Println("ошибка чтения данных из trackAAC_ADTS_CBR_bytes:" + err.Error())
-		return errors.New("ошибка чтения данных")
-	}
-
-	duration := durationDetermineADTS(trackAAC_ADTS_CBR_bytes, 128000)
-
-	if err = stream.SendHeader(metadata.Pairs("music-duration", fmt.Sprintf("%f", duration))); err != nil {
-		logging.Printf("ошибка отправки метаданных: %v\n", err)
-		return fmt.Errorf("ошибка отправки метаданных: %v", err)
-	}
-
-	logging.Println("metadata is written")
-
-	startPosition := req.GetStartPosition()
-
-	if startPosition > int64(duration) {
-		logging.Println("Стартовая позиция больше чем длина файла")
-		return fmt.Errorf("стартовая позиция больше чем длина файла")
-	}
-
-	// Перемотка до начала позиции, возвращает готовый кусок с секунды с учетом фреймов
-	songBytesPart, err := sliceADTSFromSecond(trackAAC_ADTS_CBR_bytes, float64(startPosition), 128000)
-	if err != nil {
-		logging.Printf("ошибка обрезки по стартовой позиции: %v\n", err)
-		return fmt.Errorf("ошибка стрима трека")
-	}
-
-	frames, err := parseADTSFramesRaw(songBytesPart) //переводит биты в готовые фреймы
-	if err != nil {
-		logging.Printf("ошибка создания фреймов: %v\n", err)
-		return fmt.Errorf("ошибка стрима трека")
-	}
-
-	var bytesBuffer bytes.Buffer
-	for i := 0; i < len(frames); i += 200 {
-		end := i + 200
-		if end > len(frames) {
-			end = len(frames)
-		}
-
-		for _, v := range frames[i:end] {
-			bytesBuffer.Write(v)
-		}
-
-		data := bytesBuffer.Bytes() //пачка из 200 фреймов
-
-		errSend := stream.Send(&gen.StreamMusicResponse{Data: data})
-		if errSend != nil {
-			logging.Printf("ошибка стрима трека: %v\n", errSend)
-			return fmt.Errorf("ошибка стрима трека: %v", errSend)
-		}
-
-		bytesBuffer.Reset()
-	}
-
-	logging.Println("стриминг завершен")
-
-	return nil
-}
-
 func (s *MusicServiceServer) GetMeta(ctx context.Context, req *gen.GetMetaRequest) (*gen.GetMetaResponse, error) {
 	trackID := req.GetTrackId()
 
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	/*
-		var artistName, title, albumName, genre, description, owner string
-		var addToDBDate time.Time
-		var duration, releaseYear int32
-		var likes, plays int64
-
-		query := `SELECT artist_name, title, album_name, genre, description, duration, release_year, add_to_db_date, owner, likes, plays FROM trackMeta WHERE id = $1`
-
-			err := s.db.QueryRow(query, trackID).Scan(&artistName, &title, &albumName, &genre, &description, &duration, &releaseYear, &addToDBDate, &owner, &likes, &plays)
-			if err != nil {
-					// Добавляем больше контекста в ошибку, чтобы было понятно, какой именно трек не удалось найти
-					if err == sql.ErrNoRows {
-						return nil, fmt.Errorf("метаданные для трека с ID %d не найдены", trackID)
-					}
-					log.Printf("Ошибка при получении метаданных для трека с ID %d: %v", trackID, err)
-					return nil, fmt.Errorf("ошибка при получении метаданных для трека с ID %d: %w", trackID, err)
-				}*/
-
 	trackIDstring := strconv.FormatInt(int64(trackID), 10)
 
-	trackMetaR, err := rdb.HGetAll(ctx, "track"+trackIDstring).Result()
+	trackMetaR, err := s.trackMeta(ctx, trackIDstring)
 	if err != nil {
-		logging.Println("getting track meta error: " + err.Error())
-		return nil, fmt.Errorf("getting track meta error: %v", err)
+		return nil, err
+	}
+
+	if err := authorizeListener(trackMetaR, trackIDstring, req.GetListener(), false); err != nil {
+		return nil, err
 	}
 
 	durationString, _ := trackMetaR["duration"]
@@ -313,29 +456,34 @@ func (s *MusicServiceServer) GetMeta(ctx context.Context, req *gen.GetMetaReques
 	likes, _ := strconv.Atoi(trackMetaR["likes"])
 	plays, _ := strconv.Atoi(trackMetaR["plays"])
 
+	integratedLoudness, _ := strconv.ParseFloat(trackMetaR["integratedLoudness"], 64)
+	truePeak, _ := strconv.ParseFloat(trackMetaR["truePeak"], 64)
+	loudnessRange, _ := strconv.ParseFloat(trackMetaR["loudnessRange"], 64)
+	normalizedRendition, _ := strconv.ParseBool(trackMetaR["normalizedRendition"])
+	audioVersion, _ := strconv.Atoi(trackMetaR["audioVersion"])
+	_, rollbackAvailable := trackMetaR["previousAudioVersion"]
+
 	addToDBDate, _ := time.Parse(time.RFC3339Nano, trackMetaR["addToDbDate"])
 	timeStamp := timestamppb.New(addToDBDate)
 
-	file, err := os.Open(filepath.Join("pictures", trackMetaR["owner"], trackMetaR["owner"]+"-"+trackIDstring+".jpeg"))
-
+	pictureURL, err := coverURL(ctx, trackMetaR["owner"], trackIDstring, int(req.GetPictureSize()))
 	if err != nil {
 		return nil, fmt.Errorf("geting track picture error: %v", err)
 	}
-	defer file.Close()
 
-	// Получаем информацию о файле
-	fileInfo, err := file.Stat()
-	if err != nil {
-		logging.Printf("Ошибка получения информации о файле: %v", err)
-		return nil, fmt.Errorf("error getting file info: %w", err)
+	// Хэши, закэшированные до появления visibility, принадлежат публичным трекам
+	visibility := trackMetaR["visibility"]
+	if visibility == "" {
+		visibility = trackVisibilityPublic
 	}
 
-	// Читаем файл в буфер
-	bufferBytes := make([]byte, fileInfo.Size())
-	n, err := file.Read(bufferBytes)
-	if err != nil {
-		logging.Printf("Ошибка чтения файла изображения: %v", err)
-		return nil, fmt.Errorf("error reading file: %w", err)
+	// Байты обложки только по явной просьбе, обычно клиент грузит ее по pictureURL
+	var picture []byte
+	if req.GetIncludePicture() {
+		picture, err = readCover(ctx, trackMetaR["owner"], trackIDstring, int(req.GetPictureSize()))
+		if err != nil {
+			return nil, fmt.Errorf("geting track picture error: %v", err)
+		}
 	}
 
 	return &gen.GetMetaResponse{
@@ -350,8 +498,17 @@ func (s *MusicServiceServer) GetMeta(ctx context.Context, req *gen.GetMetaReques
 		Owner:        trackMetaR["owner"],
 		Likes:        int64(likes),
 		Plays:        int64(plays),
-		TrackPicture: bufferBytes[:n],
+		TrackPicture: picture,
 		TrackID:      trackID,
+
+		IntegratedLoudness:  integratedLoudness,
+		TruePeak:            truePeak,
+		LoudnessRange:       loudnessRange,
+		NormalizedRendition: normalizedRendition,
+		PictureUrl:          pictureURL,
+		AudioVersion:        int32(audioVersion),
+		RollbackAvailable:   rollbackAvailable,
+		Visibility:          visibility,
 	}, nil
 }
 
@@ -384,6 +541,16 @@ func main() {
 	}
 	defer rdb.Close()
 
+	blobStorage, err = storage.FromEnv(".")
+	if err != nil {
+		logging.Fatalf("Ошибка настройки хранилища файлов: %v", err)
+	}
+
+	hlsSegments, err = hlsSegmentConfigFromEnv()
+	if err != nil {
+		logging.Fatalf("Ошибка настройки сегментов HLS: %v", err)
+	}
+
 	server := &MusicServiceServer{}
 
 	err = server.connectDB()
@@ -394,6 +561,28 @@ func main() {
 		defer server.db.Close()
 	}
 
+	if err := initTranscodeWorkDir(); err != nil {
+		log.Fatalf("Ошибка подготовки рабочей папки конвертации: %v", err)
+	}
+
+	// Обслуживание каталога запускается той же программой: music-service retranscode|audit [флаги]
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "retranscode":
+			err = server.retranscodeCommand(os.Args[2:])
+		case "audit":
+			err = server.auditCommand(os.Args[2:])
+		default:
+			log.Fatalf("неизвестная команда %s", os.Args[1])
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	server.startTranscodeWorkers()
+
 	listener, err := net.Listen("tcp", ":8081")
 	if err != nil {
 		logging.Fatalf("Ошибка создания слушателя на порту 8081: %v", err)
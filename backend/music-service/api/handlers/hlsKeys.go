@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsKeyURI адрес ключа в #EXT-X-KEY. В хранилище ключ не попадает: auth-service переписывает
+// этот адрес на свой эндпоинт выдачи ключа, а тот получает его через GetTrackKey
+const hlsKeyURI = "trackkey"
+
+// newHLSKey случайный ключ AES-128 для сегментов нового трека
+func newHLSKey() ([]byte, error) {
+	key := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// trackHLSKey ключ сегментов трека, nil если трек загружен без шифрования
+func (s *MusicServiceServer) trackHLSKey(ctx context.Context, trackID string) ([]byte, error) {
+	var key []byte
+	err := s.db.QueryRowContext(ctx, `SELECT hls_key FROM trackMeta WHERE id = $1`, trackID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// writeHLSKeyInfo файлы для -hls_key_info_file во временной папке с правами 0700 вне папки,
+// которая уходит в хранилище. IV не задается: FFmpeg берет номер сегмента, как и положено
+// по умолчанию в HLS. Возвращает путь к key info и функцию удаления папки с ключом.
+// Папки, оставшиеся после падения процесса, удаляет initTranscodeWorkDir
+func writeHLSKeyInfo(key []byte) (string, func(), error) {
+	keyDir, err := os.MkdirTemp(transcodeWorkDir, "hlskey_*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	remove := func() { os.RemoveAll(keyDir) }
+
+	keyPath := filepath.Join(keyDir, "hls.key")
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		remove()
+		return "", nil, err
+	}
+
+	keyInfoPath := filepath.Join(keyDir, "hls.keyinfo")
+	err = os.WriteFile(keyInfoPath, []byte(hlsKeyURI+"\n"+keyPath+"\n"), 0600)
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+
+	return keyInfoPath, remove, nil
+}
+
+// hlsSequenceIV IV сегмента номер sequence по умолчанию HLS: номер в младших байтах, big-endian
+func hlsSequenceIV(sequence int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// hlsTagAttributes список атрибутов тега вида NAME=value,NAME="quoted, value"
+func hlsTagAttributes(list string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for list != "" {
+		name, rest, found := strings.Cut(list, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("атрибут без значения: %q", list)
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, found = strings.Cut(rest[1:], `"`)
+			if !found {
+				return nil, fmt.Errorf("незакрытая кавычка в атрибуте %s", name)
+			}
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("мусор после атрибута %s: %q", name, rest)
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		attributes[name] = value
+		list = strings.TrimPrefix(rest, ",")
+	}
+	return attributes, nil
+}
+
+// hlsKeyTag разбирает #EXT-X-KEY. Поддерживаются METHOD=NONE и AES-128 с ключом трека (URI hlsKeyURI).
+// iv - явный IV тега или nil, если IV берется из номера сегмента
+func hlsKeyTag(line string) (encrypted bool, iv []byte, err error) {
+	attributes, err := hlsTagAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+	if err != nil {
+		return false, nil, err
+	}
+
+	switch attributes["METHOD"] {
+	case "NONE":
+		return false, nil, nil
+	case "AES-128":
+	case "":
+		return false, nil, errors.New("нет METHOD")
+	default:
+		return false, nil, fmt.Errorf("неподдерживаемый METHOD %s", attributes["METHOD"])
+	}
+
+	if uri := attributes["URI"]; uri != hlsKeyURI {
+		return false, nil, fmt.Errorf("неизвестный URI ключа %q", uri)
+	}
+
+	value, found := attributes["IV"]
+	if !found {
+		return true, nil, nil
+	}
+	if !strings.HasPrefix(value, "0x") && !strings.HasPrefix(value, "0X") {
+		return false, nil, fmt.Errorf("IV %q без префикса 0x", value)
+	}
+	iv, err = hex.DecodeString(value[2:])
+	if err != nil {
+		return false, nil, fmt.Errorf("некорректный IV %q", value)
+	}
+	if len(iv) != aes.BlockSize {
+		return false, nil, fmt.Errorf("длина IV %d вместо %d", len(iv), aes.BlockSize)
+	}
+	return true, iv, nil
+}
+
+// decryptSegment снимает AES-128-CBC с сегмента и паддинг PKCS#7. Ключ и IV - ровно по 16 байт:
+// AES-192/256 HLS не допускает, и такой ключ в базе говорит о повреждении
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("длина ключа сегмента %d вместо %d", len(key), aes.BlockSize)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("длина IV сегмента %d вместо %d", len(iv), aes.BlockSize)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("длина зашифрованного сегмента %d не кратна блоку", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("некорректный паддинг сегмента")
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("некорректный паддинг сегмента")
+		}
+	}
+
+	return plain[:len(plain)-padding], nil
+}
+
+// GetTrackKey выдает ключ сегментов трека слушателю. Аутентификацию проверяет auth-service,
+// здесь - право слушать: private трек доступен только владельцу
+func (s *MusicServiceServer) GetTrackKey(ctx context.Context, req *gen.GetTrackKeyRequest) (*gen.GetTrackKeyResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	trackMeta, err := s.trackMeta(ctx, trackIDstring)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorizeListener(trackMeta, trackIDstring, req.GetUsername(), true); err != nil {
+		return nil, err
+	}
+
+	key, err := s.trackHLSKey(ctx, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка чтения ключа трека %s: %v", trackIDstring, err)
+		return nil, status.Error(codes.Internal, "ошибка чтения ключа трека")
+	}
+	if key == nil {
+		return nil, status.Errorf(codes.NotFound, "сегменты трека %s не зашифрованы", trackIDstring)
+	}
+
+	return &gen.GetTrackKeyResponse{Key: key}, nil
+}
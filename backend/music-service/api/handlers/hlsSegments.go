@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Формат сегментов HLS. fmp4 (CMAF) - init сегмент с заголовками и .m4s без накладных
+// расходов MPEG-TS, mpegts - прежний формат, его продолжают отдавать старые треки
+const (
+	hlsSegmentTypeFMP4   = "fmp4"
+	hlsSegmentTypeMPEGTS = "mpegts"
+)
+
+// defaultHLSSegmentDuration длительность сегмента в секундах, если HLS_SEGMENT_DURATION не задан
+const defaultHLSSegmentDuration = 6
+
+// hlsFMP4InitFilename имя init сегмента. При нескольких ступенях FFmpeg добавляет к нему номер,
+// поэтому настоящее имя всегда берется из #EXT-X-MAP плейлиста
+const hlsFMP4InitFilename = "init.mp4"
+
+// hlsSegmentConfig настройки сегментов для новых конвертаций. Уже сохраненные треки переходят
+// на новые настройки командой retranscode
+type hlsSegmentConfig struct {
+	Type     string
+	Duration int
+}
+
+var hlsSegments = hlsSegmentConfig{Type: hlsSegmentTypeFMP4, Duration: defaultHLSSegmentDuration}
+
+// hlsSegmentConfigFromEnv читает HLS_SEGMENT_TYPE (fmp4 или mpegts) и HLS_SEGMENT_DURATION (секунды)
+func hlsSegmentConfigFromEnv() (hlsSegmentConfig, error) {
+	config := hlsSegmentConfig{Type: hlsSegmentTypeFMP4, Duration: defaultHLSSegmentDuration}
+
+	switch segmentType := os.Getenv("HLS_SEGMENT_TYPE"); segmentType {
+	case "":
+	case hlsSegmentTypeFMP4, hlsSegmentTypeMPEGTS:
+		config.Type = segmentType
+	default:
+		return config, fmt.Errorf("неизвестный HLS_SEGMENT_TYPE %q, допустимы fmp4 и mpegts", segmentType)
+	}
+
+	if value := os.Getenv("HLS_SEGMENT_DURATION"); value != "" {
+		duration, err := strconv.Atoi(value)
+		if err != nil || duration < 1 || duration > 30 {
+			return config, fmt.Errorf("HLS_SEGMENT_DURATION должен быть числом секунд от 1 до 30, получено %q", value)
+		}
+		config.Duration = duration
+	}
+
+	return config, nil
+}
+
+// ffmpegArgs параметры муксера hls для папки outputDir с поддиректориями ступеней (%v).
+// Шифрование AES-128 в FFmpeg надежно работает только с mpegts, поэтому зашифрованные
+// треки остаются на нем независимо от настройки
+func (config hlsSegmentConfig) ffmpegArgs(outputDir string, encrypted bool) []string {
+	segmentType := config.Type
+	if encrypted {
+		segmentType = hlsSegmentTypeMPEGTS
+	}
+
+	args := []string{
+		"-hls_time", strconv.Itoa(config.Duration),
+		"-hls_segment_type", segmentType,
+	}
+
+	if segmentType == hlsSegmentTypeFMP4 {
+		return append(args,
+			"-hls_fmp4_init_filename", hlsFMP4InitFilename,
+			"-hls_segment_filename", filepath.Join(outputDir, "%v", "segment%d.m4s"),
+		)
+	}
+
+	return append(args, "-hls_segment_filename", filepath.Join(outputDir, "%v", "segment%d.ts"))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxUploadIDLength длина колонки trackMeta.upload_id
+const maxUploadIDLength = 64
+
+// ingestUploadLockTTL сколько держится блокировка upload_id, если процесс упал, не сняв ее
+const ingestUploadLockTTL = 10 * time.Minute
+
+// lockIngestUpload не дает двум одновременным повторам одной загрузки создать два трека.
+// Возвращает функцию снятия блокировки
+func lockIngestUpload(ctx context.Context, owner, uploadID string) (func(), error) {
+	if len(uploadID) > maxUploadIDLength {
+		return nil, status.Errorf(codes.InvalidArgument, "upload_id длиннее %d символов", maxUploadIDLength)
+	}
+
+	key := "ingestUpload:" + owner + ":" + uploadID
+
+	locked, err := rdb.SetNX(ctx, key, 1, ingestUploadLockTTL).Result()
+	if err != nil {
+		logging.Printf("ошибка блокировки загрузки %s: %v", uploadID, err)
+		return nil, status.Error(codes.Internal, "ошибка сохранения трека")
+	}
+	if !locked {
+		return nil, status.Errorf(codes.Aborted, "загрузка %s уже обрабатывается", uploadID)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		rdb.Del(ctx, key)
+	}, nil
+}
+
+// findIngestedUpload ищет трек, уже созданный загрузкой с этим upload_id. Вызывается под
+// lockIngestUpload, поэтому неопубликованная строка без задачи конвертации - след попытки,
+// оборванной падением процесса между шагами: компенсации тогда не выполнились, их выполняем
+// здесь, и загрузка принимается заново
+func (s *MusicServiceServer) findIngestedUpload(ctx context.Context, owner, uploadID string) (*gen.UploadMusicResponse, bool, error) {
+	var trackID int64
+	var genre string
+	var published bool
+	var duplicateOf sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, `SELECT id, genre, published, duplicate_of FROM trackMeta WHERE owner = $1 AND upload_id = $2`,
+		owner, uploadID).Scan(&trackID, &genre, &published, &duplicateOf)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		logging.Printf("ошибка поиска загрузки %s: %v", uploadID, err)
+		return nil, false, status.Error(codes.Internal, "ошибка сохранения трека")
+	}
+
+	trackIDstring := strconv.FormatInt(trackID, 10)
+
+	if published {
+		return uploadResponse(trackID, uploadStatusReady, duplicateOf), true, nil
+	}
+
+	_, err = os.Stat(transcodeJobMetaPath(trackIDstring))
+	if errors.Is(err, os.ErrNotExist) {
+		logging.Printf("загрузка %s оборвалась на треке %s, следы удаляются", uploadID, trackIDstring)
+		if err := s.discardIngestedTrack(ctx, trackID, owner, genre); err != nil {
+			logging.Printf("ошибка удаления следов загрузки %s: %v", uploadID, err)
+			return nil, false, status.Error(codes.Internal, "ошибка сохранения трека")
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		logging.Printf("ошибка проверки задачи конвертации трека %s: %v", trackIDstring, err)
+		return nil, false, status.Error(codes.Internal, "ошибка сохранения трека")
+	}
+
+	uploadStatus, err := rdb.HGet(ctx, "uploadStatus:"+trackIDstring, "status").Result()
+	if err != nil || uploadStatus == "" {
+		uploadStatus = uploadStatusQueued
+	}
+
+	return uploadResponse(trackID, uploadStatus, duplicateOf), true, nil
+}
+
+// discardIngestedTrack компенсации всех шагов приема загрузки разом
+func (s *MusicServiceServer) discardIngestedTrack(ctx context.Context, trackID int64, owner, genre string) error {
+	trackIDstring := strconv.FormatInt(trackID, 10)
+
+	if err := s.deleteTrackRow(ctx, trackID, trackEventReasonUploadFailed); err != nil {
+		return err
+	}
+
+	if _, err := removeTrackFromRedis(ctx, trackIDstring, owner, genre); err != nil {
+		return err
+	}
+
+	return removeTrackMedia(ctx, owner, trackIDstring)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Целевая громкость нормализованной версии трека, как у большинства стриминговых сервисов
+	loudnessTargetLUFS     = -14.0
+	loudnessTargetTruePeak = -1.0
+	loudnessTargetLRA      = 11.0
+	// Нижняя граница измерений EBU R128, ей заменяется -inf у тишины
+	loudnessFloor = -70.0
+
+	hlsNormalizedMasterPlaylist = "master-normalized.m3u8"
+	hlsNormalizedVariantSuffix  = "-norm"
+)
+
+// loudnessInfo результат измерения громкости трека по EBU R128
+type loudnessInfo struct {
+	IntegratedLoudness float64 // LUFS
+	TruePeak           float64 // dBTP
+	LoudnessRange      float64 // LU
+	Threshold          float64 // порог гейтирования, нужен второму проходу loudnorm
+	TargetOffset       float64
+}
+
+// AnalyzeLoudness декодирует трек и измеряет интегральную громкость, true peak и LRA
+// первым (измерительным) проходом фильтра loudnorm
+func AnalyzeLoudness(audioPath string) (*loudnessInfo, error) {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-nostats",
+		"-i", audioPath,
+		"-vn",
+		"-af", fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f:print_format=json", loudnessTargetLUFS, loudnessTargetTruePeak, loudnessTargetLRA),
+		"-f", "null",
+		"-",
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg loudnorm error: %v\n%s", err, stderr.String())
+	}
+
+	// loudnorm печатает JSON последним блоком в stderr
+	output := stderr.String()
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start == -1 || end < start {
+		return nil, errors.New("loudnorm output not found")
+	}
+
+	var measured struct {
+		InputI       string `json:"input_i"`
+		InputTP      string `json:"input_tp"`
+		InputLRA     string `json:"input_lra"`
+		InputThresh  string `json:"input_thresh"`
+		TargetOffset string `json:"target_offset"`
+	}
+
+	if err := json.Unmarshal([]byte(output[start:end+1]), &measured); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm output: %w", err)
+	}
+
+	return &loudnessInfo{
+		IntegratedLoudness: parseLoudnessValue(measured.InputI),
+		TruePeak:           parseLoudnessValue(measured.InputTP),
+		LoudnessRange:      parseLoudnessValue(measured.InputLRA),
+		Threshold:          parseLoudnessValue(measured.InputThresh),
+		TargetOffset:       parseLoudnessValue(measured.TargetOffset),
+	}, nil
+}
+
+func parseLoudnessValue(value string) float64 {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return loudnessFloor
+	}
+	return parsed
+}
+
+// ConvertAudioToHLSNormalized пишет вторую лестницу битрейтов, приведенную к loudnessTargetLUFS,
+// с отдельным master-normalized.m3u8. Используется второй проход loudnorm по измерениям первого
+func ConvertAudioToHLSNormalized(audioPath, username, trackID string, version int, loudness *loudnessInfo, key []byte) error {
+	audioFilter := fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f:measured_I=%.2f:measured_TP=%.2f:measured_LRA=%.2f:measured_thresh=%.2f:offset=%.2f:linear=true,aresample=48000",
+		loudnessTargetLUFS, loudnessTargetTruePeak, loudnessTargetLRA,
+		loudness.IntegratedLoudness, loudness.TruePeak, loudness.LoudnessRange, loudness.Threshold, loudness.TargetOffset)
+
+	return convertAudioToHLSLadder(audioPath, trackAudioDir(username, trackID, version), audioFilter, hlsNormalizedVariantSuffix, hlsNormalizedMasterPlaylist, key)
+}
@@ -1,31 +1,53 @@
 package main
 
 import (
+	"aiartistprod/backend/storage"
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"music-service/api/proto/gen"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// GetTrackInfo анализирует аудиоданные из байтов и возвращает длительность, расширение и битрейт
-func GetTrackInfo(audioData []byte) (float64, string, int, error) {
+// ffprobeOutput нужная часть вывода ffprobe -show_format -show_streams
+type ffprobeOutput struct {
+	Streams []struct {
+		Index       int    `json:"index"`
+		CodecType   string `json:"codec_type"`
+		CodecName   string `json:"codec_name"`
+		BitRate     string `json:"bit_rate"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+		Tags map[string]string `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		FormatName string            `json:"format_name"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+func runFFprobe(audioPath string) (*ffprobeOutput, error) {
 	cmd := exec.Command("ffprobe",
 		"-v", "error",
 		"-show_format",
 		"-show_streams",
 		"-print_format", "json",
-		"-")
-
-	cmd.Stdin = bytes.NewReader(audioData)
+		audioPath)
 
 	var out bytes.Buffer
 	cmd.Stdout = &out
@@ -33,24 +55,22 @@ func GetTrackInfo(audioData []byte) (float64, string, int, error) {
 
 	err := cmd.Run()
 	if err != nil {
-		return 0, "", 0, fmt.Errorf("FFprobe failed: %s | %w", out.String(), err)
+		return nil, fmt.Errorf("FFprobe failed: %s | %w", out.String(), err)
 	}
 
-	var probeData struct {
-		Streams []struct {
-			CodecType string `json:"codec_type"`
-			CodecName string `json:"codec_name"`
-			BitRate   string `json:"bit_rate"`
-		} `json:"streams"`
-		Format struct {
-			Duration   string `json:"duration"`
-			BitRate    string `json:"bit_rate"`
-			FormatName string `json:"format_name"`
-		} `json:"format"`
+	var probeData ffprobeOutput
+	if err := json.Unmarshal(out.Bytes(), &probeData); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
 	}
 
-	if err := json.Unmarshal(out.Bytes(), &probeData); err != nil {
-		return 0, "", 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	return &probeData, nil
+}
+
+// GetTrackInfo анализирует аудиофайл и возвращает длительность, расширение и битрейт
+func GetTrackInfo(audioPath string) (float64, string, int, error) {
+	probeData, err := runFFprobe(audioPath)
+	if err != nil {
+		return 0, "", 0, err
 	}
 
 	var codecName, streamBitRate string
@@ -88,6 +108,142 @@ func GetTrackInfo(audioData []byte) (float64, string, int, error) {
 	return duration, extension, bitRate / 1000, nil
 }
 
+// trackTags метаданные, встроенные в сам файл: ID3 у mp3, Vorbis comments у flac/ogg, атомы MP4
+type trackTags struct {
+	Title       string
+	ArtistName  string
+	AlbumName   string
+	Genre       string
+	Description string
+	ReleaseYear int
+	// Встроенная обложка, перекодированная в JPEG
+	Picture []byte
+}
+
+var tagYearRegexp = regexp.MustCompile(`\d{4}`)
+
+// ExtractTrackTags читает теги и встроенную обложку трека. Отсутствие обложки ошибкой не считается
+func ExtractTrackTags(audioPath string) (*trackTags, error) {
+	probeData, err := runFFprobe(audioPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ключи тегов зависят от контейнера и кодировщика, поэтому сравниваются без учета регистра.
+	// Vorbis comments у ogg лежат в тегах потока, а не формата
+	tagValues := make(map[string]string)
+	collect := func(tags map[string]string) {
+		for key, value := range tags {
+			key = strings.ToLower(key)
+			if _, ok := tagValues[key]; !ok && strings.TrimSpace(value) != "" {
+				tagValues[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	collect(probeData.Format.Tags)
+	pictureStream := -1
+	pictureCodec := ""
+	for _, stream := range probeData.Streams {
+		switch {
+		case stream.CodecType == "audio":
+			collect(stream.Tags)
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 1 && pictureStream == -1:
+			pictureStream = stream.Index
+			pictureCodec = stream.CodecName
+		}
+	}
+
+	firstTag := func(keys ...string) string {
+		for _, key := range keys {
+			if value := tagValues[key]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	tags := &trackTags{
+		Title:       firstTag("title"),
+		ArtistName:  firstTag("artist", "album_artist", "performer"),
+		AlbumName:   firstTag("album"),
+		Genre:       firstTag("genre"),
+		Description: firstTag("comment", "description"),
+	}
+
+	// date бывает как "2021", так и "2021-05-14"
+	if year := tagYearRegexp.FindString(firstTag("date", "year", "originaldate", "tdor")); year != "" {
+		tags.ReleaseYear, _ = strconv.Atoi(year)
+	}
+
+	if pictureStream != -1 {
+		tags.Picture, err = extractAttachedPicture(audioPath, pictureStream, pictureCodec)
+		if err != nil {
+			logging.Printf("ошибка извлечения обложки из %s: %v", audioPath, err)
+		}
+	}
+
+	return tags, nil
+}
+
+// extractAttachedPicture достает встроенную обложку. JPEG копируется как есть, остальное перекодируется
+func extractAttachedPicture(audioPath string, streamIndex int, codecName string) ([]byte, error) {
+	codec := "mjpeg"
+	if codecName == "mjpeg" {
+		codec = "copy"
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", audioPath,
+		"-map", "0:"+strconv.Itoa(streamIndex),
+		"-frames:v", "1",
+		"-c:v", codec,
+		"-f", "image2pipe",
+		"pipe:1",
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\n%s", err, stderr.String())
+	}
+
+	if stdout.Len() == 0 {
+		return nil, errors.New("attached picture is empty")
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// applyTrackTags заполняет тегами файла поля загрузки, которые пользователь оставил пустыми
+func applyTrackTags(req *gen.UploadMusicRequest, tags *trackTags) {
+	if req.Title == "" {
+		req.Title = tags.Title
+	}
+	if req.ArtistName == "" {
+		req.ArtistName = tags.ArtistName
+	}
+	if req.AlbumName == "" {
+		req.AlbumName = tags.AlbumName
+	}
+	if req.Genre == "" {
+		req.Genre = tags.Genre
+	}
+	if req.Description == "" {
+		req.Description = tags.Description
+	}
+	if req.ReleaseYear == 0 {
+		req.ReleaseYear = int32(tags.ReleaseYear)
+	}
+	if len(req.TrackPicture) == 0 {
+		req.TrackPicture = tags.Picture
+	}
+}
+
 func mapCodecToExtension(codec string) string {
 	codecMap := map[string]string{
 		"mp3":  "mp3",
@@ -100,41 +256,92 @@ func mapCodecToExtension(codec string) string {
 	}
 	return codecMap[strings.ToLower(codec)]
 }
-func ConvertAudioToHLS(audioData []byte, username, trackID string) error {
-	// Создаем временный файл с уникальным именем
-	tmpDir := os.TempDir()
-	tmpFileName := fmt.Sprintf("audio_%d_%s.m4a", time.Now().UnixNano(), randomString(6))
-	tmpFilePath := filepath.Join(tmpDir, tmpFileName)
 
-	// Записываем данные во временный файл
-	if err := os.WriteFile(tmpFilePath, audioData, 0644); err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
+// hlsRendition одна ступень лестницы битрейтов: имя поддиректории и битрейт AAC
+type hlsRendition struct {
+	Name    string
+	Bitrate string
+}
+
+// hlsRenditions лестница битрейтов, в которую транскодируется каждый загруженный трек
+var hlsRenditions = []hlsRendition{
+	{Name: "64k", Bitrate: "64k"},
+	{Name: "128k", Bitrate: "128k"},
+	{Name: "256k", Bitrate: "256k"},
+}
+
+const hlsMasterPlaylist = "master.m3u8"
+
+// trackAudioDir папка HLS версии звука трека. Версия 0 - исходная загрузка в корне папки трека,
+// замены звука кладутся рядом в v<N>, поэтому удаление папки трека убирает все версии
+func trackAudioDir(username, trackID string, version int) string {
+	dir := path.Join("songs", username, username+"-"+trackID)
+	if version > 0 {
+		dir = path.Join(dir, "v"+strconv.Itoa(version))
 	}
-	defer os.Remove(tmpFilePath) // Гарантированное удаление после использования
+	return dir
+}
 
-	// Пути для выходных файлов
-	outputDir := filepath.Join("songs", username, username+"-"+trackID)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+// ConvertAudioToHLS транскодирует трек во все ступени hlsRenditions и пишет master.m3u8
+// с #EXT-X-STREAM-INF на каждую ступень, чтобы плеер сам переключал битрейт
+func ConvertAudioToHLS(audioPath, username, trackID string, version int, key []byte) error {
+	return convertAudioToHLSLadder(audioPath, trackAudioDir(username, trackID, version), "", "", hlsMasterPlaylist, key)
+}
+
+// convertAudioToHLSLadder общий проход FFmpeg для лестницы битрейтов в папку trackDir хранилища.
+// audioFilter применяется ко всем ступеням, variantSuffix добавляется к именам их поддиректорий.
+// С key сегменты шифруются AES-128, а в плейлисты добавляется #EXT-X-KEY
+func convertAudioToHLSLadder(audioPath, trackDir, audioFilter, variantSuffix, masterPlaylist string, key []byte) error {
+	// FFmpeg пишет во временную директорию, готовые плейлисты и сегменты потом уходят в хранилище
+	outputDir, err := os.MkdirTemp(transcodeWorkDir, "hls_*")
+	if err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
+	defer os.RemoveAll(outputDir)
 
-	// Команда FFmpeg
-	cmd := exec.Command("ffmpeg",
+	// Один проход FFmpeg: аудиодорожка размножается на каждую ступень лестницы
+	args := []string{
 		"-hide_banner",
 		"-y",
-		"-i", tmpFilePath,
+		"-i", audioPath,
 		"-vn",
+	}
+
+	if audioFilter != "" {
+		args = append(args, "-af", audioFilter)
+	}
+
+	streamMap := make([]string, 0, len(hlsRenditions))
+	for i, rendition := range hlsRenditions {
+		args = append(args, "-map", "0:a:0")
+		args = append(args, fmt.Sprintf("-b:a:%d", i), rendition.Bitrate)
+		streamMap = append(streamMap, fmt.Sprintf("a:%d,name:%s", i, rendition.Name+variantSuffix))
+	}
+
+	args = append(args,
 		"-c:a", "aac",
-		"-b:a", "128k",
 		"-f", "hls",
-		"-hls_time", "1",
 		"-hls_list_size", "0",
 		"-hls_flags", "split_by_time",
-		"-hls_segment_type", "mpegts",
 		"-hls_playlist_type", "vod",
-		"-hls_segment_filename", filepath.Join(outputDir, "segment%d.ts"),
-		filepath.Join(outputDir, "playlist.m3u8"),
+		"-master_pl_name", masterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
 	)
+	args = append(args, hlsSegments.ffmpegArgs(outputDir, key != nil)...)
+
+	if key != nil {
+		keyInfoPath, removeKeyInfo, err := writeHLSKeyInfo(key)
+		if err != nil {
+			return fmt.Errorf("writing HLS key info error: %w", err)
+		}
+		defer removeKeyInfo()
+
+		args = append(args, "-hls_key_info_file", keyInfoPath)
+	}
+
+	args = append(args, filepath.Join(outputDir, "%v", "playlist.m3u8"))
+
+	cmd := exec.Command("ffmpeg", args...)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -143,9 +350,55 @@ func ConvertAudioToHLS(audioData []byte, username, trackID string) error {
 		return fmt.Errorf("ffmpeg error: %v\n%s", err, stderr.String())
 	}
 
+	// master.m3u8 загружается последним: пока его нет, трек в хранилище считается неготовым
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	masterPath := filepath.Join(outputDir, masterPlaylist)
+	masterData, err := os.ReadFile(masterPath)
+	if err != nil {
+		return fmt.Errorf("reading master playlist error: %w", err)
+	}
+	if err := os.Remove(masterPath); err != nil {
+		return err
+	}
+
+	if err := storage.PutDir(ctx, blobStorage, trackDir, outputDir); err != nil {
+		return fmt.Errorf("uploading HLS to storage error: %w", err)
+	}
+
+	masterKey := path.Join(trackDir, masterPlaylist)
+	err = blobStorage.Put(ctx, masterKey, bytes.NewReader(masterData), int64(len(masterData)), storage.ContentType(masterKey))
+	if err != nil {
+		return fmt.Errorf("uploading HLS to storage error: %w", err)
+	}
+
 	return nil
 }
 
+// spoolToTempFile сохраняет аудио из потока во временный файл в рабочей папке процесса, не держа его
+// целиком в памяти. Удаление файла - на вызывающей стороне
+func spoolToTempFile(r io.Reader, limit int64) (string, error) {
+	tmpFile, err := os.CreateTemp(transcodeWorkDir, "upload_*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	written, err := io.Copy(tmpFile, io.LimitReader(r, limit+1))
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if written > limit {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("audio file is larger than %d bytes", limit)
+	}
+
+	return tmpFile.Name(), nil
+}
+
 // Вспомогательная функция для генерации случайной строки
 func randomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -183,51 +436,6 @@ func getAudioDuration(audioData []byte) (float64, error) {
 	return duration, nil
 }
 
-func parseADTSFramesRaw(data []byte) ([][]byte, error) {
-	var frames [][]byte
-	pos := 0
-	for pos < len(data)-7 {
-		if data[pos] == 0xFF && (data[pos+1]&0xF0) == 0xF0 {
-			frameLength := int(((uint16(data[pos+3]&0x03) << 11) | (uint16(data[pos+4]) << 3) | (uint16(data[pos+5]) >> 5)))
-			if frameLength <= 7 || pos+frameLength > len(data) {
-				return nil, errors.New("некорректная длина фрейма или выход за границы")
-			}
-			frame := data[pos : pos+frameLength]
-			frames = append(frames, frame)
-			pos += frameLength
-		} else {
-			pos++
-		}
-	}
-	if len(frames) == 0 {
-		return nil, errors.New("не найдены фреймы ADTS")
-	}
-	return frames, nil
-}
-
-func sliceADTSFromSecond(aacADTS []byte, seekSeconds float64, bitrate int) ([]byte, error) {
-	if bitrate <= 0 {
-		return nil, fmt.Errorf("invalid bitrate")
-	}
-
-	// 1. Вычисляем стартовую позицию по секундам
-	bytesPerSecond := bitrate / 8
-	startOffset := int(seekSeconds * float64(bytesPerSecond))
-
-	// 2. Ищем ближайший заголовок ADTS фрейма (0xFFFx) после смещения
-	i := startOffset
-	for i < len(aacADTS)-1 {
-		// ADTS Syncword: 0xFFF (12 бит), на практике ищем первые два байта
-		if aacADTS[i] == 0xFF && (aacADTS[i+1]&0xF0) == 0xF0 {
-			// Нашли валидный фрейм
-			return aacADTS[i:], nil
-		}
-		i++
-	}
-
-	return nil, fmt.Errorf("could not find ADTS syncword after offset %d", startOffset)
-}
-
 func ConvertMP3ToAAC_ADTS_CBR(mp3Data []byte) ([]byte, error) {
 	cmd := exec.Command("ffmpeg",
 		"-hide_banner",
@@ -404,42 +612,21 @@ func sliceMP3IntoFrames(data []byte) ([][]byte, error) {
 }
 
 func saveFramesToFile(username, trackID string, frames [][]byte) error {
-	filePath := filepath.Join("songs", username, username+"-"+trackID)
-
-	dir := filepath.Dir(filePath)
-
-	if _, err := os.Stat(filePath); err == nil {
-		logging.Printf("file with such name is already exists: %v", err)
-		return fmt.Errorf("file with such name is already exists: %v", err)
-	}
-
-	if _, err := os.Stat(dir); err != nil {
-		err = os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			logging.Printf("Ошибка создания директории: %v", err)
-			return fmt.Errorf("не удалось создать директорию: %w", err)
-		}
-	}
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("error saving mp3 frames: %v", err)
-	}
-	defer file.Close()
+	var buf bytes.Buffer
 
 	for _, frame := range frames {
-		err = binary.Write(file, binary.BigEndian, uint32(len(frame)))
+		err := binary.Write(&buf, binary.BigEndian, uint32(len(frame)))
 		if err != nil {
 			return fmt.Errorf("error saving len mp3 frames: %v", err)
 		}
 
-		_, err = file.Write(frame)
+		_, err = buf.Write(frame)
 		if err != nil {
 			return fmt.Errorf("error saving mp3 frame into file: %v", err)
 		}
 	}
 
-	return nil
+	return saveFile("songs", "", username, trackID, buf.Bytes())
 }
 
 func openFramesFile(path string) ([][]byte, error) {
@@ -485,12 +672,3 @@ func getFramesFromTime(frames [][]byte, startTime float64, sampleRate int) ([][]
 
 	return frames[frameIndex:], nil
 }
-
-func durationDetermineADTS(aacADTS []byte, bitrate int) float64 {
-	if bitrate <= 0 {
-		return 0
-	}
-	bits := len(aacADTS) * 8
-	duration := float64(bits) / float64(bitrate)
-	return duration
-}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Типы событий жизненного цикла трека в trackOutbox
+const (
+	trackEventCreated = "created"
+	trackEventReady   = "ready"
+	trackEventUpdated = "updated"
+	trackEventDeleted = "deleted"
+)
+
+// Причины updated и deleted: подписчику не нужно угадывать, что произошло с треком
+const (
+	trackEventReasonMeta            = "meta"
+	trackEventReasonCover           = "cover"
+	trackEventReasonAudioReplaced   = "audio_replaced"
+	trackEventReasonAudioRolledBack = "audio_rolled_back"
+	trackEventReasonRetranscoded    = "retranscoded"
+	trackEventReasonOwner           = "owner"
+	trackEventReasonUnpublished     = "unpublished"
+	trackEventReasonUploadFailed    = "upload_failed"
+	trackEventReasonAudit           = "audit"
+)
+
+// trackEvent событие, которое relay в auth-service публикует в Kafka как есть
+type trackEvent struct {
+	Type         string    `json:"type"`
+	TrackID      int64     `json:"track_id"`
+	Owner        string    `json:"owner"`
+	ArtistName   string    `json:"artist_name"`
+	Title        string    `json:"title"`
+	Reason       string    `json:"reason,omitempty"`
+	AudioVersion int       `json:"audio_version,omitempty"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+// writeTrackEvent кладет событие в outbox в транзакции изменения trackMeta: событие появляется
+// тогда и только тогда, когда изменение закоммичено
+func writeTrackEvent(tx *sql.Tx, event trackEvent) error {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO trackOutbox (event_type, track_id, payload) VALUES ($1, $2, $3)`,
+		event.Type, event.TrackID, payload)
+	return err
+}
+
+// writeTrackEventFor событие о треке с владельцем и названием из текущей строки trackMeta
+func writeTrackEventFor(tx *sql.Tx, trackID int64, eventType, reason string) error {
+	event := trackEvent{Type: eventType, TrackID: trackID, Reason: reason}
+
+	err := tx.QueryRow(`SELECT owner, artist_name, title, audio_version FROM trackMeta WHERE id = $1`, trackID).
+		Scan(&event.Owner, &event.ArtistName, &event.Title, &event.AudioVersion)
+	if err != nil {
+		return err
+	}
+
+	return writeTrackEvent(tx, event)
+}
+
+// execTrackChange выполняет одиночное изменение трека и пишет событие в той же транзакции.
+// Пустой query означает, что в trackMeta ничего не меняется (например, заменена только обложка)
+func (s *MusicServiceServer) execTrackChange(ctx context.Context, trackID int64, eventType, reason, query string, args ...interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if query != "" {
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+
+	if err := writeTrackEventFor(tx, trackID, eventType, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteTrackRow удаляет строку trackMeta вместе с событием deleted. Повторный вызов для уже
+// удаленной строки ничего не делает и второго события не пишет
+func (s *MusicServiceServer) deleteTrackRow(ctx context.Context, trackID int64, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	event := trackEvent{Type: trackEventDeleted, TrackID: trackID, Reason: reason}
+	err = tx.QueryRow(`DELETE FROM trackMeta WHERE id = $1 RETURNING owner, artist_name, title, audio_version`, trackID).
+		Scan(&event.Owner, &event.ArtistName, &event.Title, &event.AudioVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeTrackEvent(tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
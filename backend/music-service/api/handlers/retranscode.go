@@ -0,0 +1,419 @@
+package main
+
+import (
+	"aiartistprod/backend/storage"
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// originalAudioPath ключ исходного загруженного файла версии звука трека. Исходник хранится,
+// чтобы после смены настроек HLS пересобрать сегменты командой retranscode
+func originalAudioPath(username, trackID string, version int) string {
+	name := username + "-" + trackID
+	if version > 0 {
+		name += "-v" + strconv.Itoa(version)
+	}
+	return path.Join("originals", username, name)
+}
+
+// saveOriginalAudio кладет исходник задачи конвертации в хранилище
+func saveOriginalAudio(job *transcodeJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	key := originalAudioPath(job.Owner, job.trackIDString(), job.AudioVersion)
+	return storage.PutFile(ctx, blobStorage, key, job.AudioPath)
+}
+
+// retranscodeUnit одна версия звука одного трека
+type retranscodeUnit struct {
+	trackID string
+	owner   string
+	version int
+}
+
+func (unit retranscodeUnit) String() string {
+	return unit.trackID + ":" + strconv.Itoa(unit.version)
+}
+
+// retranscodeCommand подкоманда обслуживания: music-service retranscode [флаги].
+// Пересобирает HLS всех треков (текущей и предыдущей версии звука) из сохраненных исходников
+// с текущими настройками convertAudioToHLSLadder. Каждая версия собирается под новым номером
+// и подменяет старую атомарно. Готовые версии запоминаются в Redis-множестве
+// retranscode:<run>, поэтому прерванный запуск с тем же -run продолжается с места остановки
+func (s *MusicServiceServer) retranscodeCommand(args []string) error {
+	flags := flag.NewFlagSet("retranscode", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "только показать, какие треки будут пересобраны")
+	concurrency := flags.Int("concurrency", transcodeWorkers, "сколько треков конвертировать одновременно")
+	run := flags.String("run", "default", "имя запуска, под которым сохраняется прогресс")
+	restart := flags.Bool("restart", false, "забыть прогресс запуска и начать заново")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return errors.New("-concurrency должен быть больше 0")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	progressKey := "retranscode:" + *run
+	if *restart && !*dryRun {
+		if err := rdb.Del(ctx, progressKey).Err(); err != nil {
+			return fmt.Errorf("resetting progress error: %w", err)
+		}
+	}
+
+	units, err := s.retranscodeUnits(ctx)
+	if err != nil {
+		return err
+	}
+
+	done, err := rdb.SMembers(ctx, progressKey).Result()
+	if err != nil {
+		return fmt.Errorf("getting progress error: %w", err)
+	}
+	doneSet := make(map[string]bool, len(done))
+	for _, unit := range done {
+		doneSet[unit] = true
+	}
+
+	var pending []retranscodeUnit
+	for _, unit := range units {
+		if !doneSet[unit.String()] {
+			pending = append(pending, unit)
+		}
+	}
+
+	fmt.Printf("retranscode %s: версий звука %d, уже готово %d, осталось %d\n", *run, len(units), len(units)-len(pending), len(pending))
+
+	if *dryRun {
+		missing := 0
+		for _, unit := range pending {
+			_, err := blobStorage.Stat(ctx, originalAudioPath(unit.owner, unit.trackID, unit.version))
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				missing++
+				fmt.Printf("  трек %s версия %d: нет исходника, будет пропущен\n", unit.trackID, unit.version)
+			case err != nil:
+				return err
+			default:
+				fmt.Printf("  трек %s версия %d: будет пересобран\n", unit.trackID, unit.version)
+			}
+		}
+		fmt.Printf("dry-run: к пересборке %d, без исходника %d\n", len(pending)-missing, missing)
+		return nil
+	}
+
+	var processed, failed, skipped atomic.Int64
+	start := time.Now()
+
+	unitsCh := make(chan retranscodeUnit)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for unit := range unitsCh {
+				result := "готово"
+
+				newVersion, err := s.retranscodeTrack(ctx, unit)
+				switch {
+				case errors.Is(err, errRetranscodeSkipped):
+					skipped.Add(1)
+					result = "пропущен"
+				case err != nil:
+					failed.Add(1)
+					result = "ошибка"
+					logging.Printf("retranscode: трек %s версия %d: %v", unit.trackID, unit.version, err)
+				default:
+					// Версия пересобрана под новым номером, повторный запуск не должен взять ее еще раз
+					rebuilt := retranscodeUnit{trackID: unit.trackID, owner: unit.owner, version: newVersion}
+					if err := rdb.SAdd(context.Background(), progressKey, unit.String(), rebuilt.String()).Err(); err != nil {
+						logging.Printf("retranscode: ошибка сохранения прогресса %s: %v", unit, err)
+					}
+				}
+
+				n := processed.Add(1)
+				fmt.Printf("[%d/%d] трек %s версия %d: %s", n, len(pending), unit.trackID, unit.version, result)
+				if err != nil {
+					fmt.Printf(" (%v)", err)
+				}
+				fmt.Println()
+			}
+		}()
+	}
+
+dispatch:
+	for _, unit := range pending {
+		select {
+		case unitsCh <- unit:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(unitsCh)
+	wg.Wait()
+
+	fmt.Printf("retranscode %s: обработано %d из %d за %s, ошибок %d, пропущено %d\n",
+		*run, processed.Load(), len(pending), time.Since(start).Round(time.Second), failed.Load(), skipped.Load())
+
+	if ctx.Err() != nil {
+		return errors.New("прервано, повторный запуск с тем же -run продолжит с места остановки")
+	}
+	if failed.Load() > 0 {
+		return fmt.Errorf("не удалось пересобрать %d версий, подробности в логе", failed.Load())
+	}
+	return nil
+}
+
+// retranscodeUnits все хранимые версии звука каталога: текущая и предыдущая для отката
+func (s *MusicServiceServer) retranscodeUnits(ctx context.Context) ([]retranscodeUnit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, owner, audio_version, previous_audio_version FROM trackMeta ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("getting tracks error: %w", err)
+	}
+	defer rows.Close()
+
+	var units []retranscodeUnit
+	for rows.Next() {
+		var id int64
+		var owner string
+		var version int
+		var previousVersion *int
+		if err := rows.Scan(&id, &owner, &version, &previousVersion); err != nil {
+			return nil, fmt.Errorf("getting tracks error: %w", err)
+		}
+
+		trackID := strconv.FormatInt(id, 10)
+		units = append(units, retranscodeUnit{trackID: trackID, owner: owner, version: version})
+		if previousVersion != nil {
+			units = append(units, retranscodeUnit{trackID: trackID, owner: owner, version: *previousVersion})
+		}
+	}
+
+	return units, rows.Err()
+}
+
+// errRetranscodeSkipped версию сейчас нельзя или нечем пересобрать, в прогресс она не попадает
+var errRetranscodeSkipped = errors.New("skipped")
+
+// retranscodeTrack пересобирает HLS одной версии в папку новой версии звука и переключает
+// на нее трек одной транзакцией, как switchTrackAudio: слушатели не видят наполовину
+// перезаписанных плейлистов, а сессии, закрепленные за старой версией, доигрывают ее сегменты
+// до переключения. Старая версия удаляется после коммита. Возвращает номер новой версии
+func (s *MusicServiceServer) retranscodeTrack(ctx context.Context, unit retranscodeUnit) (int, error) {
+	if err := checkTrackNotProcessing(ctx, unit.trackID); err != nil {
+		return 0, fmt.Errorf("%w: трек обрабатывается", errRetranscodeSkipped)
+	}
+
+	// Та же блокировка, что у ReplaceTrackAudio: замена не должна занять тот же номер версии
+	locked, err := rdb.SetNX(ctx, "audioReplace:"+unit.trackID, unit.owner, 30*time.Minute).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, fmt.Errorf("%w: звук трека заменяется", errRetranscodeSkipped)
+	}
+	defer rdb.Del(context.Background(), "audioReplace:"+unit.trackID)
+
+	var currentVersion int
+	var previousVersion sql.NullInt64
+	err = s.db.QueryRowContext(ctx, `SELECT audio_version, previous_audio_version FROM trackMeta WHERE id = $1`, unit.trackID).
+		Scan(&currentVersion, &previousVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: трек удален", errRetranscodeSkipped)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("getting audio version error: %w", err)
+	}
+	if unit.version != currentVersion && (!previousVersion.Valid || unit.version != int(previousVersion.Int64)) {
+		return 0, fmt.Errorf("%w: версия больше не хранится", errRetranscodeSkipped)
+	}
+	newVersion := max(currentVersion, int(previousVersion.Int64)) + 1
+
+	body, _, err := blobStorage.Get(ctx, originalAudioPath(unit.owner, unit.trackID, unit.version))
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("%w: нет исходника", errRetranscodeSkipped)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	audioPath, err := spoolToTempFile(body, maxStreamUploadSize)
+	body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("downloading original error: %w", err)
+	}
+	defer os.Remove(audioPath)
+
+	hlsKey, err := s.trackHLSKey(ctx, unit.trackID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Пока трек не переключен, недостроенная версия никому не видна и при ошибке удаляется
+	switched := false
+	defer func() {
+		if switched {
+			return
+		}
+		if err := removeTrackAudioVersion(context.Background(), unit.owner, unit.trackID, newVersion); err != nil {
+			logging.Printf("retranscode: ошибка удаления недостроенной версии %d трека %s: %v", newVersion, unit.trackID, err)
+		}
+	}()
+
+	err = ConvertAudioToHLS(audioPath, unit.owner, unit.trackID, newVersion, hlsKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// Нормализованная лестница пересобирается только там, где она уже была
+	_, err = blobStorage.Stat(ctx, path.Join(trackAudioDir(unit.owner, unit.trackID, unit.version), hlsNormalizedMasterPlaylist))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, err
+	}
+	if err == nil {
+		loudness, err := AnalyzeLoudness(audioPath)
+		if err != nil {
+			return 0, fmt.Errorf("loudness analysis error: %w", err)
+		}
+
+		err = ConvertAudioToHLSNormalized(audioPath, unit.owner, unit.trackID, newVersion, loudness, hlsKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = GenerateWaveform(audioPath, unit.owner, unit.trackID, newVersion)
+	if err != nil {
+		return 0, fmt.Errorf("waveform error: %w", err)
+	}
+
+	err = storage.PutFile(ctx, blobStorage, originalAudioPath(unit.owner, unit.trackID, newVersion), audioPath)
+	if err != nil {
+		return 0, fmt.Errorf("saving original error: %w", err)
+	}
+
+	err = s.switchRetranscodedVersion(ctx, unit, newVersion)
+	if err != nil {
+		return 0, err
+	}
+	switched = true
+
+	// После коммита новая версия уже основная: ошибки дальше не должны удалять ее файлы.
+	// Пока кэш указывает на старую версию, ее файлы тоже нужны слушателям
+	err = s.resetTrackCache(ctx, unit.trackID)
+	if err != nil {
+		return newVersion, fmt.Errorf("версия %d оставлена, кэш трека указывает на нее: %w", unit.version, err)
+	}
+
+	if err := removeTrackAudioVersion(ctx, unit.owner, unit.trackID, unit.version); err != nil {
+		logging.Printf("retranscode: ошибка удаления версии %d трека %s: %v", unit.version, unit.trackID, err)
+	}
+
+	return newVersion, nil
+}
+
+// switchRetranscodedVersion заменяет в trackMeta и trackAudioVersions номер пересобранной версии
+// на newVersion. Версия перечитывается под блокировкой строки: откат мог поменять местами
+// текущую и предыдущую версии, пока шла конвертация
+func (s *MusicServiceServer) switchRetranscodedVersion(ctx context.Context, unit retranscodeUnit, newVersion int) error {
+	trackID, err := strconv.ParseInt(unit.trackID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentVersion int
+	var previousVersion sql.NullInt64
+	err = tx.QueryRow(`SELECT audio_version, previous_audio_version FROM trackMeta WHERE id = $1 FOR UPDATE`, trackID).
+		Scan(&currentVersion, &previousVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: трек удален", errRetranscodeSkipped)
+	}
+	if err != nil {
+		return fmt.Errorf("getting audio version error: %w", err)
+	}
+
+	switch {
+	case unit.version == currentVersion:
+		_, err = tx.Exec(`UPDATE trackMeta SET audio_version = $2 WHERE id = $1`, trackID, newVersion)
+	case previousVersion.Valid && unit.version == int(previousVersion.Int64):
+		_, err = tx.Exec(`UPDATE trackMeta SET previous_audio_version = $2 WHERE id = $1`, trackID, newVersion)
+	default:
+		return fmt.Errorf("%w: версия больше не хранится", errRetranscodeSkipped)
+	}
+	if err != nil {
+		return fmt.Errorf("switching audio version error: %w", err)
+	}
+
+	// У исходной загрузки строки версии может не быть, тогда переносить нечего
+	_, err = tx.Exec(`UPDATE trackAudioVersions SET version = $3 WHERE track_id = $1 AND version = $2`, trackID, unit.version, newVersion)
+	if err != nil {
+		return fmt.Errorf("renaming audio version error: %w", err)
+	}
+
+	err = writeTrackEventFor(tx, trackID, trackEventUpdated, trackEventReasonRetranscoded)
+	if err != nil {
+		return fmt.Errorf("writing track event error: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// hlsMapURI адрес init сегмента из тега #EXT-X-MAP
+func hlsMapURI(line string) (string, bool) {
+	if !strings.HasPrefix(line, "#EXT-X-MAP:") {
+		return "", false
+	}
+	_, uri, found := strings.Cut(line, `URI="`)
+	if !found {
+		return "", false
+	}
+	uri, _, found = strings.Cut(uri, `"`)
+	return uri, found && uri != ""
+}
+
+// readPlaylistEntries ссылки из m3u8 плейлиста: все строки, кроме тегов и пустых, и init сегмент из #EXT-X-MAP
+func readPlaylistEntries(ctx context.Context, key string) ([]string, error) {
+	data, _, err := storage.ReadAll(ctx, blobStorage, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if uri, ok := hlsMapURI(line); ok {
+			entries = append(entries, uri)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// sagaStep шаг многошаговой операции и действие, которое отменяет его результат.
+// compensate может быть nil, если отменять нечего (например, строки уходят каскадом)
+type sagaStep struct {
+	name       string
+	action     func(ctx context.Context) error
+	compensate func(ctx context.Context) error
+}
+
+// saga выполняет шаги по одному. Если шаг не удался, уже выполненные шаги отменяются
+// в обратном порядке, так что после ошибки от операции ничего не остается
+type saga struct {
+	name string
+	done []sagaStep
+}
+
+// run выполняет шаг и запоминает его компенсацию. При ошибке шага сразу вызывает rollback
+func (sg *saga) run(ctx context.Context, step sagaStep) error {
+	if err := step.action(ctx); err != nil {
+		logging.Printf("%s: шаг %s не выполнен: %v", sg.name, step.name, err)
+		sg.rollback()
+		return err
+	}
+
+	sg.done = append(sg.done, step)
+	return nil
+}
+
+// rollback отменяет выполненные шаги. Контекст свой: запрос, из-за отмены которого операция
+// оборвалась, не должен оборвать и откат. Ошибка компенсации не останавливает остальные
+func (sg *saga) rollback() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for i := len(sg.done) - 1; i >= 0; i-- {
+		step := sg.done[i]
+		if step.compensate == nil {
+			continue
+		}
+		if err := step.compensate(ctx); err != nil {
+			logging.Printf("%s: ошибка отмены шага %s: %v", sg.name, step.name, err)
+		}
+	}
+	sg.done = nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"aiartistprod/backend/storage"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"math"
+	"music-service/api/proto/gen"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// streamMusicRendition ступень лестницы, которую StreamMusic отдает без явного quality:
+// тот же битрейт, что был у потока до перехода на HLS
+const streamMusicRendition = "128k"
+
+// streamMusicChunkSize размер одного сообщения StreamMusicResponse
+const streamMusicChunkSize = 64 << 10
+
+// hlsSegment сегмент медиаплейлиста: ключ в хранилище, положение на временной шкале трека
+// и IV, если сегмент зашифрован (#EXT-X-KEY с METHOD=AES-128)
+type hlsSegment struct {
+	key       string
+	start     float64
+	duration  float64
+	encrypted bool
+	iv        []byte
+}
+
+// hlsMediaPlaylist разобранный медиаплейлист ступени. init - ключ init сегмента CMAF,
+// пустой у сегментов mpegts
+type hlsMediaPlaylist struct {
+	init     string
+	segments []hlsSegment
+}
+
+// StreamMusic отдает трек из сохраненных HLS сегментов ступени quality (по умолчанию streamMusicRendition).
+// Перемотка идет по шкале #EXTINF: поток начинается с сегмента, в который попадает
+// start_position, а его фактическое начало уходит клиенту в метаданных music-start.
+// Отмена запроса клиентом прекращает чтение сегментов из хранилища.
+// Зашифрованные сегменты расшифровываются здесь, поэтому их, как и ключ в GetTrackKey, получает
+// только вошедший listener с правом слушать трек. У CMAF первым уходит init сегмент,
+// формат (mpegts или fmp4) клиент узнает из метаданных music-format
+func (s *MusicServiceServer) StreamMusic(req *gen.StreamMusicRequest, stream gen.MusicService_StreamMusicServer) error {
+	ctx := stream.Context()
+
+	if _, err := strconv.ParseInt(req.GetTrackId(), 10, 32); err != nil {
+		return status.Errorf(codes.InvalidArgument, "некорректный ID трека %q", req.GetTrackId())
+	}
+
+	trackMeta, err := s.trackMeta(ctx, req.GetTrackId())
+	if err != nil {
+		return err
+	}
+
+	owner := trackMeta["owner"]
+	if req.GetUsername() != "" && req.GetUsername() != owner {
+		return status.Errorf(codes.NotFound, "трек %s не найден", req.GetTrackId())
+	}
+
+	hlsKey, err := s.trackHLSKey(ctx, req.GetTrackId())
+	if err != nil {
+		logging.Printf("ошибка чтения ключа трека %s: %v", req.GetTrackId(), err)
+		return status.Errorf(codes.Internal, "ошибка стрима трека %s", req.GetTrackId())
+	}
+
+	if err := authorizeListener(trackMeta, req.GetTrackId(), req.GetListener(), hlsKey != nil); err != nil {
+		return err
+	}
+
+	audioVersion, _ := strconv.Atoi(trackMeta["audioVersion"])
+
+	quality := req.GetQuality()
+	if quality == "" {
+		quality = streamMusicRendition
+	}
+
+	playlist, quality, err := streamMusicSegments(ctx, trackAudioDir(owner, req.GetTrackId(), audioVersion), quality, req.GetQuality() != "")
+	if errors.Is(err, errUnknownQuality) {
+		return status.Errorf(codes.InvalidArgument, "у трека %s нет ступени %s", req.GetTrackId(), req.GetQuality())
+	}
+	if err != nil {
+		logging.Printf("ошибка чтения плейлиста трека %s: %v", req.GetTrackId(), err)
+		return status.Errorf(codes.Internal, "ошибка стрима трека %s", req.GetTrackId())
+	}
+	segments := playlist.segments
+	if len(segments) == 0 {
+		return status.Errorf(codes.NotFound, "у трека %s нет сегментов", req.GetTrackId())
+	}
+
+	last := segments[len(segments)-1]
+	duration := last.start + last.duration
+
+	startPosition := float64(req.GetStartPosition())
+	if startPosition < 0 || startPosition >= duration {
+		return status.Errorf(codes.OutOfRange, "стартовая позиция %d вне трека длиной %.3f с", req.GetStartPosition(), duration)
+	}
+
+	first := 0
+	for first+1 < len(segments) && segments[first+1].start <= startPosition {
+		first++
+	}
+
+	format := hlsSegmentTypeMPEGTS
+	if playlist.init != "" {
+		format = hlsSegmentTypeFMP4
+	}
+
+	err = stream.SendHeader(metadata.Pairs(
+		"music-duration", fmt.Sprintf("%f", duration),
+		"music-start", fmt.Sprintf("%f", segments[first].start),
+		"music-format", format,
+		"music-quality", quality,
+	))
+	if err != nil {
+		logging.Printf("ошибка отправки метаданных: %v\n", err)
+		return fmt.Errorf("ошибка отправки метаданных: %v", err)
+	}
+
+	// Init сегмент не несет звука: длительность 0 и начало первого отдаваемого сегмента.
+	// FFmpeg шифрует только mpegts, так что init всегда открытый
+	if playlist.init != "" {
+		err := sendSegment(ctx, stream, hlsSegment{key: playlist.init, start: segments[first].start}, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			logging.Printf("ошибка отправки init сегмента трека %s: %v\n", req.GetTrackId(), err)
+			return fmt.Errorf("ошибка стрима трека: %v", err)
+		}
+	}
+
+	for _, segment := range segments[first:] {
+		if err := ctx.Err(); err != nil {
+			logging.Printf("стриминг трека %s прерван клиентом", req.GetTrackId())
+			return status.FromContextError(err).Err()
+		}
+
+		err := sendSegment(ctx, stream, segment, hlsKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				logging.Printf("стриминг трека %s прерван клиентом", req.GetTrackId())
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			logging.Printf("ошибка стрима трека %s: %v\n", req.GetTrackId(), err)
+			return fmt.Errorf("ошибка стрима трека: %v", err)
+		}
+	}
+
+	logging.Println("стриминг завершен")
+
+	return nil
+}
+
+// sendSegment передает один сегмент кусками по streamMusicChunkSize, не читая его целиком в память.
+// Зашифрованный сегмент приходится прочитать целиком, чтобы снять паддинг ключом трека key
+func sendSegment(ctx context.Context, stream gen.MusicService_StreamMusicServer, segment hlsSegment, key []byte) error {
+	if segment.encrypted && key == nil {
+		return fmt.Errorf("сегмент %s зашифрован, а ключа у трека нет", segment.key)
+	}
+
+	rawBody, _, err := blobStorage.Get(ctx, segment.key)
+	if err != nil {
+		return err
+	}
+	defer rawBody.Close()
+
+	var body io.Reader = rawBody
+	if segment.encrypted {
+		data, err := io.ReadAll(rawBody)
+		if err != nil {
+			return err
+		}
+		data, err = decryptSegment(data, key, segment.iv)
+		if err != nil {
+			return fmt.Errorf("сегмент %s: %w", segment.key, err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	for {
+		// Отправленное сообщение нельзя менять, поэтому буфер на каждый кусок свой
+		buf := make([]byte, streamMusicChunkSize)
+		n, err := io.ReadFull(body, buf)
+		if n > 0 {
+			errSend := stream.Send(&gen.StreamMusicResponse{
+				Data:            buf[:n],
+				SegmentStart:    segment.start,
+				SegmentDuration: segment.duration,
+			})
+			if errSend != nil {
+				return errSend
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errUnknownQuality запрошенной ступени нет в master.m3u8 трека
+var errUnknownQuality = errors.New("unknown quality")
+
+// streamMusicSegments медиаплейлист ступени quality из master.m3u8 версии и имя ступени, которая
+// реально отдается. Если ступени нет, а клиент ее не выбирал явно (strict), берется первая.
+// У треков, загруженных до лестницы битрейтов, ступень одна - playlist.m3u8 в корне папки
+func streamMusicSegments(ctx context.Context, trackDir, quality string, strict bool) (hlsMediaPlaylist, string, error) {
+	variants, err := readPlaylistEntries(ctx, path.Join(trackDir, hlsMasterPlaylist))
+	if errors.Is(err, storage.ErrNotFound) {
+		mediaPlaylist, err := readMediaPlaylist(ctx, path.Join(trackDir, "playlist.m3u8"))
+		return mediaPlaylist, "", err
+	}
+	if err != nil {
+		return hlsMediaPlaylist{}, "", err
+	}
+	if len(variants) == 0 {
+		return hlsMediaPlaylist{}, "", fmt.Errorf("в %s нет ступеней", hlsMasterPlaylist)
+	}
+
+	playlist := ""
+	for _, variant := range variants {
+		if path.Dir(variant) == quality {
+			playlist = variant
+			break
+		}
+	}
+	if playlist == "" {
+		if strict {
+			return hlsMediaPlaylist{}, "", errUnknownQuality
+		}
+		playlist = variants[0]
+	}
+
+	mediaPlaylist, err := readMediaPlaylist(ctx, path.Join(trackDir, playlist))
+	return mediaPlaylist, path.Dir(playlist), err
+}
+
+// readMediaPlaylist читает медиаплейлист key из хранилища и разбирает его parseMediaPlaylist
+func readMediaPlaylist(ctx context.Context, key string) (hlsMediaPlaylist, error) {
+	data, _, err := storage.ReadAll(ctx, blobStorage, key)
+	if err != nil {
+		return hlsMediaPlaylist{}, err
+	}
+	return parseMediaPlaylist(key, data)
+}
+
+// parseMediaPlaylist разбирает медиаплейлист key в сегменты с началом и длительностью из #EXTINF.
+// Номер сегмента отсчитывается от #EXT-X-MEDIA-SEQUENCE, он же IV зашифрованного сегмента,
+// если #EXT-X-KEY не задает IV явно
+func parseMediaPlaylist(key string, data []byte) (hlsMediaPlaylist, error) {
+	var playlist hlsMediaPlaylist
+
+	var position, segmentDuration float64
+	haveDuration := false
+	sequence := 0
+	encrypted := false
+	var keyIV []byte
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			uri, ok := hlsMapURI(line)
+			if !ok {
+				return playlist, fmt.Errorf("некорректный #EXT-X-MAP в %s: %q", key, line)
+			}
+			playlist.init = path.Join(path.Dir(key), uri)
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			var err error
+			encrypted, keyIV, err = hlsKeyTag(line)
+			if err != nil {
+				return playlist, fmt.Errorf("некорректный #EXT-X-KEY в %s: %q: %w", key, line, err)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			var err error
+			sequence, err = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			if err != nil || sequence < 0 {
+				return playlist, fmt.Errorf("некорректный #EXT-X-MEDIA-SEQUENCE в %s: %q", key, line)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			var err error
+			segmentDuration, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || segmentDuration < 0 || math.IsNaN(segmentDuration) || math.IsInf(segmentDuration, 0) {
+				return playlist, fmt.Errorf("некорректный #EXTINF в %s: %q", key, line)
+			}
+			haveDuration = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if !haveDuration {
+				return playlist, fmt.Errorf("сегмент %s без #EXTINF в %s", line, key)
+			}
+			segment := hlsSegment{
+				key:       path.Join(path.Dir(key), line),
+				start:     position,
+				duration:  segmentDuration,
+				encrypted: encrypted,
+			}
+			if encrypted {
+				segment.iv = keyIV
+				if segment.iv == nil {
+					segment.iv = hlsSequenceIV(sequence)
+				}
+			}
+			playlist.segments = append(playlist.segments, segment)
+			position += segmentDuration
+			sequence++
+			haveDuration = false
+		}
+	}
+
+	return playlist, scanner.Err()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseMediaPlaylist(t *testing.T) {
+	const key = "tracks/alice/42/v1/128k/playlist.m3u8"
+	explicitIV := bytes.Repeat([]byte{0xab}, 16)
+
+	tests := []struct {
+		name    string
+		content string
+		want    hlsMediaPlaylist
+		wantErr bool
+	}{
+		{
+			name: "encrypted mpegts",
+			content: "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\"\n" +
+				"#EXTINF:6.000000,\nsegment3.ts\n#EXTINF:2.5,\nsegment4.ts\n#EXT-X-ENDLIST\n",
+			want: hlsMediaPlaylist{segments: []hlsSegment{
+				{key: "tracks/alice/42/v1/128k/segment3.ts", start: 0, duration: 6, encrypted: true, iv: hlsSequenceIV(3)},
+				{key: "tracks/alice/42/v1/128k/segment4.ts", start: 6, duration: 2.5, encrypted: true, iv: hlsSequenceIV(4)},
+			}},
+		},
+		{
+			name:    "plain mpegts",
+			content: "#EXTM3U\n#EXTINF:6,\nsegment0.ts\n",
+			want: hlsMediaPlaylist{segments: []hlsSegment{
+				{key: "tracks/alice/42/v1/128k/segment0.ts", duration: 6},
+			}},
+		},
+		{
+			name:    "cmaf",
+			content: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.000,\nsegment0.m4s\n#EXTINF:1.2,\nsegment1.m4s\n",
+			want: hlsMediaPlaylist{
+				init: "tracks/alice/42/v1/128k/init.mp4",
+				segments: []hlsSegment{
+					{key: "tracks/alice/42/v1/128k/segment0.m4s", duration: 6},
+					{key: "tracks/alice/42/v1/128k/segment1.m4s", start: 6, duration: 1.2},
+				},
+			},
+		},
+		{
+			name: "explicit iv",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\",IV=0xabababababababababababababababab\n" +
+				"#EXTINF:6,\nsegment0.ts\n",
+			want: hlsMediaPlaylist{segments: []hlsSegment{
+				{key: "tracks/alice/42/v1/128k/segment0.ts", duration: 6, encrypted: true, iv: explicitIV},
+			}},
+		},
+		{
+			name: "method none stops encryption",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\"\n#EXTINF:6,\nsegment0.ts\n" +
+				"#EXT-X-KEY:METHOD=NONE\n#EXTINF:6,\nsegment1.ts\n",
+			want: hlsMediaPlaylist{segments: []hlsSegment{
+				{key: "tracks/alice/42/v1/128k/segment0.ts", duration: 6, encrypted: true, iv: hlsSequenceIV(0)},
+				{key: "tracks/alice/42/v1/128k/segment1.ts", start: 6, duration: 6},
+			}},
+		},
+		{
+			name:    "extinf without duration",
+			content: "#EXTINF:,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "extinf not a number",
+			content: "#EXTINF:six,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative extinf",
+			content: "#EXTINF:-1,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "nan extinf",
+			content: "#EXTINF:NaN,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "segment without extinf",
+			content: "#EXTINF:6,\nsegment0.ts\nsegment1.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "key without method",
+			content: "#EXT-X-KEY:URI=\"trackkey\"\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported key method",
+			content: "#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"trackkey\"\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "foreign key uri",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"https://example.com/key\"\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated key uri",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "key attribute without value",
+			content: "#EXT-X-KEY:METHOD\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "short key iv",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\",IV=0xabab\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "key iv without prefix",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\",IV=abababababababababababababababab\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "key iv not hex",
+			content: "#EXT-X-KEY:METHOD=AES-128,URI=\"trackkey\",IV=0xzzababababababababababababababab\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+		{
+			name:    "map without uri",
+			content: "#EXT-X-MAP:BYTERANGE=\"100@0\"\n#EXTINF:6,\nsegment0.m4s\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad media sequence",
+			content: "#EXT-X-MEDIA-SEQUENCE:first\n#EXTINF:6,\nsegment0.ts\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMediaPlaylist(key, []byte(tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseMediaPlaylist() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMediaPlaylist() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMediaPlaylist() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
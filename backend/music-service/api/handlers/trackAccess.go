@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	redisOrig "github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+)
+
+// authorizeListener право listener получить звук трека: public доступен всем, unlisted -
+// вошедшим пользователям, private - только владельцу. requireListener требует вход и для
+// публичного трека (ключ и расшифрованные сегменты зашифрованного трека).
+// Чужой private трек выглядит несуществующим
+func authorizeListener(trackMeta map[string]string, trackID, listener string, requireListener bool) error {
+	visibility := trackMeta["visibility"]
+	if visibility == "" {
+		visibility = trackVisibilityPublic
+	}
+
+	if visibility == trackVisibilityPublic && !requireListener {
+		return nil
+	}
+
+	if listener == "" {
+		return status.Errorf(codes.Unauthenticated, "трек %s доступен только вошедшим пользователям", trackID)
+	}
+
+	if visibility == trackVisibilityPrivate && listener != trackMeta["owner"] {
+		return status.Errorf(codes.NotFound, "трек %s не найден", trackID)
+	}
+
+	return nil
+}
+
+// trackIsPublic трек виден в общих списках: newTracks, жанрах и рейтингах likes и plays.
+// Хэши, закэшированные до появления visibility, принадлежат публичным трекам
+func trackIsPublic(trackMeta map[string]string) bool {
+	return trackMeta["visibility"] == "" || trackMeta["visibility"] == trackVisibilityPublic
+}
+
+// removeTrackFromPublicLists убирает трек, ставший непубличным, из общих списков.
+// UserTracks владельца не трогается: свои треки он видит при любой видимости
+func removeTrackFromPublicLists(ctx context.Context, trackIDstring, genre string) error {
+	pipe := rdb.TxPipeline()
+	pipe.LRem(ctx, "newTracks", 0, "track"+trackIDstring)
+	pipe.LRem(ctx, genre, 0, "track"+trackIDstring)
+	pipe.ZRem(ctx, "likes", "track"+trackIDstring)
+	pipe.ZRem(ctx, "plays", "track"+trackIDstring)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// addTrackToPublicLists возвращает ставший публичным трек в общие списки. Рейтинги
+// восстанавливаются из счетчиков кэша track<ID>, которые копились и без них
+func addTrackToPublicLists(ctx context.Context, trackMeta map[string]string, trackIDstring string) error {
+	likes, _ := strconv.ParseFloat(trackMeta["likes"], 64)
+	plays, _ := strconv.ParseFloat(trackMeta["plays"], 64)
+
+	pipe := rdb.TxPipeline()
+	pipe.LRem(ctx, "newTracks", 0, "track"+trackIDstring)
+	pipe.LPush(ctx, "newTracks", "track"+trackIDstring)
+	pipe.LRem(ctx, trackMeta["genre"], 0, "track"+trackIDstring)
+	pipe.LPush(ctx, trackMeta["genre"], "track"+trackIDstring)
+	pipe.ZAddGT(ctx, "likes", redisOrig.Z{Score: likes, Member: "track" + trackIDstring})
+	pipe.ZAddGT(ctx, "plays", redisOrig.Z{Score: plays, Member: "track" + trackIDstring})
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	redisOrig "github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// trackCacheTTL время жизни хэша track<ID>. Источник истины - trackMeta в Postgres,
+// хэш заполняется при промахе и продлевается при каждом чтении
+const trackCacheTTL = 24 * time.Hour
+
+// trackCacheMarker поле, которое есть только в полностью заполненном хэше. auth-service
+// увеличивает plays через HIncrBy, и после истечения TTL от хэша может остаться один счетчик
+const trackCacheMarker = "trackID"
+
+// trackMeta метаданные опубликованного трека из кэша, а при промахе - из Postgres.
+// Недоступный Redis не прячет трек: тогда данные просто читаются из Postgres
+func (s *MusicServiceServer) trackMeta(ctx context.Context, trackIDstring string) (map[string]string, error) {
+	cached, err := rdb.HGetAll(ctx, "track"+trackIDstring).Result()
+	if err != nil {
+		logging.Println("getting track meta error: " + err.Error())
+	}
+	if err == nil && cached[trackCacheMarker] != "" {
+		rdb.Expire(ctx, "track"+trackIDstring, trackCacheTTL)
+		return cached, nil
+	}
+
+	trackMeta, err := s.loadTrackMeta(ctx, trackIDstring)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeTrackCache(ctx, trackIDstring, trackMeta); err != nil {
+		logging.Printf("ошибка кэширования трека %s: %v", trackIDstring, err)
+	}
+
+	return trackMeta, nil
+}
+
+// refreshTrackCache перезаписывает хэш трека после изменения trackMeta, чтобы кэш не расходился с базой
+func (s *MusicServiceServer) refreshTrackCache(ctx context.Context, trackIDstring string) error {
+	trackMeta, err := s.loadTrackMeta(ctx, trackIDstring)
+	if err != nil {
+		return err
+	}
+
+	return writeTrackCache(ctx, trackIDstring, trackMeta)
+}
+
+// resetTrackCache обновляет хэш трека после переключения версии звука. По audioVersion из хэша
+// auth-service подписывает ссылки на HLS, поэтому если перезаписать хэш не удалось, он удаляется:
+// следующий GetMeta заполнит его из Postgres, а не будет до trackCacheTTL отдавать старую версию.
+// Ошибка возвращается, только если в Redis остался устаревший хэш
+func (s *MusicServiceServer) resetTrackCache(ctx context.Context, trackIDstring string) error {
+	err := s.refreshTrackCache(ctx, trackIDstring)
+	if err == nil {
+		return nil
+	}
+	logging.Printf("ошибка обновления кэша трека %s, кэш удаляется: %v", trackIDstring, err)
+
+	// ctx мог истечь на самом обновлении
+	delCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if delErr := rdb.Del(delCtx, "track"+trackIDstring).Err(); delErr != nil {
+		return fmt.Errorf("dropping stale track cache error: %w (refresh error: %v)", delErr, err)
+	}
+	return nil
+}
+
+// loadTrackMeta читает опубликованный трек из Postgres в виде полей хэша track<ID>.
+// Лайки и прослушивания в Postgres сохраняются с отставанием, их берем из sorted set likes и plays
+func (s *MusicServiceServer) loadTrackMeta(ctx context.Context, trackIDstring string) (map[string]string, error) {
+	var artistName, title, albumName, genre, description, owner, visibility string
+	var duration, releaseYear, audioVersion int
+	var addToDBDate time.Time
+	var likes, plays int64
+	var integratedLoudness, truePeak, loudnessRange sql.NullFloat64
+	var normalizedRendition bool
+	var previousAudioVersion sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, `SELECT artist_name, title, COALESCE(album_name, ''), genre, COALESCE(description, ''),
+		duration, release_year, add_to_db_date, owner, COALESCE(likes, 0), COALESCE(plays, 0), integrated_loudness, true_peak, loudness_range, normalized_rendition,
+		audio_version, previous_audio_version, visibility FROM trackMeta WHERE id = $1 AND published`, trackIDstring).
+		Scan(&artistName, &title, &albumName, &genre, &description, &duration, &releaseYear,
+			&addToDBDate, &owner, &likes, &plays, &integratedLoudness, &truePeak, &loudnessRange, &normalizedRendition,
+			&audioVersion, &previousAudioVersion, &visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "трек %s не найден", trackIDstring)
+	}
+	if err != nil {
+		logging.Printf("ошибка получения метаданных трека %s: %v", trackIDstring, err)
+		return nil, status.Errorf(codes.Internal, "ошибка получения метаданных трека %s", trackIDstring)
+	}
+
+	trackMeta := map[string]string{
+		"artistName":          artistName,
+		"title":               title,
+		"albumName":           albumName,
+		"genre":               genre,
+		"description":         description,
+		"duration":            strconv.Itoa(duration),
+		"releaseYear":         strconv.Itoa(releaseYear),
+		"addToDbDate":         addToDBDate.Format(time.RFC3339Nano),
+		"owner":               owner,
+		"likes":               strconv.FormatInt(likes, 10),
+		"plays":               strconv.FormatInt(plays, 10),
+		"trackID":             trackIDstring,
+		"normalizedRendition": strconv.FormatBool(normalizedRendition),
+		"audioVersion":        strconv.Itoa(audioVersion),
+		"visibility":          visibility,
+	}
+
+	if integratedLoudness.Valid {
+		trackMeta["integratedLoudness"] = strconv.FormatFloat(integratedLoudness.Float64, 'f', -1, 64)
+		trackMeta["truePeak"] = strconv.FormatFloat(truePeak.Float64, 'f', -1, 64)
+		trackMeta["loudnessRange"] = strconv.FormatFloat(loudnessRange.Float64, 'f', -1, 64)
+	}
+	if previousAudioVersion.Valid {
+		trackMeta["previousAudioVersion"] = strconv.FormatInt(previousAudioVersion.Int64, 10)
+	}
+
+	for _, counter := range []string{"likes", "plays"} {
+		score, err := rdb.ZScore(ctx, counter, "track"+trackIDstring).Result()
+		if err == nil {
+			trackMeta[counter] = strconv.FormatInt(int64(score), 10)
+		} else if !errors.Is(err, redisOrig.Nil) {
+			logging.Printf("ошибка получения %s трека %s: %v", counter, trackIDstring, err)
+		}
+	}
+
+	return trackMeta, nil
+}
+
+// writeTrackCache целиком заменяет хэш трека. Del убирает поля, которых в базе больше нет
+// (например громкость после отката версии)
+func writeTrackCache(ctx context.Context, trackIDstring string, trackMeta map[string]string) error {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, "track"+trackIDstring)
+	pipe.HSet(ctx, "track"+trackIDstring, trackMeta)
+	pipe.Expire(ctx, "track"+trackIDstring, trackCacheTTL)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
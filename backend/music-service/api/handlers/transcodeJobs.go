@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	redisOrig "github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	uploadStatusQueued     = "queued"
+	uploadStatusProcessing = "processing"
+	uploadStatusReady      = "ready"
+	uploadStatusFailed     = "failed"
+)
+
+const (
+	transcodeJobsDir     = "transcode-jobs"
+	transcodeWorkers     = 2
+	transcodeQueueSize   = 100
+	uploadStatusFinalTTL = 7 * 24 * time.Hour
+)
+
+// transcodeJob трек, ожидающий HLS-конвертации. Хранится на диске рядом с исходником,
+// чтобы незавершенные задачи переживали перезапуск сервиса
+type transcodeJob struct {
+	TrackID     int64     `json:"track_id"`
+	AudioPath   string    `json:"audio_path"`
+	ArtistName  string    `json:"artist_name"`
+	Title       string    `json:"title"`
+	AlbumName   string    `json:"album_name"`
+	Genre       string    `json:"genre"`
+	Description string    `json:"description"`
+	Duration    float64   `json:"duration"`
+	ReleaseYear int32     `json:"release_year"`
+	AddToDbDate time.Time `json:"add_to_db_date"`
+	Owner       string    `json:"owner"`
+	// Дополнительно собрать лестницу, нормализованную к loudnessTargetLUFS
+	NormalizeLoudness bool `json:"normalize_loudness"`
+	// AudioVersion больше 0 у замены звука уже опубликованного трека: HLS пишется в v<N>,
+	// а вместо publishTrack воспроизведение переключается на новую версию
+	AudioVersion int `json:"audio_version,omitempty"`
+	// DuplicateOf трек, с которым совпал новый звук при политике flag
+	DuplicateOf int64 `json:"duplicate_of,omitempty"`
+	// Fingerprint отпечаток нового звука (encodeFingerprint), посчитанный при приеме замены
+	Fingerprint []byte `json:"fingerprint,omitempty"`
+
+	loudness            *loudnessInfo
+	normalizedRendition bool
+}
+
+func (job *transcodeJob) trackIDString() string {
+	return strconv.FormatInt(job.TrackID, 10)
+}
+
+func transcodeJobMetaPath(trackID string) string {
+	return filepath.Join(transcodeJobsDir, trackID+".json")
+}
+
+// startTranscodeWorkers запускает пул конвертации и возвращает в очередь задачи,
+// прерванные прошлым перезапуском
+func (s *MusicServiceServer) startTranscodeWorkers() {
+	s.transcodeQueue = make(chan *transcodeJob, transcodeQueueSize)
+
+	for i := 0; i < transcodeWorkers; i++ {
+		go func() {
+			for job := range s.transcodeQueue {
+				s.runTranscodeJob(job)
+			}
+		}()
+	}
+
+	entries, err := os.ReadDir(transcodeJobsDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logging.Printf("ошибка чтения директории задач конвертации: %v", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		// Временные файлы лежат в рабочих папках процессов, их убирает initTranscodeWorkDir
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(transcodeJobsDir, entry.Name()))
+		if err != nil {
+			logging.Printf("ошибка чтения задачи конвертации %s: %v", entry.Name(), err)
+			continue
+		}
+
+		job := &transcodeJob{}
+		err = json.Unmarshal(data, job)
+		if err != nil {
+			logging.Printf("ошибка чтения задачи конвертации %s: %v", entry.Name(), err)
+			continue
+		}
+
+		logging.Printf("задача конвертации трека %d возвращена в очередь", job.TrackID)
+		go func() { s.transcodeQueue <- job }()
+	}
+}
+
+// enqueueTranscodeJob переносит исходник в директорию задач, сохраняет задачу на диск
+// и ставит ее в очередь пула. Статус трека становится queued
+func (s *MusicServiceServer) enqueueTranscodeJob(ctx context.Context, job *transcodeJob, audioPath string) error {
+	trackIDstring := job.trackIDString()
+
+	job.AudioPath = filepath.Join(transcodeJobsDir, trackIDstring+".audio")
+	err := os.Rename(audioPath, job.AudioPath)
+	if err != nil {
+		return fmt.Errorf("ошибка переноса исходника трека %s: %w", trackIDstring, err)
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		os.Remove(job.AudioPath)
+		return fmt.Errorf("ошибка сохранения задачи конвертации %s: %w", trackIDstring, err)
+	}
+
+	err = os.WriteFile(transcodeJobMetaPath(trackIDstring), data, 0644)
+	if err != nil {
+		os.Remove(job.AudioPath)
+		return fmt.Errorf("ошибка сохранения задачи конвертации %s: %w", trackIDstring, err)
+	}
+
+	err = setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusQueued, "")
+	if err != nil {
+		removeTranscodeJobFiles(job)
+		return err
+	}
+
+	select {
+	case s.transcodeQueue <- job:
+		return nil
+	default:
+		removeTranscodeJobFiles(job)
+		rdb.Del(ctx, "uploadStatus:"+trackIDstring)
+		return errors.New("очередь конвертации переполнена, попробуйте позже")
+	}
+}
+
+// runTranscodeJob конвертирует трек в HLS и только после этого публикует его в Redis,
+// поэтому необработанные треки не попадают в newTracks, жанры, likes и plays
+func (s *MusicServiceServer) runTranscodeJob(job *transcodeJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	trackIDstring := job.trackIDString()
+
+	err := setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusProcessing, "")
+	if err != nil {
+		logging.Println(err.Error())
+	}
+
+	// Ключ общий для всех версий звука трека, nil у загруженных без шифрования
+	hlsKey, err := s.trackHLSKey(ctx, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка чтения ключа трека %s: %v", trackIDstring, err)
+		s.failTranscodeJob(job, "ошибка конвертации трека")
+		return
+	}
+
+	// Без исходника трек нельзя будет пересобрать после смены настроек HLS
+	err = saveOriginalAudio(job)
+	if err != nil {
+		logging.Printf("ошибка сохранения исходника трека %s: %v", trackIDstring, err)
+		s.failTranscodeJob(job, "ошибка сохранения трека")
+		return
+	}
+
+	// Громкость не критична для публикации: без нее трек просто остается без нормализации
+	job.loudness, err = AnalyzeLoudness(job.AudioPath)
+	if err != nil {
+		logging.Printf("ошибка измерения громкости трека %s: %v", trackIDstring, err)
+	}
+
+	err = ConvertAudioToHLS(job.AudioPath, job.Owner, trackIDstring, job.AudioVersion, hlsKey)
+	if err != nil {
+		logging.Printf("ошибка конвертации трека %s: %v", trackIDstring, err)
+		s.failTranscodeJob(job, "ошибка конвертации трека")
+		return
+	}
+
+	// Без волны плеер показывает обычный прогресс-бар, публикацию она не блокирует
+	err = GenerateWaveform(job.AudioPath, job.Owner, trackIDstring, job.AudioVersion)
+	if err != nil {
+		logging.Printf("ошибка построения волны трека %s: %v", trackIDstring, err)
+	}
+
+	if job.loudness != nil && job.NormalizeLoudness {
+		err = ConvertAudioToHLSNormalized(job.AudioPath, job.Owner, trackIDstring, job.AudioVersion, job.loudness, hlsKey)
+		if err != nil {
+			logging.Printf("ошибка нормализации громкости трека %s: %v", trackIDstring, err)
+		}
+		job.normalizedRendition = err == nil
+	}
+
+	// Замена звука: громкость и длительность переезжают в trackMeta вместе с переключением версии
+	if job.AudioVersion > 0 {
+		err = s.switchTrackAudio(job)
+		if err != nil {
+			logging.Printf("ошибка переключения трека %s на версию %d: %v", trackIDstring, job.AudioVersion, err)
+			s.failTranscodeJob(job, "ошибка переключения на новую версию звука")
+			return
+		}
+
+		removeTranscodeJobFiles(job)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		err = setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusReady, "")
+		if err != nil {
+			logging.Println(err.Error())
+		}
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = s.publishTrack(ctx, job)
+	if err != nil {
+		logging.Printf("ошибка публикации трека %s: %v", trackIDstring, err)
+		s.failTranscodeJob(job, err.Error())
+		return
+	}
+
+	removeTranscodeJobFiles(job)
+
+	err = setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusReady, "")
+	if err != nil {
+		logging.Println(err.Error())
+	}
+}
+
+// publishTrack отмечает готовый трек опубликованным в Postgres вместе с результатами обработки,
+// заполняет кэш track<ID> и добавляет трек во все списки Redis. Каждый шаг можно повторить
+// (задача после перезапуска выполняется заново), а при ошибке уже сделанные шаги отменяются
+func (s *MusicServiceServer) publishTrack(ctx context.Context, job *transcodeJob) error {
+	trackIDstring := job.trackIDString()
+	publish := &saga{name: "публикация трека " + trackIDstring}
+
+	var integratedLoudness, truePeak, loudnessRange sql.NullFloat64
+	if job.loudness != nil {
+		integratedLoudness = sql.NullFloat64{Float64: job.loudness.IntegratedLoudness, Valid: true}
+		truePeak = sql.NullFloat64{Float64: job.loudness.TruePeak, Valid: true}
+		loudnessRange = sql.NullFloat64{Float64: job.loudness.LoudnessRange, Valid: true}
+	}
+
+	err := publish.run(ctx, sagaStep{
+		name: "published",
+		action: func(ctx context.Context) error {
+			tx, err := s.db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			_, err = tx.Exec(`UPDATE trackMeta SET integrated_loudness = $1, true_peak = $2, loudness_range = $3,
+				normalized_rendition = $4, published = TRUE WHERE id = $5`,
+				integratedLoudness, truePeak, loudnessRange, job.normalizedRendition, job.TrackID)
+			if err != nil {
+				return err
+			}
+
+			err = writeTrackEventFor(tx, job.TrackID, trackEventReady, "")
+			if err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		},
+		compensate: func(ctx context.Context) error {
+			return s.execTrackChange(ctx, job.TrackID, trackEventUpdated, trackEventReasonUnpublished,
+				`UPDATE trackMeta SET published = FALSE WHERE id = $1`, job.TrackID)
+		},
+	})
+	if err != nil {
+		logging.Printf("ошибка публикации трека %s в постгре: %v", trackIDstring, err)
+		return errors.New("ошибка сохранения метаданных трека")
+	}
+
+	//второе добавление данных трека
+	err = publish.run(ctx, sagaStep{
+		name: "cache",
+		action: func(ctx context.Context) error {
+			return s.refreshTrackCache(ctx, trackIDstring)
+		},
+		compensate: func(ctx context.Context) error {
+			return rdb.Del(ctx, "track"+trackIDstring).Err()
+		},
+	})
+	if err != nil {
+		logging.Println("Ошибка добавления метаданных в Redis")
+		return errors.New("ошибка добавления метаданных в Redis")
+	}
+
+	// Непубличный трек попадает только в UserTracks владельца, общие списки и рейтинги
+	// его не показывают
+	trackMeta, err := s.trackMeta(ctx, trackIDstring)
+	if err != nil {
+		logging.Printf("ошибка чтения трека %s перед публикацией: %v", trackIDstring, err)
+		publish.rollback()
+		return errors.New("ошибка добавления метаданных в Redis")
+	}
+	public := trackIsPublic(trackMeta)
+
+	type listEntry struct {
+		key   string
+		value string
+	}
+
+	lists := []listEntry{{"UserTracks:" + job.Owner, trackIDstring}}
+	if public {
+		lists = append(lists, listEntry{"newTracks", "track" + trackIDstring}, listEntry{job.Genre, "track" + trackIDstring})
+	}
+
+	//третье - пятое добавление данных трека
+	for _, list := range lists {
+		err = publish.run(ctx, sagaStep{
+			name: list.key,
+			action: func(ctx context.Context) error {
+				return addToListRedis(ctx, list.key, list.value)
+			},
+			compensate: func(ctx context.Context) error {
+				return rdb.LRem(ctx, list.key, 0, list.value).Err()
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !public {
+		return nil
+	}
+
+	//шестое и седьмое добавление данных трека
+	for _, rating := range []string{"likes", "plays"} {
+		err = publish.run(ctx, sagaStep{
+			name: rating,
+			action: func(ctx context.Context) error {
+				return addToSortedSetRedis(ctx, rating, "track"+trackIDstring)
+			},
+			compensate: func(ctx context.Context) error {
+				return rdb.ZRem(ctx, rating, "track"+trackIDstring).Err()
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failTranscodeJob убирает следы трека, который не удалось обработать, и сохраняет текст ошибки.
+// При неудачной замене звука удаляется только новая версия, опубликованный трек остается как был
+func (s *MusicServiceServer) failTranscodeJob(job *transcodeJob, errText string) {
+	trackIDstring := job.trackIDString()
+
+	if job.AudioVersion > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := removeTrackAudioVersion(ctx, job.Owner, trackIDstring, job.AudioVersion); err != nil {
+			logging.Printf("ошибка удаления версии %d трека %s: %v", job.AudioVersion, trackIDstring, err)
+		}
+		removeTranscodeJobFiles(job)
+
+		err := setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusFailed, errText)
+		if err != nil {
+			logging.Println(err.Error())
+		}
+		return
+	}
+
+	err := s.deleteTrackRow(context.Background(), job.TrackID, trackEventReasonUploadFailed)
+	if err != nil {
+		logging.Printf("ошибка удаления из постгре метаданных для %s,%s: %v\n", job.ArtistName, job.Title, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := removeTrackMedia(ctx, job.Owner, trackIDstring); err != nil {
+		logging.Printf("ошибка удаления файлов трека %s: %v", trackIDstring, err)
+	}
+	removeTranscodeJobFiles(job)
+
+	// Свои шаги publishTrack отменяет сам, но запуск задачи, оборванный перезапуском сервиса,
+	// мог успеть заполнить кэш и часть списков
+	if _, err := removeTrackFromRedis(ctx, trackIDstring, job.Owner, job.Genre); err != nil {
+		logging.Printf("ошибка удаления трека %s из Redis: %v", trackIDstring, err)
+	}
+
+	err = setUploadStatus(ctx, trackIDstring, job.Owner, uploadStatusFailed, errText)
+	if err != nil {
+		logging.Println(err.Error())
+	}
+}
+
+func removeTranscodeJobFiles(job *transcodeJob) {
+	os.Remove(job.AudioPath)
+	os.Remove(transcodeJobMetaPath(job.trackIDString()))
+}
+
+// checkTrackNotProcessing запрещает менять трек, пока по нему идет задача конвертации:
+// по ее окончании publishTrack или переключение версии перезапишут сделанные изменения
+func checkTrackNotProcessing(ctx context.Context, trackIDstring string) error {
+	uploadStatus, err := rdb.HGet(ctx, "uploadStatus:"+trackIDstring, "status").Result()
+	if err != nil && !errors.Is(err, redisOrig.Nil) {
+		logging.Println("getting upload status error: " + err.Error())
+		return status.Errorf(codes.Internal, "getting upload status error: %v", err)
+	}
+	if uploadStatus == uploadStatusQueued || uploadStatus == uploadStatusProcessing {
+		return status.Errorf(codes.FailedPrecondition, "трек %s еще обрабатывается", trackIDstring)
+	}
+	return nil
+}
+
+// setUploadStatus сохраняет статус обработки трека. Финальные статусы живут uploadStatusFinalTTL
+func setUploadStatus(ctx context.Context, trackID, owner, status, errText string) error {
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, "uploadStatus:"+trackID, map[string]interface{}{
+		"status": status,
+		"error":  errText,
+		"owner":  owner,
+	})
+
+	if status == uploadStatusReady || status == uploadStatusFailed {
+		pipe.Expire(ctx, "uploadStatus:"+trackID, uploadStatusFinalTTL)
+	} else {
+		pipe.Persist(ctx, "uploadStatus:"+trackID)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logging.Printf("ошибка сохранения статуса трека %s: %v", trackID, err)
+		return fmt.Errorf("ошибка сохранения статуса трека %s: %v", trackID, err)
+	}
+	return nil
+}
+
+func (s *MusicServiceServer) GetUploadStatus(ctx context.Context, req *gen.GetUploadStatusRequest) (*gen.GetUploadStatusResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	uploadStatus, err := rdb.HGetAll(ctx, "uploadStatus:"+trackIDstring).Result()
+	if err != nil {
+		logging.Println("getting upload status error: " + err.Error())
+		return nil, fmt.Errorf("getting upload status error: %v", err)
+	}
+
+	if len(uploadStatus) == 0 {
+		// статус готового трека мог истечь, сам трек при этом опубликован
+		trackMeta, err := s.trackMeta(ctx, trackIDstring)
+		if err != nil {
+			return nil, err
+		}
+
+		uploadStatus = map[string]string{"status": uploadStatusReady, "owner": trackMeta["owner"]}
+	}
+
+	return &gen.GetUploadStatusResponse{
+		TrackID: req.GetTrackId(),
+		Status:  uploadStatus["status"],
+		Error:   uploadStatus["error"],
+		Owner:   uploadStatus["owner"],
+	}, nil
+}
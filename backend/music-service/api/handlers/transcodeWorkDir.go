@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// transcodeWorkRoot корень рабочих папок процессов. transcode-jobs общий для сервиса и
+// подкоманд retranscode и audit, поэтому временные файлы каждый процесс держит в своей папке
+var transcodeWorkRoot = filepath.Join(transcodeJobsDir, "work")
+
+// transcodeWorkDir рабочая папка этого процесса: принятые загрузки (.tmp), выход FFmpeg (hls_*)
+// и ключи шифрования (hlskey_*). Пока процесс жив, на файле .lock в ней держится блокировка
+var (
+	transcodeWorkDir  string
+	transcodeWorkLock *os.File
+)
+
+// initTranscodeWorkDir создает рабочую папку процесса и удаляет папки процессов, которые
+// завершились, не убрав за собой. Папки живых процессов не трогаются
+func initTranscodeWorkDir() error {
+	if err := os.MkdirAll(transcodeWorkRoot, 0700); err != nil {
+		return fmt.Errorf("creating work dir error: %w", err)
+	}
+
+	dir, err := os.MkdirTemp(transcodeWorkRoot, strconv.Itoa(os.Getpid())+"_*")
+	if err != nil {
+		return fmt.Errorf("creating work dir error: %w", err)
+	}
+
+	lock, err := os.Create(filepath.Join(dir, ".lock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("creating work dir lock error: %w", err)
+	}
+	if err := tryLockFile(lock); err != nil {
+		lock.Close()
+		os.RemoveAll(dir)
+		return fmt.Errorf("locking work dir error: %w", err)
+	}
+
+	transcodeWorkDir, transcodeWorkLock = dir, lock
+
+	removeStaleWorkDirs()
+	return nil
+}
+
+// removeStaleWorkDirs удаляет рабочие папки, блокировку которых никто не держит
+func removeStaleWorkDirs() {
+	entries, err := os.ReadDir(transcodeWorkRoot)
+	if err != nil {
+		logging.Printf("ошибка чтения рабочих папок конвертации: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(transcodeWorkRoot, entry.Name())
+		if !entry.IsDir() || dir == transcodeWorkDir {
+			continue
+		}
+
+		// Без .lock папка только создается другим процессом
+		lock, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_RDWR, 0)
+		if err != nil {
+			continue
+		}
+
+		if tryLockFile(lock) == nil {
+			if err := os.RemoveAll(dir); err != nil {
+				logging.Printf("ошибка удаления рабочей папки %s: %v", dir, err)
+			}
+		}
+		lock.Close()
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"music-service/api/proto/gen"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// trackMetaField редактируемое поле трека: колонка trackMeta и предел длины VARCHAR
+type trackMetaField struct {
+	column   string
+	maxLen   int
+	required bool
+}
+
+var (
+	trackMetaTitle       = trackMetaField{column: "title", maxLen: 100, required: true}
+	trackMetaAlbumName   = trackMetaField{column: "album_name", maxLen: 100}
+	trackMetaGenre       = trackMetaField{column: "genre", maxLen: 100, required: true}
+	trackMetaDescription = trackMetaField{column: "description", maxLen: 300}
+)
+
+// Доступ к треку. Ссылки на HLS непубличного трека auth-service выдает только после проверки JWT:
+// unlisted - любому авторизованному пользователю, private - только владельцу
+const (
+	trackVisibilityPublic   = "public"
+	trackVisibilityUnlisted = "unlisted"
+	trackVisibilityPrivate  = "private"
+)
+
+// UpdateTrackMeta меняет метаданные и обложку опубликованного трека, не трогая прослушивания и лайки.
+// Изменения пишутся в Postgres, после чего кэш track<ID> перечитывается из базы, а при смене
+// жанра трек переезжает из списка старого жанра в список нового. Возвращает метаданные после изменения
+func (s *MusicServiceServer) UpdateTrackMeta(ctx context.Context, req *gen.UpdateTrackMetaRequest) (*gen.GetMetaResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	owner, oldGenre, err := s.trackOwnerAndGenre(ctx, req.GetTrackId())
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != req.GetUsername() {
+		return nil, status.Errorf(codes.PermissionDenied, "трек %s принадлежит другому пользователю", trackIDstring)
+	}
+
+	// publishTrack по окончании обработки перезапишет хэш трека значениями из загрузки
+	if err := checkTrackNotProcessing(ctx, trackIDstring); err != nil {
+		return nil, err
+	}
+
+	trackMetaBefore, err := s.trackMeta(ctx, trackIDstring)
+	if err != nil {
+		return nil, err
+	}
+	wasPublic := trackIsPublic(trackMetaBefore)
+
+	var columns []string
+	var args []interface{}
+	newGenre := ""
+
+	setField := func(field trackMetaField, value *string) error {
+		if value == nil {
+			return nil
+		}
+
+		trimmed := strings.TrimSpace(*value)
+		if field.required && trimmed == "" {
+			return status.Errorf(codes.InvalidArgument, "поле %s не может быть пустым", field.column)
+		}
+		if utf8.RuneCountInString(trimmed) > field.maxLen {
+			return status.Errorf(codes.InvalidArgument, "поле %s длиннее %d символов", field.column, field.maxLen)
+		}
+
+		args = append(args, trimmed)
+		columns = append(columns, fmt.Sprintf("%s = $%d", field.column, len(args)))
+		if field == trackMetaGenre {
+			newGenre = trimmed
+		}
+		return nil
+	}
+
+	for _, update := range []struct {
+		field trackMetaField
+		value *string
+	}{
+		{trackMetaTitle, req.Title},
+		{trackMetaAlbumName, req.AlbumName},
+		{trackMetaGenre, req.Genre},
+		{trackMetaDescription, req.Description},
+	} {
+		if err := setField(update.field, update.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.ReleaseYear != nil {
+		releaseYear := req.GetReleaseYear()
+		if releaseYear < 0 || int(releaseYear) > time.Now().Year()+1 {
+			return nil, status.Errorf(codes.InvalidArgument, "некорректный год выпуска %d", releaseYear)
+		}
+
+		args = append(args, releaseYear)
+		columns = append(columns, fmt.Sprintf("release_year = $%d", len(args)))
+	}
+
+	if req.Visibility != nil {
+		visibility := req.GetVisibility()
+		if visibility != trackVisibilityPublic && visibility != trackVisibilityUnlisted && visibility != trackVisibilityPrivate {
+			return nil, status.Errorf(codes.InvalidArgument, "некорректный доступ %q, допустимы public, unlisted и private", visibility)
+		}
+
+		args = append(args, visibility)
+		columns = append(columns, fmt.Sprintf("visibility = $%d", len(args)))
+	}
+
+	var coverRenditions map[int][]byte
+	if len(req.GetTrackPicture()) > 0 {
+		coverRenditions, err = processCover(req.GetTrackPicture())
+		if err != nil {
+			logging.Printf("ошибка обработки новой обложки трека %s: %v", trackIDstring, err)
+			return nil, status.Error(codes.InvalidArgument, errInvalidCover.Error())
+		}
+	}
+
+	if len(columns) == 0 && coverRenditions == nil {
+		return nil, status.Error(codes.InvalidArgument, "не передано ни одного изменения")
+	}
+
+	if len(columns) > 0 {
+		args = append(args, req.GetTrackId())
+		query := fmt.Sprintf(`UPDATE trackMeta SET %s WHERE id = $%d`, strings.Join(columns, ", "), len(args))
+
+		err = s.execTrackChange(ctx, int64(req.GetTrackId()), trackEventUpdated, trackEventReasonMeta, query, args...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "трек %s не найден", trackIDstring)
+		}
+		if err != nil {
+			logging.Printf("ошибка обновления метаданных трека %s: %v", trackIDstring, err)
+			return nil, status.Errorf(codes.Internal, "ошибка обновления трека %s", trackIDstring)
+		}
+	}
+
+	if coverRenditions != nil {
+		err = replaceCover(ctx, owner, trackIDstring, coverRenditions)
+		if err != nil {
+			logging.Printf("ошибка замены обложки трека %s: %v", trackIDstring, err)
+			return nil, status.Errorf(codes.Internal, "ошибка замены обложки трека %s", trackIDstring)
+		}
+	}
+
+	// Обложка хранится только в хранилище, событие о ней пишется отдельно от trackMeta
+	if coverRenditions != nil && len(columns) == 0 {
+		err = s.execTrackChange(ctx, int64(req.GetTrackId()), trackEventUpdated, trackEventReasonCover, "")
+		if err != nil {
+			logging.Printf("ошибка записи события обложки трека %s: %v", trackIDstring, err)
+		}
+	}
+
+	if len(columns) > 0 {
+		err = s.updateTrackInRedis(ctx, trackIDstring, oldGenre, newGenre, wasPublic)
+		if err != nil {
+			logging.Printf("ошибка обновления трека %s в Redis: %v", trackIDstring, err)
+			return nil, status.Errorf(codes.Internal, "ошибка обновления трека %s", trackIDstring)
+		}
+	}
+
+	logging.Printf("метаданные трека %s обновлены владельцем %s", trackIDstring, owner)
+
+	return s.GetMeta(ctx, &gen.GetMetaRequest{TrackId: req.GetTrackId(), Listener: owner})
+}
+
+// updateTrackInRedis перечитывает кэш трека из Postgres, при смене видимости убирает трек из общих
+// списков или возвращает в них, а при смене жанра публичного трека переносит его между списками жанров
+func (s *MusicServiceServer) updateTrackInRedis(ctx context.Context, trackIDstring, oldGenre, newGenre string, wasPublic bool) error {
+	err := s.refreshTrackCache(ctx, trackIDstring)
+	if err != nil {
+		return err
+	}
+
+	trackMeta, err := s.trackMeta(ctx, trackIDstring)
+	if err != nil {
+		return err
+	}
+
+	switch isPublic := trackIsPublic(trackMeta); {
+	case wasPublic && !isPublic:
+		return removeTrackFromPublicLists(ctx, trackIDstring, oldGenre)
+	case !wasPublic && isPublic:
+		return addTrackToPublicLists(ctx, trackMeta, trackIDstring)
+	case !isPublic:
+		return nil
+	}
+
+	if newGenre == "" || newGenre == oldGenre {
+		return nil
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.LRem(ctx, oldGenre, 0, "track"+trackIDstring)
+	pipe.LPush(ctx, newGenre, "track"+trackIDstring)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
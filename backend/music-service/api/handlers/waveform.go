@@ -0,0 +1,216 @@
+package main
+
+import (
+	"aiartistprod/backend/storage"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"music-service/api/proto/gen"
+	"os/exec"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	// Частота декодирования для волны: форме пиков большего не нужно
+	waveformSampleRate = 8000
+	// Базовая детализация - 100 пар min/max в секунду, из нее собираются все разрешения
+	waveformSamplesPerPeak = 80
+)
+
+// waveformResolutions количество пар min/max на весь трек в каждом сохраняемом разрешении
+var waveformResolutions = []int{256, 1024, 4096}
+
+// waveformData волна трека. Peaks хранит чередующиеся min,max в диапазоне int8 (-128..127)
+type waveformData struct {
+	Duration float64           `json:"duration"`
+	Peaks    map[string][]int8 `json:"peaks"`
+}
+
+// waveformPath волна версии звука трека, у версии 0 имя без суффикса
+func waveformPath(username, trackID string, version int) string {
+	name := username + "-" + trackID
+	if version > 0 {
+		name += "-v" + strconv.Itoa(version)
+	}
+	return path.Join("waveforms", username, name+".json")
+}
+
+// GenerateWaveform декодирует трек в моно PCM и сохраняет пики min/max во всех waveformResolutions
+func GenerateWaveform(audioPath, username, trackID string, version int) error {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", audioPath,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(waveformSampleRate),
+		"-f", "s16le",
+		"pipe:1",
+	)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("ffmpeg stdout error: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg start error: %w", err)
+	}
+
+	basePeaks, samples, err := readBasePeaks(bufio.NewReader(stdout))
+	if err != nil {
+		cmd.Wait()
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg error: %w", err)
+	}
+
+	if len(basePeaks) == 0 {
+		return errors.New("no audio samples decoded")
+	}
+
+	waveform := waveformData{
+		Duration: float64(samples) / waveformSampleRate,
+		Peaks:    make(map[string][]int8, len(waveformResolutions)),
+	}
+
+	for _, resolution := range waveformResolutions {
+		waveform.Peaks[strconv.Itoa(resolution)] = downsamplePeaks(basePeaks, resolution)
+	}
+
+	data, err := json.Marshal(waveform)
+	if err != nil {
+		return fmt.Errorf("waveform encoding error: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key := waveformPath(username, trackID, version)
+	return blobStorage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), storage.ContentType(key))
+}
+
+// readBasePeaks читает s16le поток и возвращает пары min/max по waveformSamplesPerPeak сэмплов
+func readBasePeaks(r io.Reader) ([][2]int16, int, error) {
+	var peaks [][2]int16
+	var sample int16
+	samples := 0
+	current := [2]int16{math.MaxInt16, math.MinInt16}
+
+	for {
+		err := binary.Read(r, binary.LittleEndian, &sample)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("pcm reading error: %w", err)
+		}
+
+		current[0] = min(current[0], sample)
+		current[1] = max(current[1], sample)
+		samples++
+
+		if samples%waveformSamplesPerPeak == 0 {
+			peaks = append(peaks, current)
+			current = [2]int16{math.MaxInt16, math.MinInt16}
+		}
+	}
+
+	if samples%waveformSamplesPerPeak != 0 {
+		peaks = append(peaks, current)
+	}
+
+	return peaks, samples, nil
+}
+
+// downsamplePeaks сводит базовые пики к resolution пар и масштабирует их до int8
+func downsamplePeaks(basePeaks [][2]int16, resolution int) []int8 {
+	if resolution > len(basePeaks) {
+		resolution = len(basePeaks)
+	}
+
+	result := make([]int8, 0, resolution*2)
+	for i := 0; i < resolution; i++ {
+		from := i * len(basePeaks) / resolution
+		to := (i + 1) * len(basePeaks) / resolution
+
+		low, high := int16(math.MaxInt16), int16(math.MinInt16)
+		for _, peak := range basePeaks[from:to] {
+			low = min(low, peak[0])
+			high = max(high, peak[1])
+		}
+
+		result = append(result, int8(low>>8), int8(high>>8))
+	}
+
+	return result
+}
+
+func (s *MusicServiceServer) GetWaveform(ctx context.Context, req *gen.GetWaveformRequest) (*gen.GetWaveformResponse, error) {
+	trackIDstring := strconv.FormatInt(int64(req.GetTrackId()), 10)
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	trackMeta, err := s.trackMeta(ctx, trackIDstring)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorizeListener(trackMeta, trackIDstring, req.GetListener(), false); err != nil {
+		return nil, err
+	}
+
+	owner := trackMeta["owner"]
+	version, _ := strconv.Atoi(trackMeta["audioVersion"])
+
+	data, _, err := storage.ReadAll(ctx, blobStorage, waveformPath(owner, trackIDstring, version))
+	if err != nil {
+		logging.Printf("ошибка чтения волны трека %s: %v", trackIDstring, err)
+		return nil, fmt.Errorf("волна трека %s не найдена", trackIDstring)
+	}
+
+	var waveform waveformData
+	if err := json.Unmarshal(data, &waveform); err != nil {
+		logging.Printf("ошибка чтения волны трека %s: %v", trackIDstring, err)
+		return nil, fmt.Errorf("ошибка чтения волны трека %s", trackIDstring)
+	}
+
+	// Отдаем ближайшее сохраненное разрешение не меньше запрошенного, по умолчанию - наибольшее
+	resolution := waveformResolutions[len(waveformResolutions)-1]
+	for _, available := range waveformResolutions {
+		if int(req.GetResolution()) > 0 && available >= int(req.GetResolution()) {
+			resolution = available
+			break
+		}
+	}
+
+	peaksInt8 := waveform.Peaks[strconv.Itoa(resolution)]
+	peaks := make([]int32, len(peaksInt8))
+	for i, v := range peaksInt8 {
+		peaks[i] = int32(v)
+	}
+
+	availableResolutions := make([]int32, len(waveformResolutions))
+	for i, v := range waveformResolutions {
+		availableResolutions[i] = int32(v)
+	}
+
+	return &gen.GetWaveformResponse{
+		TrackID:              req.GetTrackId(),
+		Resolution:           int32(resolution),
+		Duration:             waveform.Duration,
+		Peaks:                peaks,
+		AvailableResolutions: availableResolutions,
+	}, nil
+}
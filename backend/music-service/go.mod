@@ -3,6 +3,7 @@ module music-service
 go 1.23.1
 
 require (
+	aiartistprod/backend/storage v0.0.0
 	github.com/lib/pq v1.10.9
 	github.com/redis/go-redis/v9 v9.7.1
 	github.com/u2takey/ffmpeg-go v0.5.0
@@ -21,3 +22,5 @@ require (
 	golang.org/x/text v0.21.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241202173237-19429a94021a // indirect
 )
+
+replace aiartistprod/backend/storage => ../storage
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// FSStorage хранит объекты файлами в локальной директории, ключ - относительный путь
+type FSStorage struct {
+	root string
+}
+
+func NewFSStorage(root string) *FSStorage {
+	return &FSStorage{root: root}
+}
+
+// path переводит ключ в путь внутри root. Clean от "/" отрезает попытки выйти выше корня через ".."
+func (s *FSStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put пишет во временный файл и переименовывает его, чтобы читатели не видели недописанный объект
+func (s *FSStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	filePath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), ".put_*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmpFile, r)
+	if errClose := tmpFile.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (s *FSStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if fileInfo.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+
+	return file, &ObjectInfo{Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+func (s *FSStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fileInfo, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fileInfo.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+func (s *FSStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FSStorage) List(ctx context.Context, dir string) ([]string, error) {
+	var keys []string
+	root := s.path(dir)
+
+	err := filepath.WalkDir(root, func(filePath string, entry os.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, path.Join(dir, filepath.ToSlash(rel)))
+		return nil
+	})
+
+	return keys, err
+}
+
+func (s *FSStorage) DeleteDir(ctx context.Context, dir string) error {
+	return os.RemoveAll(s.path(dir))
+}
@@ -0,0 +1,3 @@
+module aiartistprod/backend/storage
+
+go 1.23.1
@@ -0,0 +1,128 @@
+// Package storage общее хранилище медиафайлов music-service и auth-service. Модуль подключается
+// в оба сервиса через replace на ../storage
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound объекта с таким ключом нет в хранилище
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo сведения об объекте, общие для всех реализаций
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage хранилище медиафайлов. Ключи - пути через "/" от корня хранилища:
+// songs/<user>/<user>-<id>/..., pictures/<user>/..., waveforms/<user>/...
+// Одно и то же хранилище должны видеть music-service (пишет) и auth-service (отдает клиентам)
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// List возвращает ключи всех объектов внутри dir, включая вложенные
+	List(ctx context.Context, dir string) ([]string, error)
+	// DeleteDir удаляет все объекты внутри dir
+	DeleteDir(ctx context.Context, dir string) error
+}
+
+// FromEnv выбирает хранилище по STORAGE_BACKEND: fs (по умолчанию) или s3.
+// Для fs корень берется из STORAGE_FS_ROOT, иначе defaultRoot.
+// Для s3 нужны S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY, S3_SECRET_KEY и необязательный S3_REGION
+func FromEnv(defaultRoot string) (Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "fs":
+		root := os.Getenv("STORAGE_FS_ROOT")
+		if root == "" {
+			root = defaultRoot
+		}
+		return NewFSStorage(root), nil
+
+	case "s3":
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return NewS3Storage(os.Getenv("S3_ENDPOINT"), region, os.Getenv("S3_BUCKET"), os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// ReadAll читает объект целиком. Подходит для плейлистов, обложек и прочих небольших файлов
+func ReadAll(ctx context.Context, s Storage, key string) ([]byte, *ObjectInfo, error) {
+	body, info, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s error: %w", key, err)
+	}
+
+	return data, info, nil
+}
+
+// PutFile загружает локальный файл под ключом key
+func PutFile(ctx context.Context, s Storage, key, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, key, file, fileInfo.Size(), ContentType(key))
+}
+
+// PutDir загружает все файлы локальной директории под префиксом dir, сохраняя вложенность
+func PutDir(ctx context.Context, s Storage, dir, localDir string) error {
+	return filepath.WalkDir(localDir, func(localPath string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+
+		return PutFile(ctx, s, path.Join(dir, filepath.ToSlash(rel)), localPath)
+	})
+}
+
+// ContentType тип содержимого по расширению ключа
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s", ".mp4":
+		return "audio/mp4"
+	case ".jpeg", ".jpg":
+		return "image/jpeg"
+	case ".json":
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
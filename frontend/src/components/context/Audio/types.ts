@@ -3,6 +3,7 @@ import type { MutableRefObject, ReactNode } from 'react'
 interface Track {
 	track_id: string
 	track_picture?: string
+	picture_url?: string
 	title: string
 	artist_name?: string
 	duration: number
@@ -66,7 +66,7 @@ export const MiniPlayer: React.FC<MiniPlayerProps> = () => {
 		title: '',
 		artist_name: '',
 		duration: 0,
-		track_picture: '',
+		picture_url: '',
 		id: '',
 	}
 
@@ -177,8 +177,8 @@ export const MiniPlayer: React.FC<MiniPlayerProps> = () => {
 				<div className='w-16 h-16 min-w-16 rounded-lg overflow-hidden shadow-md transition-transform duration-300 group'>
 					<img
 						src={
-							track.track_picture
-								? `data:image/jpeg;base64,${track.track_picture}`
+							track.picture_url
+								? `http://localhost:8080${track.picture_url}`
 								: defaultImage
 						}
 						alt='Now playing'
@@ -14,6 +14,7 @@ interface Track {
 	artist_name: string
 	duration: number
 	track_picture?: string
+	picture_url?: string
 }
 
 interface PlaylistSelectorProps {
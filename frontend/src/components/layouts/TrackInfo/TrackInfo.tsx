@@ -12,6 +12,7 @@ type Track = {
 	release_year?: number
 	likes?: number
 	track_picture?: string
+	picture_url?: string
 }
 
 type TrackInfoPanelProps = {
@@ -50,8 +51,8 @@ export const TrackInfo: React.FC<TrackInfoPanelProps> = ({
 			<div className='flex items-center mb-4'>
 				<img
 					src={
-						track.track_picture
-							? `data:image/jpeg;base64,${track.track_picture}`
+						track.picture_url
+							? `http://localhost:8080${track.picture_url}`
 							: defaultImage
 					}
 					alt='Track cover'
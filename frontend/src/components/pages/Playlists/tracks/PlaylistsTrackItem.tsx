@@ -60,8 +60,8 @@ export const PlaylistsTrackItem = ({
 				<div className='relative w-14 h-14 mr-4 rounded-md overflow-hidden'>
 					<img
 						src={
-							track.track_picture
-								? `data:image/jpeg;base64,${track.track_picture}`
+							track.picture_url
+								? `http://localhost:8080${track.picture_url}`
 								: 'https://via.placeholder.com/100'
 						}
 						alt='Обложка трека'
@@ -11,7 +11,8 @@ interface Track {
 	owner: string
 	likes: number
 	plays: number
-	track_picture: string
+	track_picture?: string
+	picture_url?: string
 	track_id: number
 }
 
@@ -4,6 +4,7 @@ export interface Track {
 	artist: string
 	plays: number
 	duration: number
+	picture_url?: string
 }
 
 export interface TrackItemProps {
@@ -95,15 +95,62 @@ export const UploadTrack: React.FC<UploadTrackProps> = ({
 		fetchUserData()
 	}, [fetchUserData])
 
+	// Теги файла (ID3 и т.п.) заполняют только пустые поля, введенное пользователем не трогаем
+	const prefillFromTags = useCallback(
+		async (file: File) => {
+			if (!token) return
+
+			try {
+				const formData = new FormData()
+				formData.append('track_file', file)
+
+				const response = await fetch('http://localhost:8080/probeupload', {
+					method: 'POST',
+					headers: { Authorization: `Bearer ${token}` },
+					credentials: 'include',
+					body: formData,
+				})
+				if (!response.ok) return
+
+				const tags = await response.json()
+				setTrackMeta(prev => ({
+					...prev,
+					title: prev.title || tags.title || '',
+					genre: prev.genre || tags.genre || '',
+					album_name: prev.album_name || tags.album_name || '',
+					description: prev.description || tags.description || '',
+					release_year: tags.release_year || prev.release_year,
+				}))
+
+				if (tags.track_picture) {
+					const bytes = Uint8Array.from(atob(tags.track_picture), c =>
+						c.charCodeAt(0)
+					)
+					setCoverFile(
+						prev =>
+							prev || new File([bytes], 'cover.jpeg', { type: 'image/jpeg' })
+					)
+					setPreviewCover(
+						prev => prev || `data:image/jpeg;base64,${tags.track_picture}`
+					)
+				}
+			} catch {
+				// без тегов форма просто остается пустой
+			}
+		},
+		[token]
+	)
+
 	const handleTrackChange = useCallback(
 		(e: React.ChangeEvent<HTMLInputElement>) => {
 			const file = e.target.files?.[0]
 			if (file && file.type.startsWith('audio/')) {
 				setTrackFile(file)
 				setCurrentStep(2)
+				prefillFromTags(file)
 			}
 		},
-		[]
+		[prefillFromTags]
 	)
 
 	const handleCoverChange = useCallback(
@@ -182,9 +229,9 @@ export const UploadTrack: React.FC<UploadTrackProps> = ({
 
 			try {
 				const formData = new FormData()
-				formData.append('track_file', trackFile)
-				formData.append('picture_file', coverFile)
 				formData.append('meta_data', JSON.stringify(trackMeta))
+				formData.append('picture_file', coverFile)
+				formData.append('track_file', trackFile)
 
 				const response = await fetch('http://localhost:8080/uploadmusicsend', {
 					method: 'POST',